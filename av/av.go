@@ -5,7 +5,12 @@ import (
 	"time"
 
 	"github.com/nareix/joy5/codec/aac"
+	"github.com/nareix/joy5/codec/av1"
+	"github.com/nareix/joy5/codec/g711"
 	"github.com/nareix/joy5/codec/h264"
+	"github.com/nareix/joy5/codec/h265"
+	"github.com/nareix/joy5/codec/opus"
+	"github.com/nareix/joy5/codec/vp9"
 )
 
 const (
@@ -15,6 +20,18 @@ const (
 	H264SPSPPSNALU
 	AACDecoderConfig
 	Metadata
+	H265
+	H265DecoderConfig
+	AV1
+	AV1DecoderConfig
+	VP9
+	VP9DecoderConfig
+	Opus
+	OpusConfig
+	MP3
+	ALAW
+	MULAW
+	Speex
 )
 
 var PacketTypeString = map[int]string{
@@ -24,10 +41,23 @@ var PacketTypeString = map[int]string{
 	H264SPSPPSNALU:    "H264SPSPPSNALU",
 	AACDecoderConfig:  "AACDecoderConfig",
 	Metadata:          "Metadata",
+	H265:              "H265",
+	H265DecoderConfig: "H265DecoderConfig",
+	AV1:               "AV1",
+	AV1DecoderConfig:  "AV1DecoderConfig",
+	VP9:               "VP9",
+	VP9DecoderConfig:  "VP9DecoderConfig",
+	Opus:              "Opus",
+	OpusConfig:        "OpusConfig",
+	MP3:               "MP3",
+	ALAW:              "ALAW",
+	MULAW:             "MULAW",
+	Speex:             "Speex",
 }
 
 type Packet struct {
 	Type       int
+	Idx        int // track index, 0 unless the container has several tracks of a kind
 	IsKeyFrame bool
 	CTime      time.Duration
 	Time       time.Duration
@@ -37,6 +67,11 @@ type Packet struct {
 	Metadata   []byte
 	AAC        *aac.Codec
 	H264       *h264.Codec
+	H265       *h265.Codec
+	AV1        *av1.Codec
+	VP9        *vp9.Codec
+	Opus       *opus.Codec
+	G711       *g711.Codec
 }
 
 func (p Packet) String() string {
@@ -48,6 +83,10 @@ func (p Packet) String() string {
 	}
 	ret += typeStr
 
+	if p.Idx != 0 {
+		ret += fmt.Sprintf(" #%d", p.Idx)
+	}
+
 	if p.IsKeyFrame {
 		ret += " K"
 	}
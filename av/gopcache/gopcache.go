@@ -0,0 +1,246 @@
+package gopcache
+
+import (
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/h264"
+)
+
+// Snapshot is an immutable view of the cache, safe to share between
+// goroutines.
+type Snapshot struct {
+	Pkts []av.Packet
+	Idx  int // number of packets put before this snapshot, including Pkts
+	keys []int
+}
+
+// LastKeyFrame returns packets from the latest keyframe on. Returns all
+// packets if there is no keyframe.
+func (s *Snapshot) LastKeyFrame() []av.Packet {
+	if len(s.keys) == 0 {
+		return s.Pkts
+	}
+	return s.Pkts[s.keys[len(s.keys)-1]:]
+}
+
+func (s *Snapshot) Duration() time.Duration {
+	if len(s.Pkts) == 0 {
+		return 0
+	}
+	return s.Pkts[len(s.Pkts)-1].Time - s.Pkts[0].Time
+}
+
+// Cache keeps the latest GOPs of a stream. Put is called by a single writer,
+// readers load snapshots with Snapshot without locking.
+//
+// Whole GOPs are dropped from the front when any limit is exceeded, the
+// latest GOP is always kept. Packets before the first keyframe are dropped
+// one by one.
+type Cache struct {
+	MaxGops     int           // 0 means 1
+	MaxDuration time.Duration // 0 means no limit
+	MaxBytes    int           // 0 means no limit
+
+	pkts  []av.Packet
+	keys  []int
+	bytes int
+	idx   int
+	curst unsafe.Pointer
+}
+
+func New() *Cache {
+	return &Cache{
+		MaxGops: 1,
+	}
+}
+
+func (gc *Cache) duration() time.Duration {
+	if len(gc.pkts) == 0 {
+		return 0
+	}
+	return gc.pkts[len(gc.pkts)-1].Time - gc.pkts[0].Time
+}
+
+func (gc *Cache) exceeded() bool {
+	maxgops := gc.MaxGops
+	if maxgops <= 0 {
+		maxgops = 1
+	}
+	if len(gc.keys) > maxgops {
+		return true
+	}
+	if gc.MaxDuration > 0 && gc.duration() > gc.MaxDuration {
+		return true
+	}
+	if gc.MaxBytes > 0 && gc.bytes > gc.MaxBytes {
+		return true
+	}
+	return false
+}
+
+func (gc *Cache) drop(n int) {
+	for _, pkt := range gc.pkts[:n] {
+		gc.bytes -= len(pkt.Data)
+	}
+	gc.pkts = gc.pkts[n:]
+	keys := make([]int, 0, len(gc.keys))
+	for _, k := range gc.keys {
+		if k >= n {
+			keys = append(keys, k-n)
+		}
+	}
+	gc.keys = keys
+}
+
+func (gc *Cache) trim() {
+	for len(gc.pkts) > 0 && gc.exceeded() {
+		switch {
+		case len(gc.keys) == 0:
+			gc.drop(1)
+		case gc.keys[0] > 0:
+			gc.drop(gc.keys[0])
+		case len(gc.keys) > 1:
+			gc.drop(gc.keys[1])
+		default:
+			return
+		}
+	}
+}
+
+func (gc *Cache) Put(pkt av.Packet) {
+	if pkt.IsKeyFrame {
+		gc.keys = append(gc.keys, len(gc.pkts))
+	}
+	gc.pkts = append(gc.pkts, pkt)
+	gc.bytes += len(pkt.Data)
+	gc.idx++
+	gc.trim()
+
+	// appending never touches packets visible in older snapshots, keys is
+	// copied because drop rewrites it
+	st := &Snapshot{
+		Pkts: gc.pkts,
+		Idx:  gc.idx,
+		keys: append([]int(nil), gc.keys...),
+	}
+	atomic.StorePointer(&gc.curst, unsafe.Pointer(st))
+}
+
+// Snapshot returns the current snapshot, nil before the first Put.
+func (gc *Cache) Snapshot() *Snapshot {
+	return (*Snapshot)(atomic.LoadPointer(&gc.curst))
+}
+
+// Cursor tracks the position of a reader. The zero value starts at the
+// oldest packet in the cache.
+//
+// A slow reader gets behind the latest packet. When it is more than MaxLag
+// behind, it skips forward to the latest keyframe, config and metadata
+// packets on the way are still returned. When it is more than
+// NonRefLag behind, H.264 frames no other frame refers to are dropped
+// first. Zero disables either.
+type Cursor struct {
+	StartAtLastKeyFrame bool // start a new reader at the latest keyframe
+	MaxLag              time.Duration
+	NonRefLag           time.Duration
+
+	// Dropped counts packets removed from the cache before the reader got
+	// them.
+	Dropped int
+	// Skipped counts packets skipped by MaxLag, NonRefDropped frames dropped
+	// by NonRefLag.
+	Skipped       int
+	NonRefDropped int
+
+	lastidx int
+	started bool
+}
+
+// Advance returns packets put since the last call.
+func (rc *Cursor) Advance(cur *Snapshot) []av.Packet {
+	return rc.drop(rc.advance(cur))
+}
+
+func (rc *Cursor) advance(cur *Snapshot) []av.Packet {
+	if !rc.started {
+		rc.started = true
+		rc.lastidx = cur.Idx
+		if rc.StartAtLastKeyFrame {
+			return cur.LastKeyFrame()
+		}
+		return cur.Pkts
+	}
+
+	lastidx := rc.lastidx
+	rc.lastidx = cur.Idx
+	if diff := cur.Idx - lastidx; diff <= len(cur.Pkts) {
+		return cur.Pkts[len(cur.Pkts)-diff:]
+	} else {
+		rc.Dropped += diff - len(cur.Pkts)
+		return cur.Pkts
+	}
+}
+
+func (rc *Cursor) drop(pkts []av.Packet) []av.Packet {
+	if len(pkts) == 0 {
+		return pkts
+	}
+	lag := pkts[len(pkts)-1].Time - pkts[0].Time
+
+	if rc.MaxLag > 0 && lag > rc.MaxLag {
+		for i := len(pkts) - 1; i > 0; i-- {
+			if pkts[i].IsKeyFrame {
+				// configs and metadata are kept, frames after them may need
+				// them
+				out := []av.Packet{}
+				for _, pkt := range pkts[:i] {
+					if av.IsConfig(pkt.Type) || pkt.Type == av.Metadata {
+						out = append(out, pkt)
+					} else {
+						rc.Skipped++
+					}
+				}
+				lag = pkts[len(pkts)-1].Time - pkts[i].Time
+				pkts = append(out, pkts[i:]...)
+				break
+			}
+		}
+	}
+
+	if rc.NonRefLag > 0 && lag > rc.NonRefLag {
+		// pkts is shared with other readers
+		out := make([]av.Packet, 0, len(pkts))
+		for _, pkt := range pkts {
+			if pkt.Type == av.H264 && !pkt.IsKeyFrame && h264.IsNonRefFrame(pkt.Data) {
+				rc.NonRefDropped++
+				continue
+			}
+			out = append(out, pkt)
+		}
+		pkts = out
+	}
+
+	return pkts
+}
+
+// Lag returns how far the reader is behind the snapshot, in packets and in
+// packet time.
+func (rc *Cursor) Lag(cur *Snapshot) (n int, dur time.Duration) {
+	if !rc.started {
+		return
+	}
+	n = cur.Idx - rc.lastidx
+	if n <= 0 || len(cur.Pkts) == 0 {
+		n = 0
+		return
+	}
+	first := len(cur.Pkts) - n
+	if first < 0 {
+		first = 0
+	}
+	dur = cur.Pkts[len(cur.Pkts)-1].Time - cur.Pkts[first].Time
+	return
+}
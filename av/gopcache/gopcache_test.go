@@ -0,0 +1,203 @@
+package gopcache
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy5/av"
+)
+
+func testPacket(i int, gop int) av.Packet {
+	return av.Packet{
+		Type:       av.H264,
+		Time:       time.Duration(i) * time.Millisecond * 40,
+		IsKeyFrame: i%gop == 0,
+		Data:       make([]byte, 100),
+	}
+}
+
+func TestRetention(t *testing.T) {
+	tests := []struct {
+		gc   *Cache
+		want int
+	}{
+		{New(), 5},
+		{&Cache{MaxGops: 3}, 25},
+		{&Cache{MaxGops: 100, MaxDuration: time.Second}, 25},
+		{&Cache{MaxGops: 100, MaxBytes: 2500}, 25},
+		{&Cache{MaxGops: 100, MaxBytes: 50}, 5},
+	}
+
+	for i, test := range tests {
+		for j := 0; j < 105; j++ {
+			test.gc.Put(testPacket(j, 10))
+		}
+		st := test.gc.Snapshot()
+		if !st.Pkts[0].IsKeyFrame {
+			t.Errorf("#%d: snapshot starts without keyframe", i)
+		}
+		if n := len(st.Pkts); n != test.want {
+			t.Errorf("#%d: got %d packets want %d", i, n, test.want)
+		}
+		if st.Idx != 105 {
+			t.Errorf("#%d: idx %d", i, st.Idx)
+		}
+	}
+}
+
+func TestNoKeyFrame(t *testing.T) {
+	gc := &Cache{MaxDuration: time.Second}
+	for j := 0; j < 100; j++ {
+		gc.Put(testPacket(j+1, 1000))
+	}
+	st := gc.Snapshot()
+	if st.Duration() > time.Second {
+		t.Errorf("duration %v", st.Duration())
+	}
+}
+
+func TestCursor(t *testing.T) {
+	gc := New()
+	for j := 0; j < 15; j++ {
+		gc.Put(testPacket(j, 10))
+	}
+
+	rc := &Cursor{}
+	if n := len(rc.Advance(gc.Snapshot())); n != 5 {
+		t.Errorf("got %d", n)
+	}
+	rc2 := &Cursor{StartAtLastKeyFrame: true}
+	gc.Put(testPacket(15, 10))
+	if n := len(rc2.Advance(gc.Snapshot())); n != 6 {
+		t.Errorf("got %d", n)
+	}
+
+	for j := 16; j < 35; j++ {
+		gc.Put(testPacket(j, 10))
+	}
+	st := gc.Snapshot()
+	if n, dur := rc.Lag(st); n != 20 || dur != 4*40*time.Millisecond {
+		t.Errorf("lag %d %v", n, dur)
+	}
+	pkts := rc.Advance(st)
+	if len(pkts) != 5 || rc.Dropped != 15 {
+		t.Errorf("got %d dropped %d", len(pkts), rc.Dropped)
+	}
+	if n, _ := rc.Lag(st); n != 0 {
+		t.Errorf("lag %d", n)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	const total = 20000
+	gc := &Cache{MaxGops: 2}
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	for r := 0; r < 8; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rc := &Cursor{}
+			var st *Snapshot
+			for st == nil {
+				st = gc.Snapshot()
+			}
+			last := -1
+			got := 0
+			for {
+				pkts := rc.Advance(st)
+				for _, pkt := range pkts {
+					i := int(pkt.Time / (time.Millisecond * 40))
+					if i <= last {
+						t.Errorf("out of order %d after %d", i, last)
+						return
+					}
+					last = i
+					got++
+				}
+				if st.Idx == total {
+					break
+				}
+				st = gc.Snapshot()
+			}
+			if last != total-1 {
+				t.Errorf("last %d", last)
+			}
+			if first := total - got - rc.Dropped; first < 0 || first > st.Idx {
+				t.Errorf("got %d dropped %d", got, rc.Dropped)
+			}
+		}()
+	}
+
+	go func() {
+		for j := 0; j < total; j++ {
+			gc.Put(testPacket(j, 25))
+		}
+		close(done)
+	}()
+
+	<-done
+	wg.Wait()
+}
+
+func TestCursorDrop(t *testing.T) {
+	gc := &Cache{MaxGops: 10}
+	rc := &Cursor{MaxLag: time.Second, NonRefLag: 200 * time.Millisecond}
+	gc.Put(testPacket(0, 10))
+	rc.Advance(gc.Snapshot())
+
+	for j := 1; j < 45; j++ {
+		pkt := testPacket(j, 10)
+		if j%2 == 1 {
+			// nal_ref_idc 0
+			pkt.Data = []byte{0, 0, 0, 2, 0x01, 0}
+		} else {
+			pkt.Data = []byte{0, 0, 0, 2, 0x21, 0}
+		}
+		gc.Put(pkt)
+	}
+
+	pkts := rc.Advance(gc.Snapshot())
+	if rc.Skipped != 39 || !pkts[0].IsKeyFrame {
+		t.Errorf("skipped %d", rc.Skipped)
+	}
+	if rc.NonRefDropped != 0 || len(pkts) != 5 {
+		t.Errorf("nonref %d got %d", rc.NonRefDropped, len(pkts))
+	}
+
+	for j := 45; j < 55; j++ {
+		pkt := testPacket(j, 100)
+		pkt.Data = []byte{0, 0, 0, 2, 0x01, 0}
+		gc.Put(pkt)
+	}
+	pkts = rc.Advance(gc.Snapshot())
+	if rc.NonRefDropped != 10 || len(pkts) != 0 {
+		t.Errorf("nonref %d got %d", rc.NonRefDropped, len(pkts))
+	}
+}
+
+func TestCursorKeepsConfig(t *testing.T) {
+	gc := &Cache{MaxGops: 10}
+	rc := &Cursor{MaxLag: time.Second}
+	gc.Put(testPacket(0, 10))
+	rc.Advance(gc.Snapshot())
+
+	for j := 1; j < 45; j++ {
+		if j == 15 {
+			// new sequence header in the middle of the skipped part
+			gc.Put(av.Packet{Type: av.H264DecoderConfig, Time: testPacket(j, 10).Time})
+		}
+		gc.Put(testPacket(j, 10))
+	}
+
+	pkts := rc.Advance(gc.Snapshot())
+	if rc.Skipped != 39 || len(pkts) != 6 {
+		t.Fatalf("skipped %d got %d", rc.Skipped, len(pkts))
+	}
+	if pkts[0].Type != av.H264DecoderConfig || !pkts[1].IsKeyFrame {
+		t.Fatalf("got %v %v", pkts[0], pkts[1])
+	}
+}
@@ -0,0 +1,101 @@
+package pktop
+
+import (
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/aac"
+)
+
+const DefaultAACTolerance = time.Millisecond * 100
+
+type aacClock struct {
+	config  aac.MPEG4AudioConfig
+	anchor  time.Duration
+	samples int64
+	started bool
+}
+
+func (c *aacClock) time() time.Duration {
+	rate := int64(c.config.SampleRate)
+	sec := c.samples / rate
+	rem := c.samples % rate
+	return c.anchor + time.Duration(sec)*time.Second + time.Duration(rem)*time.Second/time.Duration(rate)
+}
+
+// AACTimestamp regenerates AAC packet times from a running sample count at
+// the core sample rate, so HE-AAC and 960 sample frames get the right
+// duration. When the regenerated time drifts more than Tolerance from the
+// packet time, the clock is anchored again at the packet time.
+type AACTimestamp struct {
+	Tolerance time.Duration
+
+	Reanchors int
+
+	clocks map[int]*aacClock
+}
+
+func NewAACTimestamp() *AACTimestamp {
+	return &AACTimestamp{
+		Tolerance: DefaultAACTolerance,
+		clocks:    map[int]*aacClock{},
+	}
+}
+
+func (f *AACTimestamp) do(pkt *av.Packet) {
+	c := f.clocks[pkt.Idx]
+	if c == nil {
+		c = &aacClock{}
+		f.clocks[pkt.Idx] = c
+	}
+
+	switch pkt.Type {
+	case av.AACDecoderConfig:
+		if config, err := aac.ParseMPEG4AudioConfigBytes(pkt.Data); err == nil {
+			if config != c.config {
+				c.config = config
+				c.started = false
+			}
+		}
+		return
+
+	case av.AAC:
+	default:
+		return
+	}
+
+	if pkt.AAC != nil && pkt.AAC.Config != c.config {
+		c.config = pkt.AAC.Config
+		c.started = false
+	}
+	if c.config.SampleRate == 0 {
+		return
+	}
+
+	if c.started {
+		t := c.time()
+		if diff := pkt.Time - t; diff > f.Tolerance || diff < -f.Tolerance {
+			c.started = false
+			f.Reanchors++
+		} else {
+			pkt.Time = t
+		}
+	}
+	if !c.started {
+		c.started = true
+		c.anchor = pkt.Time
+		c.samples = 0
+	}
+	c.samples += int64(c.config.FrameSamples())
+}
+
+func (f *AACTimestamp) Do(in []av.Packet) []av.Packet {
+	for i := range in {
+		f.do(&in[i])
+	}
+	return in
+}
+
+func (f *AACTimestamp) Flush() []av.Packet {
+	return nil
+}
@@ -0,0 +1,200 @@
+package pktop
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nareix/joy5/av"
+)
+
+// Filter transforms packets. Do may hold packets back and return them in a
+// later call, Flush is called at end of stream to drain them.
+type Filter interface {
+	Do(in []av.Packet) []av.Packet
+	Flush() []av.Packet
+}
+
+type FilterFunc func(in []av.Packet) []av.Packet
+
+func (f FilterFunc) Do(in []av.Packet) []av.Packet {
+	return f(in)
+}
+
+func (f FilterFunc) Flush() []av.Packet {
+	return nil
+}
+
+type FilterStats struct {
+	Name string
+	In   int
+	Out  int
+}
+
+func (s FilterStats) String() string {
+	return fmt.Sprintf("%s in=%d out=%d", s.Name, s.In, s.Out)
+}
+
+func filterName(f Filter) string {
+	if s, ok := f.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", f)
+}
+
+// Pipeline chains filters, output of each filter is input of the next one.
+// A Pipeline is a Filter itself.
+type Pipeline struct {
+	filters []Filter
+	stats   []FilterStats
+}
+
+func NewPipeline(filters ...Filter) *Pipeline {
+	p := &Pipeline{}
+	p.Append(filters...)
+	return p
+}
+
+func (p *Pipeline) Append(filters ...Filter) {
+	for _, f := range filters {
+		p.filters = append(p.filters, f)
+		p.stats = append(p.stats, FilterStats{Name: filterName(f)})
+	}
+}
+
+func (p *Pipeline) run(start, end int, pkts []av.Packet) []av.Packet {
+	for i := start; i < end && len(pkts) > 0; i++ {
+		p.stats[i].In += len(pkts)
+		pkts = p.filters[i].Do(pkts)
+		p.stats[i].Out += len(pkts)
+	}
+	return pkts
+}
+
+func (p *Pipeline) Do(in []av.Packet) []av.Packet {
+	return p.run(0, len(p.filters), in)
+}
+
+// Flush drains the filters in order, packets flushed by a filter still go
+// through the filters after it before they are flushed.
+func (p *Pipeline) Flush() (out []av.Packet) {
+	for i, f := range p.filters {
+		out = p.run(i, i+1, out)
+		pkts := f.Flush()
+		p.stats[i].Out += len(pkts)
+		out = append(out, pkts...)
+	}
+	return
+}
+
+func (p *Pipeline) Stats() []FilterStats {
+	return append([]FilterStats(nil), p.stats...)
+}
+
+// Reader returns packets of r through the pipeline. When r returns an error
+// the pipeline is flushed and the error is returned after the flushed
+// packets.
+func (p *Pipeline) Reader(r av.PacketReader) *Reader {
+	return &Reader{p: p, r: r}
+}
+
+type Reader struct {
+	p       *Pipeline
+	r       av.PacketReader
+	pending []av.Packet
+	err     error
+}
+
+func (r *Reader) ReadPacket() (pkt av.Packet, err error) {
+	for len(r.pending) == 0 {
+		if r.err != nil {
+			err = r.err
+			return
+		}
+		var in av.Packet
+		if in, err = r.r.ReadPacket(); err != nil {
+			r.err = err
+			err = nil
+			r.pending = r.p.Flush()
+			continue
+		}
+		r.pending = r.p.Do([]av.Packet{in})
+	}
+	pkt = r.pending[0]
+	r.pending = r.pending[1:]
+	return
+}
+
+// Writer writes packets through the pipeline to w. Call Flush at end of
+// stream.
+func (p *Pipeline) Writer(w av.PacketWriter) *Writer {
+	return &Writer{p: p, w: w}
+}
+
+type Writer struct {
+	p *Pipeline
+	w av.PacketWriter
+}
+
+func (w *Writer) write(pkts []av.Packet) (err error) {
+	for _, pkt := range pkts {
+		if err = w.w.WritePacket(pkt); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (w *Writer) WritePacket(pkt av.Packet) (err error) {
+	return w.write(w.p.Do([]av.Packet{pkt}))
+}
+
+func (w *Writer) Flush() (err error) {
+	return w.write(w.p.Flush())
+}
+
+// TimeOffset adds Offset to packet times.
+type TimeOffset struct {
+	Offset time.Duration
+}
+
+func NewTimeOffset(offset time.Duration) *TimeOffset {
+	return &TimeOffset{Offset: offset}
+}
+
+func (f *TimeOffset) Do(in []av.Packet) []av.Packet {
+	for i := range in {
+		in[i].Time += f.Offset
+	}
+	return in
+}
+
+func (f *TimeOffset) Flush() []av.Packet {
+	return nil
+}
+
+// DropTypes drops packets of the given av packet types.
+type DropTypes struct {
+	Types map[int]bool
+}
+
+func NewDropTypes(types ...int) *DropTypes {
+	f := &DropTypes{Types: map[int]bool{}}
+	for _, t := range types {
+		f.Types[t] = true
+	}
+	return f
+}
+
+func (f *DropTypes) Do(in []av.Packet) []av.Packet {
+	out := in[:0]
+	for _, pkt := range in {
+		if !f.Types[pkt.Type] {
+			out = append(out, pkt)
+		}
+	}
+	return out
+}
+
+func (f *DropTypes) Flush() []av.Packet {
+	return nil
+}
@@ -0,0 +1,177 @@
+package pktop
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy5/av"
+)
+
+// mark appends name to the data of every packet.
+func mark(name string) Filter {
+	return FilterFunc(func(in []av.Packet) []av.Packet {
+		for i := range in {
+			in[i].Data = append(in[i].Data, name...)
+		}
+		return in
+	})
+}
+
+// delay holds back the last packet until the next one or Flush.
+type delay struct {
+	held []av.Packet
+}
+
+func (f *delay) Do(in []av.Packet) (out []av.Packet) {
+	all := append(f.held, in...)
+	f.held = []av.Packet{all[len(all)-1]}
+	return all[:len(all)-1]
+}
+
+func (f *delay) Flush() (out []av.Packet) {
+	out, f.held = f.held, nil
+	return
+}
+
+type sliceReader struct {
+	pkts []av.Packet
+	err  error
+}
+
+func (r *sliceReader) ReadPacket() (pkt av.Packet, err error) {
+	if len(r.pkts) == 0 {
+		err = r.err
+		return
+	}
+	pkt = r.pkts[0]
+	r.pkts = r.pkts[1:]
+	return
+}
+
+func testPackets(types ...int) (pkts []av.Packet) {
+	for i, typ := range types {
+		pkts = append(pkts, av.Packet{Type: typ, Time: time.Duration(i) * time.Second})
+	}
+	return
+}
+
+func describe(pkts []av.Packet) (s []string) {
+	for _, pkt := range pkts {
+		s = append(s, fmt.Sprintf("%s %v %s", av.PacketTypeString[pkt.Type], pkt.Time, pkt.Data))
+	}
+	return
+}
+
+func TestPipeline(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []Filter
+		do      []string
+		flush   []string
+	}{
+		{
+			name:    "order",
+			filters: []Filter{mark("a"), mark("b")},
+			do:      []string{"H264 0s ab", "AAC 1s ab", "H264 2s ab"},
+		},
+		{
+			name:    "flushed packets go through later filters",
+			filters: []Filter{mark("a"), &delay{}, mark("b")},
+			do:      []string{"H264 0s ab", "AAC 1s ab"},
+			flush:   []string{"H264 2s ab"},
+		},
+		{
+			name:    "flush in order",
+			filters: []Filter{&delay{}, mark("a"), &delay{}},
+			do:      []string{"H264 0s a"},
+			flush:   []string{"AAC 1s a", "H264 2s a"},
+		},
+		{
+			name:    "time offset and drop",
+			filters: []Filter{NewTimeOffset(time.Second), NewDropTypes(av.AAC)},
+			do:      []string{"H264 1s ", "H264 3s "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPipeline(tt.filters...)
+			var out []av.Packet
+			for _, pkt := range testPackets(av.H264, av.AAC, av.H264) {
+				out = append(out, p.Do([]av.Packet{pkt})...)
+			}
+			if got := describe(out); fmt.Sprint(got) != fmt.Sprint(tt.do) {
+				t.Fatalf("do %q, want %q", got, tt.do)
+			}
+			if got := describe(p.Flush()); fmt.Sprint(got) != fmt.Sprint(tt.flush) {
+				t.Fatalf("flush %q, want %q", got, tt.flush)
+			}
+		})
+	}
+}
+
+func TestPipelineReader(t *testing.T) {
+	errBroken := fmt.Errorf("broken")
+	for _, srcerr := range []error{io.EOF, errBroken} {
+		p := NewPipeline(&delay{}, &delay{})
+		r := p.Reader(&sliceReader{pkts: testPackets(av.H264, av.AAC, av.H264), err: srcerr})
+
+		var out []av.Packet
+		var err error
+		for {
+			var pkt av.Packet
+			if pkt, err = r.ReadPacket(); err != nil {
+				break
+			}
+			out = append(out, pkt)
+		}
+		if err != srcerr {
+			t.Fatalf("err %v, want %v", err, srcerr)
+		}
+		// held packets come out after the source ended
+		if got := describe(out); fmt.Sprint(got) != "[H264 0s  AAC 1s  H264 2s ]" {
+			t.Fatalf("got %q", got)
+		}
+		if _, err = r.ReadPacket(); err != srcerr {
+			t.Fatalf("err %v after end, want %v", err, srcerr)
+		}
+	}
+}
+
+func TestPipelineStats(t *testing.T) {
+	p := NewPipeline(NewDropTypes(av.AAC), &delay{})
+	sw := &sliceWriter{}
+	w := p.Writer(sw)
+	for _, pkt := range testPackets(av.H264, av.AAC, av.H264, av.AAC) {
+		if err := w.WritePacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sw.pkts) != 2 {
+		t.Fatalf("wrote %d packets", len(sw.pkts))
+	}
+
+	stats := p.Stats()
+	want := []FilterStats{
+		{Name: "*pktop.DropTypes", In: 4, Out: 2},
+		{Name: "*pktop.delay", In: 2, Out: 2},
+	}
+	if fmt.Sprint(stats) != fmt.Sprint(want) {
+		t.Fatalf("stats %v, want %v", stats, want)
+	}
+}
+
+type sliceWriter struct {
+	pkts []av.Packet
+}
+
+func (w *sliceWriter) WritePacket(pkt av.Packet) error {
+	w.pkts = append(w.pkts, pkt)
+	return nil
+}
@@ -0,0 +1,141 @@
+package pktop
+
+import (
+	"container/heap"
+	"time"
+
+	"github.com/nareix/joy5/av"
+)
+
+const DefaultMaxDelay = time.Millisecond * 500
+
+type interleaveItem struct {
+	pkt av.Packet
+	key time.Duration
+	seq int
+}
+
+type interleaveHeap []interleaveItem
+
+func (h interleaveHeap) Len() int { return len(h) }
+
+func (h interleaveHeap) Less(i, j int) bool {
+	if h[i].key != h[j].key {
+		return h[i].key < h[j].key
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h interleaveHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *interleaveHeap) Push(x interface{}) { *h = append(*h, x.(interleaveItem)) }
+
+func (h *interleaveHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Interleaver reorders packets of all tracks by decode time. A packet is
+// held until every track has a packet at or after its time, a track more
+// than MaxDelay behind the newest packet is stalled and not waited for.
+//
+// Without SetStreams the tracks are not known yet, the first MaxDelay of
+// packets is held until late starting tracks show up.
+//
+// Config packets keep their position in their track: they are ordered at the
+// time of the last frame of the track, metadata at the newest time.
+type Interleaver struct {
+	MaxDelay time.Duration
+
+	h       interleaveHeap
+	seq     int
+	started bool
+	first   time.Duration
+	newest  time.Duration
+	hinted  bool
+	tracks  map[trackKey]time.Duration
+}
+
+func NewInterleaver() *Interleaver {
+	return &Interleaver{
+		MaxDelay: DefaultMaxDelay,
+		tracks:   map[trackKey]time.Duration{},
+	}
+}
+
+// SetStreams makes the interleaver wait for streams which have not sent a
+// packet yet, up to MaxDelay.
+func (f *Interleaver) SetStreams(streams []av.Stream) {
+	f.hinted = true
+	for _, s := range streams {
+		key := trackKey{s.IsVideo(), s.Idx}
+		if _, ok := f.tracks[key]; !ok {
+			f.tracks[key] = -1 << 62
+		}
+	}
+}
+
+func (f *Interleaver) push(pkt av.Packet) {
+	var key time.Duration
+	switch {
+	case pkt.Type == av.Metadata:
+		key = f.newest
+	case av.IsConfig(pkt.Type):
+		key = f.tracks[trackKey{av.IsVideo(pkt.Type), pkt.Idx}]
+		if key < 0 {
+			key = 0
+		}
+	default:
+		key = pkt.Time
+		tk := trackKey{av.IsVideo(pkt.Type), pkt.Idx}
+		if last, ok := f.tracks[tk]; !ok || key > last {
+			f.tracks[tk] = key
+		}
+		if !f.started {
+			f.started = true
+			f.first = key
+			f.newest = key
+		}
+		if key > f.newest {
+			f.newest = key
+		}
+	}
+	heap.Push(&f.h, interleaveItem{pkt: pkt, key: key, seq: f.seq})
+	f.seq++
+}
+
+func (f *Interleaver) watermark() time.Duration {
+	if !f.hinted && f.newest-f.first < f.MaxDelay {
+		return f.newest - f.MaxDelay
+	}
+	wm := f.newest
+	for _, last := range f.tracks {
+		if last < wm {
+			wm = last
+		}
+	}
+	if min := f.newest - f.MaxDelay; wm < min {
+		wm = min
+	}
+	return wm
+}
+
+func (f *Interleaver) Do(in []av.Packet) (out []av.Packet) {
+	for _, pkt := range in {
+		f.push(pkt)
+	}
+	wm := f.watermark()
+	for len(f.h) > 0 && f.h[0].key <= wm {
+		out = append(out, heap.Pop(&f.h).(interleaveItem).pkt)
+	}
+	return
+}
+
+func (f *Interleaver) Flush() (out []av.Packet) {
+	for len(f.h) > 0 {
+		out = append(out, heap.Pop(&f.h).(interleaveItem).pkt)
+	}
+	return
+}
@@ -0,0 +1,99 @@
+package pktop
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy5/av"
+)
+
+func testStreams() []av.Stream {
+	return []av.Stream{
+		{CodecData: av.CodecData{Type: av.H264}},
+		{CodecData: av.CodecData{Type: av.AAC}},
+	}
+}
+
+func times(pkts []av.Packet) (s []string) {
+	for _, pkt := range pkts {
+		kind := "a"
+		if av.IsVideo(pkt.Type) {
+			kind = "v"
+		}
+		s = append(s, fmt.Sprintf("%s%d", kind, pkt.Time/time.Millisecond))
+	}
+	return
+}
+
+func TestInterleaverWatermark(t *testing.T) {
+	f := NewInterleaver()
+	f.SetStreams(testStreams())
+
+	do := func(pkts ...av.Packet) string {
+		return fmt.Sprint(times(f.Do(pkts)))
+	}
+	v := func(t int64) av.Packet { return av.Packet{Type: av.H264, Time: ms(t)} }
+	a := func(t int64) av.Packet { return av.Packet{Type: av.AAC, Time: ms(t)} }
+
+	// audio not seen yet
+	if got := do(v(0), v(40), v(80)); got != "[]" {
+		t.Fatalf("got %s", got)
+	}
+	// released up to the audio time
+	if got := do(a(0), a(23), a(46)); got != "[v0 a0 a23 v40 a46]" {
+		t.Fatalf("got %s", got)
+	}
+	if got := do(a(69), a(92)); got != "[a69 v80]" {
+		t.Fatalf("got %s", got)
+	}
+	if got := do(v(120)); got != "[a92]" {
+		t.Fatalf("got %s", got)
+	}
+	// end of stream
+	if got := fmt.Sprint(times(f.Flush())); got != "[v120]" {
+		t.Fatalf("flush %s", got)
+	}
+}
+
+func TestInterleaverStall(t *testing.T) {
+	f := NewInterleaver()
+	f.SetStreams(testStreams())
+
+	// audio never comes, video is only held MaxDelay
+	var out []av.Packet
+	for i := int64(0); i <= 25; i++ {
+		out = append(out, f.Do([]av.Packet{{Type: av.H264, Time: ms(i * 40)}})...)
+	}
+	if len(out) != 13 || out[len(out)-1].Time != ms(480) {
+		t.Fatalf("got %v", times(out))
+	}
+
+	// late audio of a stalled track is sent right away
+	out = f.Do([]av.Packet{{Type: av.AAC, Time: ms(100)}})
+	if fmt.Sprint(times(out)) != "[a100]" {
+		t.Fatalf("got %v", times(out))
+	}
+
+	out = f.Flush()
+	if len(out) != 13 || out[0].Time != ms(520) || out[12].Time != ms(1000) {
+		t.Fatalf("flush %v", times(out))
+	}
+}
+
+func TestInterleaverConfig(t *testing.T) {
+	f := NewInterleaver()
+	f.SetStreams(testStreams())
+	out := f.Do([]av.Packet{
+		{Type: av.H264, Time: ms(0)},
+		{Type: av.H264, Time: ms(40)},
+		{Type: av.AACDecoderConfig},
+		{Type: av.AAC, Time: ms(0)},
+		{Type: av.AAC, Time: ms(50)},
+	})
+	out = append(out, f.Flush()...)
+	got := fmt.Sprint(times(out))
+	if got != "[v0 a0 a0 v40 a50]" || out[1].Type != av.AACDecoderConfig {
+		t.Fatalf("got %s", got)
+	}
+}
@@ -30,3 +30,7 @@ func (l *NativeRateLimiter) Do(in []av.Packet) []av.Packet {
 	}
 	return in
 }
+
+func (l *NativeRateLimiter) Flush() []av.Packet {
+	return nil
+}
@@ -0,0 +1,195 @@
+package pktop
+
+import (
+	"bytes"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/h264"
+)
+
+type seqhdrState struct {
+	vtype, atype int
+	vseqhdr      []byte
+	aseqhdr      []byte
+	h264         *h264.Codec
+}
+
+func getSeqhdrState(m map[int]*seqhdrState, idx int) *seqhdrState {
+	s := m[idx]
+	if s == nil {
+		s = &seqhdrState{vtype: -1, atype: -1}
+		m[idx] = s
+	}
+	return s
+}
+
+// MergeSeqhdr drops config and metadata packets and attaches them to frames
+// instead: VSeqHdr to video keyframes, ASeqHdr to audio frames, Metadata to
+// all frames. H.264 SPS/PPS sent inband in a keyframe replace VSeqHdr when
+// they differ from the current ones, e.g. on a resolution switch.
+type MergeSeqhdr struct {
+	metadata []byte
+	tracks   map[int]*seqhdrState
+}
+
+func NewMergeSeqhdr() *MergeSeqhdr {
+	return &MergeSeqhdr{
+		tracks: map[int]*seqhdrState{},
+	}
+}
+
+func (m *MergeSeqhdr) inbandH264(s *seqhdrState, data []byte) {
+	nalus, _ := h264.SplitNALUs(data)
+	h := h264.NewCodec()
+	for _, nalu := range nalus {
+		switch h264.NALUType(nalu) {
+		case h264.NALU_SPS, h264.NALU_PPS:
+			h.AddSPSPPS(nalu)
+		}
+	}
+	if len(h.SPS) == 0 || len(h.PPS) == 0 {
+		return
+	}
+	if s.h264 != nil && s.h264.Equal(*h) {
+		return
+	}
+	n := 0
+	h.ToConfig(nil, &n)
+	b := make([]byte, n)
+	n = 0
+	h.ToConfig(b, &n)
+	s.vseqhdr = b
+	s.h264 = h
+}
+
+func (m *MergeSeqhdr) do(pkt av.Packet) (out av.Packet, ok bool) {
+	if pkt.Type == av.Metadata {
+		m.metadata = pkt.Data
+		return
+	}
+
+	s := getSeqhdrState(m.tracks, pkt.Idx)
+
+	if av.IsConfig(pkt.Type) {
+		hdr := append([]byte(nil), pkt.Data...)
+		if av.IsVideo(pkt.Type) {
+			s.vtype = av.FrameType[pkt.Type]
+			s.vseqhdr = hdr
+			s.h264 = nil
+			if pkt.Type == av.H264DecoderConfig {
+				s.h264, _ = h264.FromDecoderConfig(hdr)
+			}
+		} else {
+			s.atype = av.FrameType[pkt.Type]
+			s.aseqhdr = hdr
+		}
+		return
+	}
+
+	// a config of another codec is not attached on codec switches
+	pkt.Metadata = m.metadata
+	if av.IsVideo(pkt.Type) {
+		if pkt.IsKeyFrame {
+			if pkt.Type == av.H264 {
+				if s.vtype != av.H264 {
+					s.vtype, s.vseqhdr, s.h264 = av.H264, nil, nil
+				}
+				m.inbandH264(s, pkt.Data)
+			}
+			if s.vtype == pkt.Type {
+				pkt.VSeqHdr = s.vseqhdr
+			}
+		}
+	} else if s.atype == pkt.Type {
+		pkt.ASeqHdr = s.aseqhdr
+	}
+	return pkt, true
+}
+
+func (m *MergeSeqhdr) Do(in []av.Packet) []av.Packet {
+	out := in[:0]
+	for _, pkt := range in {
+		if pkt, ok := m.do(pkt); ok {
+			out = append(out, pkt)
+		}
+	}
+	return out
+}
+
+func (m *MergeSeqhdr) Flush() []av.Packet {
+	return nil
+}
+
+// SplitSeqhdr is the reverse of MergeSeqhdr: it sends metadata and config
+// packets before frames when they change and drops the attached copies. A
+// codec switch always sends the config, H.264 and H.265 configs are
+// compared with Codec.Equal so a repacked but identical config is not sent
+// again.
+type SplitSeqhdr struct {
+	metadata []byte
+	tracks   map[int]*seqhdrState
+}
+
+func NewSplitSeqhdr() *SplitSeqhdr {
+	return &SplitSeqhdr{
+		tracks: map[int]*seqhdrState{},
+	}
+}
+
+func (s *SplitSeqhdr) do(pkt av.Packet) (out []av.Packet) {
+	if av.IsConfig(pkt.Type) || pkt.Type == av.Metadata {
+		return
+	}
+
+	if pkt.Metadata != nil && bytes.Compare(s.metadata, pkt.Metadata) != 0 {
+		out = append(out, av.Packet{
+			Type: av.Metadata,
+			Data: pkt.Metadata,
+			Time: pkt.Time,
+		})
+		s.metadata = pkt.Metadata
+	}
+
+	st := getSeqhdrState(s.tracks, pkt.Idx)
+	cfgtyp, hascfg := av.ConfigType[pkt.Type]
+	cfg := pkt
+	cfg.Type = cfgtyp
+	cfg.IsKeyFrame = false
+	cfg.CTime = 0
+	cfg.VSeqHdr, cfg.ASeqHdr, cfg.Metadata = nil, nil, nil
+
+	if av.IsVideo(pkt.Type) {
+		if hascfg && pkt.IsKeyFrame && pkt.VSeqHdr != nil {
+			if st.vtype != pkt.Type || !av.ConfigEqual(cfgtyp, st.vseqhdr, pkt.VSeqHdr) {
+				cfg.Data = pkt.VSeqHdr
+				out = append(out, cfg)
+			}
+			st.vseqhdr = pkt.VSeqHdr
+		}
+		st.vtype = pkt.Type
+	} else {
+		if hascfg && pkt.ASeqHdr != nil {
+			if st.atype != pkt.Type || bytes.Compare(st.aseqhdr, pkt.ASeqHdr) != 0 {
+				cfg.Data = pkt.ASeqHdr
+				out = append(out, cfg)
+			}
+			st.aseqhdr = pkt.ASeqHdr
+		}
+		st.atype = pkt.Type
+	}
+
+	pkt.VSeqHdr, pkt.ASeqHdr, pkt.Metadata = nil, nil, nil
+	out = append(out, pkt)
+	return
+}
+
+func (s *SplitSeqhdr) Do(in []av.Packet) (out []av.Packet) {
+	for _, pkt := range in {
+		out = append(out, s.do(pkt)...)
+	}
+	return
+}
+
+func (s *SplitSeqhdr) Flush() []av.Packet {
+	return nil
+}
@@ -0,0 +1,148 @@
+package pktop
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/h264"
+)
+
+var (
+	testSPS = []byte{0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x03, 0xc0, 0xf1, 0x83, 0x19, 0x60}
+	testPPS = []byte{0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0}
+	testIDR = []byte{0x65, 0x88, 0x84, 0x00}
+	testP   = []byte{0x41, 0x9a, 0x02, 0x00}
+)
+
+// testSPS2 differs from testSPS in level_idc only.
+func testSPS2() []byte {
+	b := append([]byte(nil), testSPS...)
+	b[3] = 0x28
+	return b
+}
+
+func testH264Config(sps []byte) []byte {
+	c := h264.NewCodec()
+	c.AddSPSPPS(sps)
+	c.AddSPSPPS(testPPS)
+	n := 0
+	c.ToConfig(nil, &n)
+	b := make([]byte, n)
+	n = 0
+	c.ToConfig(b, &n)
+	return b
+}
+
+func TestMergeSeqhdrTracks(t *testing.T) {
+	cfg0, cfg1 := testH264Config(testSPS), testH264Config(testSPS2())
+	aaccfg := []byte{0x12, 0x10}
+	in := []av.Packet{
+		{Type: av.Metadata, Data: []byte("meta")},
+		{Type: av.H264DecoderConfig, Data: cfg0},
+		{Type: av.H264DecoderConfig, Idx: 1, Data: cfg1},
+		{Type: av.AACDecoderConfig, Data: aaccfg},
+		{Type: av.H264, IsKeyFrame: true, Data: h264.JoinNALUsAVCC([][]byte{testIDR})},
+		{Type: av.H264, Idx: 1, IsKeyFrame: true, Data: h264.JoinNALUsAVCC([][]byte{testIDR})},
+		{Type: av.AAC, Data: []byte{1}},
+		{Type: av.AAC, Idx: 1, Data: []byte{1}},
+		{Type: av.H264, Data: h264.JoinNALUsAVCC([][]byte{testP})},
+	}
+	out := NewMergeSeqhdr().Do(in)
+
+	if len(out) != 5 {
+		t.Fatalf("got %d packets", len(out))
+	}
+	for i, want := range []struct {
+		vseqhdr, aseqhdr []byte
+	}{
+		{cfg0, nil},
+		{cfg1, nil},
+		{nil, aaccfg},
+		{nil, nil}, // no config for audio track 1
+		{nil, nil},
+	} {
+		pkt := out[i]
+		if !bytes.Equal(pkt.VSeqHdr, want.vseqhdr) || !bytes.Equal(pkt.ASeqHdr, want.aseqhdr) || string(pkt.Metadata) != "meta" {
+			t.Fatalf("packet %d %s: vseqhdr %x aseqhdr %x metadata %q", i, pkt.String(), pkt.VSeqHdr, pkt.ASeqHdr, pkt.Metadata)
+		}
+	}
+}
+
+func TestMergeSeqhdrInband(t *testing.T) {
+	cfg, cfg2 := testH264Config(testSPS), testH264Config(testSPS2())
+	key := func(nalus ...[]byte) av.Packet {
+		return av.Packet{Type: av.H264, IsKeyFrame: true, Data: h264.JoinNALUsAVCC(append(nalus, testIDR))}
+	}
+	m := NewMergeSeqhdr()
+	out := m.Do([]av.Packet{
+		{Type: av.H264DecoderConfig, Data: cfg},
+		key(),
+		// same SPS/PPS inband
+		key(testSPS, testPPS),
+		// new SPS
+		key(testSPS2(), testPPS),
+		key(),
+	})
+
+	want := [][]byte{cfg, cfg, cfg2, cfg2}
+	if len(out) != len(want) {
+		t.Fatalf("got %d packets", len(out))
+	}
+	for i := range want {
+		if !bytes.Equal(out[i].VSeqHdr, want[i]) {
+			t.Fatalf("packet %d vseqhdr %x", i, out[i].VSeqHdr)
+		}
+	}
+}
+
+func TestSplitSeqhdr(t *testing.T) {
+	cfg, cfg2 := testH264Config(testSPS), testH264Config(testSPS2())
+	// the same SPS/PPS in a record differing in lengthSizeMinusOne
+	repacked := append([]byte(nil), cfg...)
+	repacked[4] = 0xfe
+
+	key := func(idx int, vseqhdr []byte) av.Packet {
+		return av.Packet{Type: av.H264, Idx: idx, IsKeyFrame: true, VSeqHdr: vseqhdr, Data: h264.JoinNALUsAVCC([][]byte{testIDR})}
+	}
+	out := NewSplitSeqhdr().Do([]av.Packet{
+		key(0, cfg),
+		key(1, cfg2),
+		key(0, cfg),
+		key(0, repacked),
+		key(1, cfg2),
+		key(0, cfg2),
+		{Type: av.AAC, ASeqHdr: []byte{0x12, 0x10}, Data: []byte{1}},
+		{Type: av.AAC, ASeqHdr: []byte{0x12, 0x10}, Data: []byte{1}},
+	})
+
+	var got []string
+	for _, pkt := range out {
+		got = append(got, pkt.String())
+		if pkt.VSeqHdr != nil || pkt.ASeqHdr != nil {
+			t.Fatalf("%s still has seqhdr", pkt.String())
+		}
+	}
+	want := []string{
+		"H264DecoderConfig 0s 43",
+		"H264 K 0s 8",
+		"H264DecoderConfig #1 0s 43",
+		"H264 #1 K 0s 8",
+		"H264 K 0s 8",
+		"H264 K 0s 8",
+		"H264 #1 K 0s 8",
+		"H264DecoderConfig 0s 43",
+		"H264 K 0s 8",
+		"AACDecoderConfig 0s 2",
+		"AAC 0s 1",
+		"AAC 0s 1",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %q", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("packet %d %q, want %q", i, got[i], want[i])
+		}
+	}
+}
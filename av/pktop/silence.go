@@ -0,0 +1,187 @@
+package pktop
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/aac"
+)
+
+const DefaultMaxGap = time.Second
+
+// AACSilence fills gaps in the AAC timeline of track 0 with silent AAC-LC
+// frames matching the current config. A gap is found when audio comes back
+// more than MaxGap after the last frame, or when video gets more than MaxGap
+// ahead of audio while audio is dropped out, silence then follows each video
+// frame until audio comes back. Audio overlapping the inserted silence is
+// dropped.
+//
+// If SynthConfig is set, packets are held until audio or MaxGap of video is
+// seen. Without audio, an audio track of silence with that config is made up
+// from the first video frame on and follows video until real audio shows up.
+type AACSilence struct {
+	MaxGap      time.Duration
+	SynthConfig *aac.MPEG4AudioConfig
+
+	Inserted int
+	Dropped  int
+
+	codec    *aac.Codec
+	frame    []byte
+	dur      time.Duration
+	started  bool
+	next     time.Duration
+	injected bool
+	synthing bool
+
+	decided    bool
+	held       []av.Packet
+	gotVideo   bool
+	firstVideo time.Duration
+}
+
+func NewAACSilence() *AACSilence {
+	return &AACSilence{
+		MaxGap: DefaultMaxGap,
+	}
+}
+
+func (f *AACSilence) setCodec(c *aac.Codec) {
+	f.codec = c
+	f.frame, _ = aac.SilentFrame(c.Config)
+	f.dur = aac.PacketDuration(c.Config, nil)
+}
+
+func (f *AACSilence) fill(until time.Duration) (out []av.Packet) {
+	if f.frame == nil {
+		return
+	}
+	for ; f.next < until; f.next += f.dur {
+		out = append(out, av.Packet{
+			Type: av.AAC,
+			Time: f.next,
+			Data: f.frame,
+			AAC:  f.codec,
+		})
+		f.Inserted++
+		f.injected = true
+	}
+	return
+}
+
+func (f *AACSilence) synth(t time.Duration) (out []av.Packet) {
+	buf := &bytes.Buffer{}
+	if err := aac.WriteMPEG4AudioConfig(buf, *f.SynthConfig); err != nil {
+		return
+	}
+	c, err := aac.FromMPEG4AudioConfigBytes(buf.Bytes())
+	if err != nil {
+		return
+	}
+	f.setCodec(c)
+	f.started = true
+	f.synthing = true
+	f.next = t
+	out = append(out, av.Packet{
+		Type: av.AACDecoderConfig,
+		Time: t,
+		Data: c.ConfigBytes,
+		AAC:  c,
+	})
+	return
+}
+
+func (f *AACSilence) release() (out []av.Packet) {
+	held := f.held
+	f.held = nil
+	for _, pkt := range held {
+		out = append(out, f.do(pkt)...)
+	}
+	return
+}
+
+func (f *AACSilence) wait(pkt av.Packet) (out []av.Packet) {
+	if pkt.Idx == 0 {
+		switch {
+		case pkt.Type == av.AACDecoderConfig || pkt.Type == av.AAC:
+			f.decided = true
+			out = f.release()
+			return append(out, f.do(pkt)...)
+
+		case av.IsVideo(pkt.Type) && !av.IsConfig(pkt.Type):
+			if !f.gotVideo {
+				f.gotVideo = true
+				f.firstVideo = pkt.Time
+			}
+			if pkt.Time-f.firstVideo >= f.MaxGap {
+				f.held = append(f.held, pkt)
+				f.decided = true
+				out = f.synth(f.firstVideo)
+				return append(out, f.release()...)
+			}
+		}
+	}
+	f.held = append(f.held, pkt)
+	return
+}
+
+func (f *AACSilence) do(pkt av.Packet) (out []av.Packet) {
+	if f.SynthConfig != nil && !f.decided {
+		return f.wait(pkt)
+	}
+	if pkt.Idx != 0 {
+		return []av.Packet{pkt}
+	}
+
+	switch {
+	case pkt.Type == av.AACDecoderConfig:
+		if c, err := aac.FromMPEG4AudioConfigBytes(pkt.Data); err == nil {
+			f.setCodec(c)
+		}
+		if f.synthing {
+			f.synthing = false
+			f.started = false
+		}
+
+	case pkt.Type == av.AAC:
+		if f.codec == nil && pkt.AAC != nil {
+			f.setCodec(pkt.AAC)
+		}
+		if f.started && f.injected && pkt.Time < f.next-f.dur/2 {
+			f.Dropped++
+			return
+		}
+		if f.started && pkt.Time-f.next > f.MaxGap {
+			out = f.fill(pkt.Time)
+		}
+		f.started = true
+		f.injected = false
+		f.next = pkt.Time + f.dur
+
+	case av.IsVideo(pkt.Type) && !av.IsConfig(pkt.Type):
+		if f.synthing || f.started && (f.injected || pkt.Time-f.next > f.MaxGap) {
+			out = f.fill(pkt.Time)
+		}
+	}
+
+	return append(out, pkt)
+}
+
+func (f *AACSilence) Do(in []av.Packet) (out []av.Packet) {
+	for _, pkt := range in {
+		out = append(out, f.do(pkt)...)
+	}
+	return
+}
+
+func (f *AACSilence) Flush() (out []av.Packet) {
+	if f.SynthConfig != nil && !f.decided {
+		f.decided = true
+		if f.gotVideo {
+			out = f.synth(f.firstVideo)
+		}
+		out = append(out, f.release()...)
+	}
+	return
+}
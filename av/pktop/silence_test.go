@@ -0,0 +1,137 @@
+package pktop
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/aac"
+)
+
+// silenceInput has video every 100ms in [vstart, 3s) and 44.1kHz AAC frames
+// in [0, aend) and [aresume, 3s), in time order.
+func silenceInput(c *aac.Codec, vstart, aend, aresume time.Duration) (pkts []av.Packet) {
+	dur := aac.PacketDuration(c.Config, nil)
+	if aend > 0 || aresume < 3*time.Second {
+		pkts = append(pkts, av.Packet{Type: av.AACDecoderConfig, Data: c.ConfigBytes, AAC: c})
+	}
+	vt, at := vstart, time.Duration(0)
+	for vt < 3*time.Second {
+		if at < vt {
+			if at < aend || at >= aresume {
+				pkts = append(pkts, av.Packet{Type: av.AAC, Time: at, Data: []byte{1}, AAC: c})
+			}
+			at += dur
+			continue
+		}
+		pkts = append(pkts, av.Packet{Type: av.H264, IsKeyFrame: vt == vstart, Time: vt})
+		vt += 100 * time.Millisecond
+	}
+	return
+}
+
+// checkSilence checks audio never goes back in time or more than lag behind
+// the video before it, and no more than max frames come between two video
+// frames.
+func checkSilence(t *testing.T, out []av.Packet, max int, lag time.Duration) {
+	var lastAudio, lastVideo time.Duration
+	n := 0
+	for i, pkt := range out {
+		switch pkt.Type {
+		case av.AAC:
+			if pkt.Time < lastAudio || pkt.Time < lastVideo-lag {
+				t.Fatalf("packet %d audio %v after audio %v video %v", i, pkt.Time, lastAudio, lastVideo)
+			}
+			lastAudio = pkt.Time
+			if n++; n > max {
+				t.Fatalf("packet %d %d audio frames in a row", i, n)
+			}
+		case av.H264:
+			lastVideo = pkt.Time
+			n = 0
+		}
+	}
+}
+
+func TestAACSilenceGap(t *testing.T) {
+	c, _ := aac.FromMPEG4AudioConfigBytes([]byte{0x12, 0x10})
+	dur := aac.PacketDuration(c.Config, nil)
+
+	f := NewAACSilence()
+	f.MaxGap = 500 * time.Millisecond
+	out := f.Do(silenceInput(c, 0, time.Second, 2*time.Second))
+	out = append(out, f.Flush()...)
+
+	// the drop out from 1s is found at the 1.6s video frame, silence comes in
+	// one burst up to that frame then in step with video
+	found := 0
+	for found < len(out) && !(out[found].Type == av.H264 && out[found].Time == 1600*time.Millisecond) {
+		found++
+	}
+	burst := f.MaxGap + 100*time.Millisecond
+	checkSilence(t, out[:found], int(burst/dur)+1, burst)
+	checkSilence(t, out[found:], int(100*time.Millisecond/dur)+1, 0)
+
+	var audio []time.Duration
+	for _, pkt := range out {
+		if pkt.Type == av.AAC {
+			audio = append(audio, pkt.Time)
+		}
+	}
+	for i := 1; i < len(audio); i++ {
+		if d := audio[i] - audio[i-1]; d > dur+time.Millisecond {
+			t.Fatalf("audio gap %v at %v", d, audio[i-1])
+		}
+	}
+	if f.Inserted < int(time.Second/dur)-1 || f.Dropped > 5 {
+		t.Fatalf("inserted %d dropped %d", f.Inserted, f.Dropped)
+	}
+}
+
+func TestAACSilenceSynth(t *testing.T) {
+	config := aac.MPEG4AudioConfig{ObjectType: aac.AOT_AAC_LC, SampleRate: 44100, ChannelLayout: aac.CH_STEREO}
+	c, _ := aac.FromMPEG4AudioConfigBytes([]byte{0x12, 0x10})
+	dur := aac.PacketDuration(c.Config, nil)
+
+	t.Run("no audio", func(t *testing.T) {
+		f := NewAACSilence()
+		f.SynthConfig = &config
+		var out []av.Packet
+		for _, pkt := range silenceInput(c, time.Second, 0, 3*time.Second) {
+			out = append(out, f.Do([]av.Packet{pkt})...)
+		}
+		out = append(out, f.Flush()...)
+
+		if len(out) == 0 || out[0].Type != av.AACDecoderConfig || out[0].Time != time.Second {
+			t.Fatalf("first packet %v", describe(out[:1]))
+		}
+		checkSilence(t, out, int(100*time.Millisecond/dur)+1, 0)
+		// up to the last video frame at 2.9s
+		if want := int(1900 * time.Millisecond / dur); f.Inserted < want-1 || f.Inserted > want+1 {
+			t.Fatalf("inserted %d", f.Inserted)
+		}
+	})
+
+	t.Run("short stream", func(t *testing.T) {
+		f := NewAACSilence()
+		f.SynthConfig = &config
+		in := silenceInput(c, 2800*time.Millisecond, 0, 3*time.Second)
+		if out := f.Do(in); len(out) != 0 {
+			t.Fatalf("got %d packets before MaxGap", len(out))
+		}
+		out := f.Flush()
+		if len(out) != 1+len(in)+f.Inserted || out[0].Type != av.AACDecoderConfig {
+			t.Fatalf("flush %v", describe(out))
+		}
+	})
+
+	t.Run("audio", func(t *testing.T) {
+		f := NewAACSilence()
+		f.SynthConfig = &config
+		in := silenceInput(c, 0, 3*time.Second, 3*time.Second)
+		out := append(f.Do(in), f.Flush()...)
+		if len(out) != len(in) || f.Inserted != 0 {
+			t.Fatalf("got %d packets of %d, inserted %d", len(out), len(in), f.Inserted)
+		}
+	})
+}
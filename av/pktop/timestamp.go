@@ -0,0 +1,191 @@
+package pktop
+
+import (
+	"time"
+
+	"github.com/nareix/joy5/av"
+)
+
+const (
+	DefaultMaxJump     = time.Second * 3
+	DefaultStartWindow = time.Second
+)
+
+// FLV and RTMP timestamps are 32-bit milliseconds
+const tsRollover = time.Duration(1<<32) * time.Millisecond
+
+type tsTrack struct {
+	last, delta time.Duration
+}
+
+// TimeNormalizer rebases times to start at zero, unwraps 32-bit millisecond
+// rollover and smooths jumps. Packets are held at the start until both video
+// and audio frames are seen, or StartWindow of frames, and times are rebased
+// on the earliest of them so a track starting later keeps its place.
+//
+// A packet more than MaxJump ahead of or behind the latest time seen is a
+// jump: all tracks are shifted by the same offset so the packet follows the
+// latest time by the last frame duration of its track, which keeps A/V
+// alignment.
+//
+// Times also never go backwards within a track. Config and metadata packets
+// take the latest time, their timestamps are often zero in the middle of a
+// stream.
+type TimeNormalizer struct {
+	MaxJump     time.Duration
+	StartWindow time.Duration
+
+	Jumps int
+
+	started   bool
+	unwrapped bool
+	lastraw   time.Duration
+	wrap      time.Duration
+	offset    time.Duration
+	latest    time.Duration
+	tracks    map[trackKey]*tsTrack
+
+	held                 []av.Packet
+	heldVideo, heldAudio bool
+	heldFrames           bool
+	heldMin, heldMax     time.Duration
+}
+
+type trackKey struct {
+	video bool
+	idx   int
+}
+
+func NewTimeNormalizer() *TimeNormalizer {
+	return &TimeNormalizer{
+		MaxJump:     DefaultMaxJump,
+		StartWindow: DefaultStartWindow,
+		tracks:      map[trackKey]*tsTrack{},
+	}
+}
+
+func isFrame(pkt av.Packet) bool {
+	return pkt.Type != av.Metadata && !av.IsConfig(pkt.Type)
+}
+
+func (f *TimeNormalizer) unwrap(t time.Duration) time.Duration {
+	t %= tsRollover
+	if t < 0 {
+		t += tsRollover
+	}
+	if f.unwrapped {
+		switch diff := t - f.lastraw; {
+		case diff < -tsRollover/2:
+			f.wrap += tsRollover
+		case diff > tsRollover/2 && f.wrap > 0:
+			// late packet from before the rollover
+			return t + f.wrap - tsRollover
+		}
+	}
+	f.unwrapped = true
+	f.lastraw = t
+	return t + f.wrap
+}
+
+// place sets the time of a frame from its unwrapped time t.
+func (f *TimeNormalizer) place(pkt *av.Packet, t time.Duration) {
+	key := trackKey{av.IsVideo(pkt.Type), pkt.Idx}
+	tr := f.tracks[key]
+
+	out := t + f.offset
+	if out > f.latest+f.MaxJump || out < f.latest-f.MaxJump {
+		var delta time.Duration
+		if tr != nil {
+			delta = tr.delta
+		}
+		f.offset = f.latest + delta - t
+		out = f.latest + delta
+		f.Jumps++
+	}
+	if out < 0 {
+		out = 0
+	}
+	if tr != nil && out < tr.last {
+		out = tr.last
+	}
+
+	if tr == nil {
+		tr = &tsTrack{last: out}
+		f.tracks[key] = tr
+	} else if d := out - tr.last; d > 0 {
+		tr.delta = d
+		tr.last = out
+	}
+	if out > f.latest {
+		f.latest = out
+	}
+	pkt.Time = out
+}
+
+func (f *TimeNormalizer) hold(pkt av.Packet) {
+	if isFrame(pkt) {
+		if !f.heldFrames || pkt.Time < f.heldMin {
+			f.heldMin = pkt.Time
+		}
+		if !f.heldFrames || pkt.Time > f.heldMax {
+			f.heldMax = pkt.Time
+		}
+		f.heldFrames = true
+		if av.IsVideo(pkt.Type) {
+			f.heldVideo = true
+		} else {
+			f.heldAudio = true
+		}
+	}
+	f.held = append(f.held, pkt)
+}
+
+// start rebases on the earliest held frame and returns the held packets.
+func (f *TimeNormalizer) start() (out []av.Packet) {
+	f.started = true
+	f.offset = -f.heldMin
+	out, f.held = f.held, nil
+	for i := range out {
+		if isFrame(out[i]) {
+			f.place(&out[i], out[i].Time)
+		} else {
+			out[i].Time = f.latest
+		}
+	}
+	return
+}
+
+func (f *TimeNormalizer) Do(in []av.Packet) (out []av.Packet) {
+	for _, pkt := range in {
+		if isFrame(pkt) {
+			pkt.Time = f.unwrap(pkt.Time)
+		}
+		if !f.started {
+			jump := isFrame(pkt) && f.heldFrames &&
+				(pkt.Time > f.heldMax+f.MaxJump || pkt.Time < f.heldMin-f.MaxJump)
+			if !jump {
+				f.hold(pkt)
+				if f.heldVideo && f.heldAudio || f.heldMax-f.heldMin >= f.StartWindow {
+					out = append(out, f.start()...)
+				}
+				continue
+			}
+			// a jump ends holding, it is placed after the held packets
+			out = append(out, f.start()...)
+		}
+		if isFrame(pkt) {
+			f.place(&pkt, pkt.Time)
+		} else {
+			pkt.Time = f.latest
+		}
+		out = append(out, pkt)
+	}
+	return
+}
+
+func (f *TimeNormalizer) Flush() []av.Packet {
+	if f.started || len(f.held) == 0 {
+		return nil
+	}
+	return f.start()
+}
@@ -0,0 +1,79 @@
+package pktop
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy5/av"
+)
+
+func ms(v int64) time.Duration {
+	return time.Duration(v) * time.Millisecond
+}
+
+func TestTimeNormalizer(t *testing.T) {
+	const wrap = int64(1) << 32
+	v := func(t int64) av.Packet { return av.Packet{Type: av.H264, Time: ms(t)} }
+	a := func(t int64) av.Packet { return av.Packet{Type: av.AAC, Time: ms(t)} }
+	cfg := av.Packet{Type: av.AACDecoderConfig}
+
+	tests := []struct {
+		name  string
+		in    []av.Packet
+		out   []int64
+		jumps int
+	}{
+		{
+			name: "rebase on earliest track",
+			in:   []av.Packet{v(1000), v(1040), a(900), a(923)},
+			out:  []int64{100, 140, 0, 23},
+		},
+		{
+			name: "config takes latest time",
+			in:   []av.Packet{cfg, a(500), a(523), cfg, a(546)},
+			out:  []int64{0, 0, 23, 23, 46},
+		},
+		{
+			name: "rollover",
+			in:   []av.Packet{a(wrap - 40), a(wrap - 17), a(6), a(29)},
+			out:  []int64{0, 23, 46, 69},
+		},
+		{
+			name:  "forward jump",
+			in:    []av.Packet{v(0), v(40), v(80), v(10080), v(10120)},
+			out:   []int64{0, 40, 80, 120, 160},
+			jumps: 1,
+		},
+		{
+			name:  "backward jump",
+			in:    []av.Packet{v(10000), v(10040), v(10080), v(0), v(40)},
+			out:   []int64{0, 40, 80, 120, 160},
+			jumps: 1,
+		},
+		{
+			name: "small step back",
+			in:   []av.Packet{v(0), v(40), v(20), v(80)},
+			out:  []int64{0, 40, 40, 80},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewTimeNormalizer()
+			var out []av.Packet
+			for _, pkt := range tt.in {
+				out = append(out, f.Do([]av.Packet{pkt})...)
+			}
+			out = append(out, f.Flush()...)
+
+			var got []int64
+			for _, pkt := range out {
+				got = append(got, int64(pkt.Time/time.Millisecond))
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.out) || f.Jumps != tt.jumps {
+				t.Fatalf("got %v jumps %d, want %v jumps %d", got, f.Jumps, tt.out, tt.jumps)
+			}
+		})
+	}
+}
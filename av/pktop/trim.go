@@ -0,0 +1,143 @@
+package pktop
+
+import (
+	"time"
+
+	"github.com/nareix/joy5/av"
+)
+
+type cfgKey struct {
+	typ, idx int
+}
+
+// Trim keeps packets from the keyframe at or before Start up to End, End 0
+// means to the end. The config and metadata packets current at that keyframe
+// are sent first and times are rebased to zero. Done reports when End is
+// reached, no more packets are let through after that.
+//
+// Inputs without video start at the first packet at or after Start.
+type Trim struct {
+	Start, End time.Duration
+
+	gotVideo bool
+	started  bool
+	done     bool
+	base     time.Duration
+
+	metadata *av.Packet
+	cfgs     map[cfgKey]av.Packet
+	cfgorder []cfgKey
+	gopcfgs  []av.Packet
+	gop      []av.Packet
+}
+
+func NewTrim(start, end time.Duration) *Trim {
+	return &Trim{
+		Start: start,
+		End:   end,
+		cfgs:  map[cfgKey]av.Packet{},
+	}
+}
+
+func (f *Trim) Done() bool {
+	return f.done
+}
+
+func (f *Trim) headers() (pkts []av.Packet) {
+	if f.metadata != nil {
+		pkts = append(pkts, *f.metadata)
+	}
+	for _, k := range f.cfgorder {
+		pkts = append(pkts, f.cfgs[k])
+	}
+	return
+}
+
+func (f *Trim) rebase(pkts []av.Packet) []av.Packet {
+	for i := range pkts {
+		if t := pkts[i].Time - f.base; t > 0 {
+			pkts[i].Time = t
+		} else {
+			pkts[i].Time = 0
+		}
+	}
+	return pkts
+}
+
+func (f *Trim) buffer(pkt av.Packet) {
+	switch {
+	case pkt.Type == av.Metadata:
+		p := pkt
+		f.metadata = &p
+	case av.IsConfig(pkt.Type):
+		k := cfgKey{pkt.Type, pkt.Idx}
+		if _, ok := f.cfgs[k]; !ok {
+			f.cfgorder = append(f.cfgorder, k)
+		}
+		f.cfgs[k] = pkt
+	case av.IsVideo(pkt.Type):
+		f.gotVideo = true
+		if pkt.IsKeyFrame {
+			f.gopcfgs = f.headers()
+			f.gop = []av.Packet{}
+		}
+	}
+	if f.gop != nil {
+		f.gop = append(f.gop, pkt)
+	}
+}
+
+func (f *Trim) do(pkt av.Packet) (out []av.Packet) {
+	if f.done {
+		return
+	}
+	frame := pkt.Type != av.Metadata && !av.IsConfig(pkt.Type)
+	key := av.IsVideo(pkt.Type) && pkt.IsKeyFrame
+
+	if f.started {
+		if f.End > 0 && frame && pkt.Time >= f.End {
+			f.done = true
+			return
+		}
+		return f.rebase([]av.Packet{pkt})
+	}
+
+	if !frame || pkt.Time < f.Start {
+		f.buffer(pkt)
+		return
+	}
+	buffered := false
+	if key && (pkt.Time == f.Start || f.gop == nil) {
+		f.buffer(pkt)
+		buffered = true
+	} else if f.gop == nil && (f.gotVideo || av.IsVideo(pkt.Type)) {
+		// wait for a keyframe
+		f.buffer(pkt)
+		return
+	}
+
+	f.started = true
+	if f.gop != nil {
+		f.base = f.gop[0].Time
+		out = append(f.gopcfgs, f.gop...)
+		if !buffered {
+			out = append(out, pkt)
+		}
+	} else {
+		f.base = pkt.Time
+		out = append(f.headers(), pkt)
+	}
+	f.gop, f.gopcfgs, f.cfgs = nil, nil, nil
+	return f.rebase(out)
+}
+
+func (f *Trim) Do(in []av.Packet) (out []av.Packet) {
+	for _, pkt := range in {
+		out = append(out, f.do(pkt)...)
+	}
+	return
+}
+
+func (f *Trim) Flush() []av.Packet {
+	return nil
+}
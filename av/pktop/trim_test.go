@@ -0,0 +1,89 @@
+package pktop
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy5/av"
+)
+
+// trimInput has a keyframe every 2s, video and audio every 500ms, and the
+// video config replaced at 1s.
+func trimInput(video bool) (pkts []av.Packet) {
+	pkts = append(pkts, av.Packet{Type: av.Metadata, Data: []byte("meta")})
+	if video {
+		pkts = append(pkts, av.Packet{Type: av.H264DecoderConfig, Data: []byte("cfg1")})
+	}
+	pkts = append(pkts, av.Packet{Type: av.AACDecoderConfig, Data: []byte("aac")})
+	for t := time.Duration(0); t < 8*time.Second; t += 500 * time.Millisecond {
+		if video && t == time.Second {
+			pkts = append(pkts, av.Packet{Type: av.H264DecoderConfig, Time: t, Data: []byte("cfg2")})
+		}
+		if video {
+			key := t%(2*time.Second) == 0
+			data := "p"
+			if key {
+				data = "k"
+			}
+			pkts = append(pkts, av.Packet{Type: av.H264, IsKeyFrame: key, Time: t, Data: []byte(data)})
+		}
+		pkts = append(pkts, av.Packet{Type: av.AAC, Time: t + 250*time.Millisecond, Data: []byte("a")})
+	}
+	return
+}
+
+func TestTrim(t *testing.T) {
+	tests := []struct {
+		name       string
+		video      bool
+		start, end time.Duration
+		want       []string
+	}{
+		{
+			name:  "from keyframe before start",
+			video: true,
+			start: 3 * time.Second, end: 5 * time.Second,
+			want: []string{
+				"Metadata 0s meta", "H264DecoderConfig 0s cfg2", "AACDecoderConfig 0s aac",
+				"H264 0s k", "AAC 250ms a", "H264 500ms p", "AAC 750ms a",
+				"H264 1s p", "AAC 1.25s a", "H264 1.5s p", "AAC 1.75s a",
+				"H264 2s k", "AAC 2.25s a", "H264 2.5s p", "AAC 2.75s a",
+			},
+		},
+		{
+			name:  "start on keyframe",
+			video: true,
+			start: 4 * time.Second, end: 5 * time.Second,
+			want: []string{
+				"Metadata 0s meta", "H264DecoderConfig 0s cfg2", "AACDecoderConfig 0s aac",
+				"H264 0s k", "AAC 250ms a", "H264 500ms p", "AAC 750ms a",
+			},
+		},
+		{
+			name:  "audio only",
+			start: 6 * time.Second,
+			want: []string{
+				"Metadata 0s meta", "AACDecoderConfig 0s aac",
+				"AAC 0s a", "AAC 500ms a", "AAC 1s a", "AAC 1.5s a",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := NewTrim(tt.start, tt.end)
+			var out []av.Packet
+			for _, pkt := range trimInput(tt.video) {
+				out = append(out, f.Do([]av.Packet{pkt})...)
+			}
+			out = append(out, f.Flush()...)
+			if got := describe(out); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("got %q\nwant %q", got, tt.want)
+			}
+			if f.Done() != (tt.end > 0) {
+				t.Fatalf("done %v", f.Done())
+			}
+		})
+	}
+}
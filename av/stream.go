@@ -0,0 +1,245 @@
+package av
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/nareix/joy5/codec/aac"
+	"github.com/nareix/joy5/codec/av1"
+	"github.com/nareix/joy5/codec/g711"
+	"github.com/nareix/joy5/codec/h264"
+	"github.com/nareix/joy5/codec/h265"
+	"github.com/nareix/joy5/codec/mp3"
+	"github.com/nareix/joy5/codec/opus"
+	"github.com/nareix/joy5/codec/speex"
+	"github.com/nareix/joy5/codec/vp9"
+)
+
+// ConfigType maps a frame packet type to its decoder config packet type.
+var ConfigType = map[int]int{
+	H264: H264DecoderConfig,
+	H265: H265DecoderConfig,
+	AV1:  AV1DecoderConfig,
+	VP9:  VP9DecoderConfig,
+	AAC:  AACDecoderConfig,
+	Opus: OpusConfig,
+}
+
+// FrameType maps a decoder config packet type to its frame packet type.
+var FrameType = map[int]int{
+	H264DecoderConfig: H264,
+	H265DecoderConfig: H265,
+	AV1DecoderConfig:  AV1,
+	VP9DecoderConfig:  VP9,
+	AACDecoderConfig:  AAC,
+	OpusConfig:        Opus,
+}
+
+// IsVideo reports whether the packet type, frame or config, is video.
+func IsVideo(pkttype int) bool {
+	switch pkttype {
+	case H264, H264DecoderConfig, H265, H265DecoderConfig,
+		AV1, AV1DecoderConfig, VP9, VP9DecoderConfig:
+		return true
+	}
+	return false
+}
+
+// IsAudio reports whether the packet type, frame or config, is audio.
+func IsAudio(pkttype int) bool {
+	switch pkttype {
+	case AAC, AACDecoderConfig, Opus, OpusConfig, MP3, ALAW, MULAW, Speex:
+		return true
+	}
+	return false
+}
+
+// IsConfig reports whether the packet type is a decoder config.
+func IsConfig(pkttype int) bool {
+	_, ok := FrameType[pkttype]
+	return ok
+}
+
+// ConfigEqual compares two decoder configs of the config packet type. H.264
+// and H.265 configs are compared by parameter sets, others byte by byte.
+func ConfigEqual(pkttype int, a, b []byte) bool {
+	if bytes.Compare(a, b) == 0 {
+		return true
+	}
+	switch pkttype {
+	case H264DecoderConfig:
+		ca, erra := h264.FromDecoderConfig(a)
+		cb, errb := h264.FromDecoderConfig(b)
+		return erra == nil && errb == nil && ca.Equal(*cb)
+	case H265DecoderConfig:
+		ca, erra := h265.FromDecoderConfig(a)
+		cb, errb := h265.FromDecoderConfig(b)
+		return erra == nil && errb == nil && ca.Equal(*cb)
+	}
+	return false
+}
+
+type CodecData struct {
+	Type        int // frame packet type, H264, AAC, ...
+	ConfigBytes []byte
+	Width       int
+	Height      int
+	SampleRate  int
+	Channels    int
+}
+
+func (c CodecData) IsVideo() bool {
+	return IsVideo(c.Type)
+}
+
+func (c CodecData) IsAudio() bool {
+	return IsAudio(c.Type)
+}
+
+func (c CodecData) String() string {
+	s := PacketTypeString[c.Type]
+	if c.IsVideo() {
+		s += fmt.Sprintf(" %dx%d", c.Width, c.Height)
+	}
+	if c.IsAudio() {
+		s += fmt.Sprintf(" %dHz %dch", c.SampleRate, c.Channels)
+	}
+	return s
+}
+
+type Stream struct {
+	Idx int // Packet.Idx of the stream
+	CodecData
+}
+
+func (s Stream) String() string {
+	if s.Idx != 0 {
+		return fmt.Sprintf("#%d %s", s.Idx, s.CodecData)
+	}
+	return s.CodecData.String()
+}
+
+type StreamsReader interface {
+	Streams() ([]Stream, error)
+}
+
+// CodecDataFromConfig parses a decoder config packet.
+func CodecDataFromConfig(pkt Packet) (c CodecData, err error) {
+	typ, ok := FrameType[pkt.Type]
+	if !ok {
+		err = fmt.Errorf("av: %s is not a config packet", PacketTypeString[pkt.Type])
+		return
+	}
+	c.Type = typ
+	c.ConfigBytes = pkt.Data
+
+	switch pkt.Type {
+	case H264DecoderConfig:
+		var h *h264.Codec
+		if h, err = h264.FromDecoderConfig(pkt.Data); err != nil {
+			return
+		}
+		c.Width, c.Height = h.W, h.H
+
+	case H265DecoderConfig:
+		var h *h265.Codec
+		if h, err = h265.FromDecoderConfig(pkt.Data); err != nil {
+			return
+		}
+		c.Width, c.Height = h.W, h.H
+
+	case AV1DecoderConfig:
+		var a *av1.Codec
+		if a, err = av1.FromDecoderConfig(pkt.Data); err != nil {
+			return
+		}
+		c.Width, c.Height = a.W, a.H
+
+	case VP9DecoderConfig:
+		if _, err = vp9.FromDecoderConfig(pkt.Data); err != nil {
+			return
+		}
+
+	case AACDecoderConfig:
+		var a *aac.Codec
+		if a, err = aac.FromMPEG4AudioConfigBytes(pkt.Data); err != nil {
+			return
+		}
+		c.SampleRate = a.Config.SampleRate
+		c.Channels = a.Config.ChannelLayout.Count()
+
+	case OpusConfig:
+		var o *opus.Codec
+		if o, err = opus.FromDecoderConfig(pkt.Data); err != nil {
+			return
+		}
+		c.SampleRate = opus.SampleRate
+		c.Channels = o.Config.ChannelCount
+	}
+
+	return
+}
+
+// CodecDataFromFrame describes codecs without a config packet from a frame.
+func CodecDataFromFrame(pkt Packet) (c CodecData, err error) {
+	c.Type = pkt.Type
+
+	switch pkt.Type {
+	case VP9:
+		var v *vp9.Codec
+		if v, err = vp9.FromKeyFrame(pkt.Data); err != nil {
+			return
+		}
+		c.ConfigBytes = v.ConfigBytes
+		c.Width, c.Height = v.W, v.H
+
+	case MP3:
+		var h mp3.FrameHeader
+		if h, err = mp3.ParseFrameHeader(pkt.Data); err != nil {
+			return
+		}
+		c.SampleRate = h.SampleRate
+		c.Channels = h.ChannelCount()
+
+	case ALAW, MULAW:
+		g := pkt.G711
+		if g == nil {
+			g = g711.NewCodec(g711.ALAW)
+		}
+		c.SampleRate = g.SampleRate
+		c.Channels = g.Channels
+
+	case Speex:
+		c.SampleRate = speex.SampleRate
+		c.Channels = 1
+
+	default:
+		err = fmt.Errorf("av: can not get codec data from %s", PacketTypeString[pkt.Type])
+	}
+
+	return
+}
+
+// PacketDuration is the duration of an audio frame packet from its codec and
+// payload, 0 if it can not be told.
+func PacketDuration(pkt Packet) time.Duration {
+	switch pkt.Type {
+	case AAC:
+		if pkt.AAC != nil {
+			return aac.PacketDuration(pkt.AAC.Config, pkt.Data)
+		}
+	case MP3:
+		return mp3.PacketDuration(pkt.Data)
+	case Opus:
+		return opus.PacketDuration(pkt.Data)
+	case ALAW, MULAW:
+		if pkt.G711 != nil {
+			return pkt.G711.PacketDuration(pkt.Data)
+		}
+		return g711.PacketDuration(g711.DefaultSampleRate, 1, pkt.Data)
+	case Speex:
+		return speex.PacketDuration(pkt.Data)
+	}
+	return 0
+}
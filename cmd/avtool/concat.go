@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/format"
+)
+
+func doConcat(dst string, srcs []string) (err error) {
+	foR := newFormatOpener()
+	foW := newFormatOpener()
+
+	cr := format.NewConcatReader(foR, srcs)
+	defer cr.Close()
+
+	streams, _ := cr.Streams()
+
+	var fw *format.Writer
+	if fw, err = foW.Create(dst); err != nil {
+		return
+	}
+	defer fw.Close()
+
+	fw.SetStreams(streams)
+
+	for {
+		var pkt av.Packet
+		if pkt, err = cr.ReadPacket(); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return
+		}
+		if err = fw.WritePacket(pkt); err != nil {
+			return
+		}
+	}
+}
@@ -31,6 +31,8 @@ func doConv(src, dst string) (err error) {
 		return
 	}
 
+	streams, _ := fr.Streams()
+
 	canRe := func() bool {
 		if optNativeRate {
 			return true
@@ -41,45 +43,51 @@ func doConv(src, dst string) (err error) {
 		return false
 	}
 
-	for {
-		var pkt av.Packet
-		if pkt, err = fr.ReadPacket(); err != nil {
-			if err == io.EOF {
-				err = nil
-			}
-			return
-		}
-
-		pkts := []av.Packet{pkt}
-
-		if fn := onPkt; fn != nil {
+	pl := pktop.NewPipeline()
+	if fn := onPkt; fn != nil {
+		pl.Append(pktop.FilterFunc(func(pkts []av.Packet) []av.Packet {
 			for _, pkt := range pkts {
 				fn(pkt)
 			}
-		}
-
+			return pkts
+		}))
+	}
+	pl.Append(pktop.FilterFunc(func(pkts []av.Packet) []av.Packet {
 		if re == nil && canRe() {
 			re = pktop.NewNativeRateLimiter()
 		}
 		if re != nil {
 			pkts = re.Do(pkts)
 		}
+		return pkts
+	}))
+
+	r := pl.Reader(fr)
 
-		for _, pkt := range pkts {
-			if !optDontPrintPkt {
-				fmt.Println(pkt.String())
+	for {
+		var pkt av.Packet
+		if pkt, err = r.ReadPacket(); err != nil {
+			if err == io.EOF {
+				err = nil
 			}
+			return
+		}
 
-			if dst != "" && fw == nil {
-				if fw, err = foW.Create(dst); err != nil {
-					return
-				}
+		if !optDontPrintPkt {
+			fmt.Println(pkt.String())
+		}
+
+		if dst != "" && fw == nil {
+			if fw, err = foW.Create(dst); err != nil {
+				return
 			}
+			defer fw.Close()
+			fw.SetStreams(streams)
+		}
 
-			if fw != nil {
-				if err = fw.WritePacket(pkt); err != nil {
-					return
-				}
+		if fw != nil {
+			if err = fw.WritePacket(pkt); err != nil {
+				return
 			}
 		}
 	}
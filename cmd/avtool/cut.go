@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/av/pktop"
+	"github.com/nareix/joy5/format"
+)
+
+var optCutStart time.Duration
+var optCutEnd time.Duration
+
+func doCut(src, dst string) (err error) {
+	foR := newFormatOpener()
+	foW := newFormatOpener()
+
+	var fr *format.Reader
+	var fw *format.Writer
+
+	if fr, err = foR.Open(src); err != nil {
+		return
+	}
+	defer fr.Close()
+
+	streams, _ := fr.Streams()
+
+	if fw, err = foW.Create(dst); err != nil {
+		return
+	}
+	defer fw.Close()
+
+	fw.SetStreams(streams)
+
+	trim := pktop.NewTrim(optCutStart, optCutEnd)
+	w := pktop.NewPipeline(trim).Writer(fw)
+
+	for !trim.Done() {
+		var pkt av.Packet
+		if pkt, err = fr.ReadPacket(); err != nil {
+			if err != io.EOF {
+				return
+			}
+			break
+		}
+		if err = w.WritePacket(pkt); err != nil {
+			return
+		}
+	}
+
+	return w.Flush()
+}
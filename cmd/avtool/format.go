@@ -8,6 +8,7 @@ import (
 	"github.com/nareix/joy5/format"
 	"github.com/nareix/joy5/format/flv"
 	"github.com/nareix/joy5/format/flv/flvio"
+	"github.com/nareix/joy5/format/hls"
 	"github.com/nareix/joy5/format/rtmp"
 )
 
@@ -78,6 +79,30 @@ func handleFlvDemuxerFlags(r *flv.Demuxer) {
 	}
 }
 
+var optHlsTargetDuration = hls.DefaultTargetDuration
+var optHlsListSize = hls.DefaultListSize
+var optHlsType = "live"
+
+var hlsTypes = map[string]int{
+	"live":  hls.PLAYLIST_LIVE,
+	"event": hls.PLAYLIST_EVENT,
+	"vod":   hls.PLAYLIST_VOD,
+}
+
+func checkHlsFlags() (err error) {
+	if _, ok := hlsTypes[optHlsType]; !ok {
+		err = fmt.Errorf("unknown hls type %q", optHlsType)
+		return
+	}
+	return
+}
+
+func handleHlsWriterFlags(w *hls.Writer) {
+	w.TargetDuration = optHlsTargetDuration
+	w.ListSize = optHlsListSize
+	w.Type = hlsTypes[optHlsType]
+}
+
 func newFormatOpener() *format.URLOpener {
 	fo := &format.URLOpener{
 		OnNewFlvDemuxer: func(r *flv.Demuxer) {
@@ -92,6 +117,9 @@ func newFormatOpener() *format.URLOpener {
 		OnNewRtmpClient: func(c *rtmp.Client) {
 			handleRtmpClientFlags(c)
 		},
+		OnNewHlsWriter: func(w *hls.Writer) {
+			handleHlsWriterFlags(w)
+		},
 	}
 	return fo
 }
@@ -51,6 +51,10 @@ func main() {
 				cmd.Help()
 				os.Exit(1)
 			}
+			if err := checkHlsFlags(); err != nil {
+				log.Println(err)
+				os.Exit(1)
+			}
 			if err := fn(cmd, args); err != nil {
 				log.Println(err)
 				os.Exit(1)
@@ -133,6 +137,24 @@ func main() {
 		}),
 	}
 
+	cmdCut := &cobra.Command{
+		Use:   "cut SRC DST",
+		Short: "cut src from the keyframe at or before start to end",
+		Args:  cobra.MinimumNArgs(2),
+		Run: run(func(cmd *cobra.Command, args []string) error {
+			return doCut(args[0], args[1])
+		}),
+	}
+
+	cmdConcat := &cobra.Command{
+		Use:   "concat DST SRC...",
+		Short: "play srcs back to back into dst",
+		Args:  cobra.MinimumNArgs(2),
+		Run: run(func(cmd *cobra.Command, args []string) error {
+			return doConcat(args[0], args[1:])
+		}),
+	}
+
 	addDebugFlags := func(fs *pflag.FlagSet) {
 		debugFlags.AddOpt(fs, "drtmp", debugRtmpOptsMap)
 		debugFlags.AddOpt(fs, "dflv", debugFlvOptsMap)
@@ -141,9 +163,23 @@ func main() {
 	addDebugFlags(cmdBenchRtmp.Flags())
 	addDebugFlags(cmdForwardRtmp.Flags())
 	addDebugFlags(cmdPubsubRtmp.Flags())
+	addDebugFlags(cmdCut.Flags())
+	addDebugFlags(cmdConcat.Flags())
 	cmdConv.Flags().BoolVar(&optPrintStatSec, "statsec", false, "print stat per second")
 	cmdConv.Flags().BoolVar(&optNativeRate, "re", false, "native rate")
 	cmdConv.Flags().BoolVar(&optDontPrintPkt, "qpkt", false, "don't print pkt")
+	addHlsFlags := func(fs *pflag.FlagSet) {
+		fs.DurationVar(&optHlsTargetDuration, "hlstime", optHlsTargetDuration, "hls segment target duration")
+		fs.IntVar(&optHlsListSize, "hlslist", optHlsListSize, "hls live playlist size")
+		fs.StringVar(&optHlsType, "hlstype", optHlsType, "hls playlist type: live, event, vod")
+	}
+	addHlsFlags(cmdConv.Flags())
+	addHlsFlags(cmdCut.Flags())
+	addHlsFlags(cmdConcat.Flags())
+	cmdCut.Flags().DurationVar(&optCutStart, "start", 0, "start time")
+	cmdCut.Flags().DurationVar(&optCutEnd, "end", 0, "end time, 0 means to the end")
+	cmdPubsubRtmp.Flags().DurationVar(&optSubMaxLag, "maxlag", 0, "skip to latest keyframe when a sub falls behind more than this")
+	cmdPubsubRtmp.Flags().DurationVar(&optSubNonRefLag, "nonreflag", 0, "drop h264 non-reference frames when a sub falls behind more than this")
 
 	rootCmd := &cobra.Command{Use: "avtool"}
 	rootCmd.AddCommand(cmdConv)
@@ -153,5 +189,7 @@ func main() {
 	rootCmd.AddCommand(cmdAvcc2Annexb)
 	rootCmd.AddCommand(cmdMoveH264SeqhdrToKeyFrame)
 	rootCmd.AddCommand(cmdSkipGop)
+	rootCmd.AddCommand(cmdCut)
+	rootCmd.AddCommand(cmdConcat)
 	rootCmd.Execute()
 }
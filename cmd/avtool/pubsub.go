@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"log"
 	"net"
@@ -11,138 +10,30 @@ import (
 	"unsafe"
 
 	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/av/gopcache"
+	"github.com/nareix/joy5/av/pktop"
 	"github.com/nareix/joy5/format/rtmp"
 )
 
-type gopCacheSnapshot struct {
-	pkts []av.Packet
-	idx  int
-}
-
-type gopCache struct {
-	pkts  []av.Packet
-	idx   int
-	curst unsafe.Pointer
-}
-
-func (gc *gopCache) put(pkt av.Packet) {
-	if pkt.IsKeyFrame {
-		gc.pkts = []av.Packet{}
-	}
-	gc.pkts = append(gc.pkts, pkt)
-	gc.idx++
-	st := &gopCacheSnapshot{
-		pkts: gc.pkts,
-		idx:  gc.idx,
-	}
-	atomic.StorePointer(&gc.curst, unsafe.Pointer(st))
-}
-
-func (gc *gopCache) curSnapshot() *gopCacheSnapshot {
-	return (*gopCacheSnapshot)(atomic.LoadPointer(&gc.curst))
-}
-
-type gopCacheReadCursor struct {
-	lastidx int
-}
+var optSubMaxLag time.Duration
+var optSubNonRefLag time.Duration
 
-func (rc *gopCacheReadCursor) advance(cur *gopCacheSnapshot) []av.Packet {
-	lastidx := rc.lastidx
-	rc.lastidx = cur.idx
-	if diff := cur.idx - lastidx; diff <= len(cur.pkts) {
-		return cur.pkts[len(cur.pkts)-diff:]
-	} else {
-		return cur.pkts
+func logSubDrops(rc *gopcache.Cursor) {
+	if rc == nil {
+		return
 	}
-}
-
-type mergeSeqhdr struct {
-	cb     func(av.Packet)
-	hdrpkt av.Packet
-}
-
-func (m *mergeSeqhdr) do(pkt av.Packet) {
-	switch pkt.Type {
-	case av.H264DecoderConfig:
-		m.hdrpkt.VSeqHdr = append([]byte(nil), pkt.Data...)
-	case av.H264:
-		pkt.Metadata = m.hdrpkt.Metadata
-		if pkt.IsKeyFrame {
-			pkt.VSeqHdr = m.hdrpkt.VSeqHdr
-		}
-		m.cb(pkt)
-	case av.AACDecoderConfig:
-		m.hdrpkt.ASeqHdr = append([]byte(nil), pkt.Data...)
-	case av.AAC:
-		pkt.Metadata = m.hdrpkt.Metadata
-		pkt.ASeqHdr = m.hdrpkt.ASeqHdr
-		m.cb(pkt)
-	case av.Metadata:
-		m.hdrpkt.Metadata = pkt.Data
+	if rc.Dropped+rc.Skipped+rc.NonRefDropped > 0 {
+		log.Println("sub dropped", rc.Dropped, "skipped", rc.Skipped, "nonref", rc.NonRefDropped)
 	}
 }
 
-type splitSeqhdr struct {
-	cb     func(av.Packet) error
-	hdrpkt av.Packet
-}
-
-func (s *splitSeqhdr) sendmeta(pkt av.Packet) error {
-	if bytes.Compare(s.hdrpkt.Metadata, pkt.Metadata) != 0 {
-		if err := s.cb(av.Packet{
-			Type: av.Metadata,
-			Data: pkt.Metadata,
-		}); err != nil {
-			return err
-		}
-		s.hdrpkt.Metadata = pkt.Metadata
-	}
-	return nil
-}
-
-func (s *splitSeqhdr) do(pkt av.Packet) error {
-	switch pkt.Type {
-	case av.H264:
-		if err := s.sendmeta(pkt); err != nil {
-			return err
-		}
-		if pkt.IsKeyFrame {
-			if bytes.Compare(s.hdrpkt.VSeqHdr, pkt.VSeqHdr) != 0 {
-				if err := s.cb(av.Packet{
-					Type: av.H264DecoderConfig,
-					Data: pkt.VSeqHdr,
-				}); err != nil {
-					return err
-				}
-				s.hdrpkt.VSeqHdr = pkt.VSeqHdr
-			}
-		}
-		return s.cb(pkt)
-	case av.AAC:
-		if err := s.sendmeta(pkt); err != nil {
-			return err
-		}
-		if bytes.Compare(s.hdrpkt.ASeqHdr, pkt.ASeqHdr) != 0 {
-			if err := s.cb(av.Packet{
-				Type: av.AACDecoderConfig,
-				Data: pkt.ASeqHdr,
-			}); err != nil {
-				return err
-			}
-			s.hdrpkt.ASeqHdr = pkt.ASeqHdr
-		}
-		return s.cb(pkt)
-	}
-	return nil
-}
-
 type streamSub struct {
 	notify chan struct{}
 }
 
 type streamPub struct {
 	cancel func()
-	gc     *gopCache
+	gc     *gopcache.Cache
 }
 
 type stream struct {
@@ -151,14 +42,6 @@ type stream struct {
 	pub unsafe.Pointer
 }
 
-func (s *stream) curGopCacheSnapshot() *gopCacheSnapshot {
-	sp := (*streamPub)(atomic.LoadPointer(&s.pub))
-	if sp == nil {
-		return nil
-	}
-	return sp.gc.curSnapshot()
-}
-
 func (s *stream) addSub(close <-chan bool, w av.PacketWriter) {
 	ss := &streamSub{
 		notify: make(chan struct{}, 1),
@@ -167,27 +50,30 @@ func (s *stream) addSub(close <-chan bool, w av.PacketWriter) {
 	s.sub.Store(ss, nil)
 	defer s.sub.Delete(ss)
 
-	var cursor *gopCacheReadCursor
+	var cursor *gopcache.Cursor
 	var lastsp *streamPub
+	defer func() {
+		logSubDrops(cursor)
+	}()
 
-	seqsplit := splitSeqhdr{
-		cb: func(pkt av.Packet) error {
-			return w.WritePacket(pkt)
-		},
-	}
+	seqsplit := pktop.NewSplitSeqhdr()
 
 	for {
 		var pkts []av.Packet
 
 		sp := (*streamPub)(atomic.LoadPointer(&s.pub))
 		if sp != lastsp {
-			cursor = &gopCacheReadCursor{}
+			logSubDrops(cursor)
+			cursor = &gopcache.Cursor{
+				MaxLag:    optSubMaxLag,
+				NonRefLag: optSubNonRefLag,
+			}
 			lastsp = sp
 		}
 		if sp != nil {
-			cur := sp.gc.curSnapshot()
+			cur := sp.gc.Snapshot()
 			if cur != nil {
-				pkts = cursor.advance(cur)
+				pkts = cursor.Advance(cur)
 			}
 		}
 
@@ -198,8 +84,8 @@ func (s *stream) addSub(close <-chan bool, w av.PacketWriter) {
 			case <-ss.notify:
 			}
 		} else {
-			for _, pkt := range pkts {
-				if err := seqsplit.do(pkt); err != nil {
+			for _, pkt := range seqsplit.Do(pkts) {
+				if err := w.WritePacket(pkt); err != nil {
 					return
 				}
 			}
@@ -224,7 +110,7 @@ func (s *stream) setPub(r av.PacketReader) {
 
 	sp := &streamPub{
 		cancel: cancel,
-		gc:     &gopCache{},
+		gc:     gopcache.New(),
 	}
 
 	oldsp := (*streamPub)(atomic.SwapPointer(&s.pub, unsafe.Pointer(sp)))
@@ -232,12 +118,7 @@ func (s *stream) setPub(r av.PacketReader) {
 		oldsp.cancel()
 	}
 
-	seqmerge := mergeSeqhdr{
-		cb: func(pkt av.Packet) {
-			sp.gc.put(pkt)
-			s.notifySub()
-		},
-	}
+	seqmerge := pktop.NewMergeSeqhdr()
 
 	for {
 		select {
@@ -251,7 +132,10 @@ func (s *stream) setPub(r av.PacketReader) {
 			return
 		}
 
-		seqmerge.do(pkt)
+		for _, pkt := range seqmerge.Do([]av.Packet{pkt}) {
+			sp.gc.Put(pkt)
+			s.notifySub()
+		}
 	}
 }
 
@@ -11,48 +11,48 @@ import (
 
 // copied from libavcodec/mpeg4audio.h
 const (
-	AOT_AAC_MAIN        = 1 + iota  ///< Y                       Main
-	AOT_AAC_LC                      ///< Y                       Low Complexity
-	AOT_AAC_SSR                     ///< N (code in SoC repo)    Scalable Sample Rate
-	AOT_AAC_LTP                     ///< Y                       Long Term Prediction
-	AOT_SBR                         ///< Y                       Spectral Band Replication
-	AOT_AAC_SCALABLE                ///< N                       Scalable
-	AOT_TWINVQ                      ///< N                       Twin Vector Quantizer
-	AOT_CELP                        ///< N                       Code Excited Linear Prediction
-	AOT_HVXC                        ///< N                       Harmonic Vector eXcitation Coding
-	AOT_TTSI            = 12 + iota ///< N                       Text-To-Speech Interface
-	AOT_MAINSYNTH                   ///< N                       Main Synthesis
-	AOT_WAVESYNTH                   ///< N                       Wavetable Synthesis
-	AOT_MIDI                        ///< N                       General MIDI
-	AOT_SAFX                        ///< N                       Algorithmic Synthesis and Audio Effects
-	AOT_ER_AAC_LC                   ///< N                       Error Resilient Low Complexity
-	AOT_ER_AAC_LTP      = 19 + iota ///< N                       Error Resilient Long Term Prediction
-	AOT_ER_AAC_SCALABLE             ///< N                       Error Resilient Scalable
-	AOT_ER_TWINVQ                   ///< N                       Error Resilient Twin Vector Quantizer
-	AOT_ER_BSAC                     ///< N                       Error Resilient Bit-Sliced Arithmetic Coding
-	AOT_ER_AAC_LD                   ///< N                       Error Resilient Low Delay
-	AOT_ER_CELP                     ///< N                       Error Resilient Code Excited Linear Prediction
-	AOT_ER_HVXC                     ///< N                       Error Resilient Harmonic Vector eXcitation Coding
-	AOT_ER_HILN                     ///< N                       Error Resilient Harmonic and Individual Lines plus Noise
-	AOT_ER_PARAM                    ///< N                       Error Resilient Parametric
-	AOT_SSC                         ///< N                       SinuSoidal Coding
-	AOT_PS                          ///< N                       Parametric Stereo
-	AOT_SURROUND                    ///< N                       MPEG Surround
-	AOT_ESCAPE                      ///< Y                       Escape Value
-	AOT_L1                          ///< Y                       Layer 1
-	AOT_L2                          ///< Y                       Layer 2
-	AOT_L3                          ///< Y                       Layer 3
-	AOT_DST                         ///< N                       Direct Stream Transfer
-	AOT_ALS                         ///< Y                       Audio LosslesS
-	AOT_SLS                         ///< N                       Scalable LosslesS
-	AOT_SLS_NON_CORE                ///< N                       Scalable LosslesS (non core)
-	AOT_ER_AAC_ELD                  ///< N                       Error Resilient Enhanced Low Delay
-	AOT_SMR_SIMPLE                  ///< N                       Symbolic Music Representation Simple
-	AOT_SMR_MAIN                    ///< N                       Symbolic Music Representation Main
-	AOT_USAC_NOSBR                  ///< N                       Unified Speech and Audio Coding (no SBR)
-	AOT_SAOC                        ///< N                       Spatial Audio Object Coding
-	AOT_LD_SURROUND                 ///< N                       Low Delay MPEG Surround
-	AOT_USAC                        ///< N                       Unified Speech and Audio Coding
+	AOT_AAC_MAIN        = 1 + iota ///< Y                       Main
+	AOT_AAC_LC                     ///< Y                       Low Complexity
+	AOT_AAC_SSR                    ///< N (code in SoC repo)    Scalable Sample Rate
+	AOT_AAC_LTP                    ///< Y                       Long Term Prediction
+	AOT_SBR                        ///< Y                       Spectral Band Replication
+	AOT_AAC_SCALABLE               ///< N                       Scalable
+	AOT_TWINVQ                     ///< N                       Twin Vector Quantizer
+	AOT_CELP                       ///< N                       Code Excited Linear Prediction
+	AOT_HVXC                       ///< N                       Harmonic Vector eXcitation Coding
+	AOT_TTSI            = 3 + iota ///< N                       Text-To-Speech Interface
+	AOT_MAINSYNTH                  ///< N                       Main Synthesis
+	AOT_WAVESYNTH                  ///< N                       Wavetable Synthesis
+	AOT_MIDI                       ///< N                       General MIDI
+	AOT_SAFX                       ///< N                       Algorithmic Synthesis and Audio Effects
+	AOT_ER_AAC_LC                  ///< N                       Error Resilient Low Complexity
+	AOT_ER_AAC_LTP      = 4 + iota ///< N                       Error Resilient Long Term Prediction
+	AOT_ER_AAC_SCALABLE            ///< N                       Error Resilient Scalable
+	AOT_ER_TWINVQ                  ///< N                       Error Resilient Twin Vector Quantizer
+	AOT_ER_BSAC                    ///< N                       Error Resilient Bit-Sliced Arithmetic Coding
+	AOT_ER_AAC_LD                  ///< N                       Error Resilient Low Delay
+	AOT_ER_CELP                    ///< N                       Error Resilient Code Excited Linear Prediction
+	AOT_ER_HVXC                    ///< N                       Error Resilient Harmonic Vector eXcitation Coding
+	AOT_ER_HILN                    ///< N                       Error Resilient Harmonic and Individual Lines plus Noise
+	AOT_ER_PARAM                   ///< N                       Error Resilient Parametric
+	AOT_SSC                        ///< N                       SinuSoidal Coding
+	AOT_PS                         ///< N                       Parametric Stereo
+	AOT_SURROUND                   ///< N                       MPEG Surround
+	AOT_ESCAPE                     ///< Y                       Escape Value
+	AOT_L1                         ///< Y                       Layer 1
+	AOT_L2                         ///< Y                       Layer 2
+	AOT_L3                         ///< Y                       Layer 3
+	AOT_DST                        ///< N                       Direct Stream Transfer
+	AOT_ALS                        ///< Y                       Audio LosslesS
+	AOT_SLS                        ///< N                       Scalable LosslesS
+	AOT_SLS_NON_CORE               ///< N                       Scalable LosslesS (non core)
+	AOT_ER_AAC_ELD                 ///< N                       Error Resilient Enhanced Low Delay
+	AOT_SMR_SIMPLE                 ///< N                       Symbolic Music Representation Simple
+	AOT_SMR_MAIN                   ///< N                       Symbolic Music Representation Main
+	AOT_USAC_NOSBR                 ///< N                       Unified Speech and Audio Coding (no SBR)
+	AOT_SAOC                       ///< N                       Spatial Audio Object Coding
+	AOT_LD_SURROUND                ///< N                       Low Delay MPEG Surround
+	AOT_USAC                       ///< N                       Unified Speech and Audio Coding
 )
 
 type ChannelLayout uint16
@@ -96,6 +96,29 @@ type MPEG4AudioConfig struct {
 	ObjectType      uint
 	SampleRateIndex uint
 	ChannelConfig   uint
+	FrameLengthFlag bool // 960 samples per frame instead of 1024
+	SBR             bool // HE-AAC, decoded at ExtSampleRate
+	PS              bool // HE-AACv2
+	ExtSampleRate   int
+	CoreObjectType  uint // under ObjectType AOT_SBR or AOT_PS, AOT_AAC_LC if zero
+}
+
+// FrameSamples returns samples per frame at SampleRate, the core sample rate
+// for HE-AAC.
+func (c MPEG4AudioConfig) FrameSamples() int {
+	if c.FrameLengthFlag {
+		return 960
+	}
+	return 1024
+}
+
+// OutputSampleRate returns the decoded sample rate, twice the core sample
+// rate for HE-AAC.
+func (c MPEG4AudioConfig) OutputSampleRate() int {
+	if c.SBR && c.ExtSampleRate > 0 {
+		return c.ExtSampleRate
+	}
+	return c.SampleRate
 }
 
 var sampleRateTable = []int{
@@ -194,7 +217,7 @@ func FillADTSHeader(header []byte, config MPEG4AudioConfig, samples int, payload
 	header[3] = header[3]&0xfc | byte(payloadLength>>11)&0x3
 	header[4] = byte(payloadLength >> 3)
 	header[5] = header[5]&0x1f | (byte(payloadLength)&0x7)<<5
-	header[6] = header[6]&0xfc | byte(samples/1024-1)
+	header[6] = header[6]&0xfc | byte(samples/config.FrameSamples()-1)&0x3
 	return
 }
 
@@ -290,9 +313,112 @@ func ParseMPEG4AudioConfigBytes(data []byte) (config MPEG4AudioConfig, err error
 	if err = config.complete(); err != nil {
 		return
 	}
+
+	// the rest is optional, a short config is valid
+	objectType := config.ObjectType
+	if objectType == AOT_SBR || objectType == AOT_PS {
+		// explicit hierarchical signalling
+		config.SBR = true
+		config.PS = objectType == AOT_PS
+		var index uint
+		if index, err = readSampleRateIndex(br); err != nil {
+			err = nil
+			return
+		}
+		if int(index) < len(sampleRateTable) {
+			config.ExtSampleRate = sampleRateTable[index]
+		}
+		if objectType, err = readObjectType(br); err != nil {
+			err = nil
+			return
+		}
+		config.CoreObjectType = objectType
+	}
+
+	switch objectType {
+	case AOT_AAC_MAIN, AOT_AAC_LC, AOT_AAC_SSR, AOT_AAC_LTP, AOT_AAC_SCALABLE, AOT_TWINVQ:
+	default:
+		return
+	}
+
+	// GASpecificConfig
+	var flag uint
+	if flag, err = br.ReadBits(1); err != nil {
+		err = nil
+		return
+	}
+	config.FrameLengthFlag = flag == 1
+	if flag, err = br.ReadBits(1); err != nil {
+		err = nil
+		return
+	}
+	if flag == 1 {
+		// coreCoderDelay
+		if _, err = br.ReadBits(14); err != nil {
+			err = nil
+			return
+		}
+	}
+	if flag, err = br.ReadBits(1); err != nil {
+		err = nil
+		return
+	}
+	if flag == 1 || config.ChannelConfig == 0 || config.SBR {
+		// extension fields or program_config_element not parsed
+		return
+	}
+
+	// backward compatible signalling in a sync extension, implicit SBR
+	// signalled in the frames only is not seen here
+	var sync uint
+	if sync, err = br.ReadBits(11); err != nil || sync != 0x2b7 {
+		err = nil
+		return
+	}
+	if objectType, err = readObjectType(br); err != nil || objectType != AOT_SBR {
+		err = nil
+		return
+	}
+	if flag, err = br.ReadBits(1); err != nil || flag == 0 {
+		err = nil
+		return
+	}
+	var index uint
+	if index, err = readSampleRateIndex(br); err != nil {
+		err = nil
+		return
+	}
+	config.SBR = true
+	if int(index) < len(sampleRateTable) {
+		config.ExtSampleRate = sampleRateTable[index]
+	}
+	if sync, err = br.ReadBits(11); err != nil || sync != 0x548 {
+		err = nil
+		return
+	}
+	if flag, err = br.ReadBits(1); err != nil {
+		err = nil
+		return
+	}
+	config.PS = flag == 1
 	return
 }
 
+// extSampleRateIndex returns the SBR sample rate index, twice the core
+// sample rate if ExtSampleRate is unset.
+func extSampleRateIndex(config MPEG4AudioConfig) uint {
+	rate := config.ExtSampleRate
+	if rate == 0 {
+		rate = config.SampleRate * 2
+	}
+	for i, r := range sampleRateTable {
+		if r == rate {
+			return uint(i)
+		}
+	}
+	return config.SampleRateIndex
+}
+
 func WriteMPEG4AudioConfig(w io.Writer, config MPEG4AudioConfig) (err error) {
 	bw := &bits.Writer{W: w}
 	if err = writeObjectType(bw, config.ObjectType); err != nil {
@@ -321,19 +447,94 @@ func WriteMPEG4AudioConfig(w io.Writer, config MPEG4AudioConfig) (err error) {
 		return
 	}
 
+	objectType := config.ObjectType
+	if objectType == AOT_SBR || objectType == AOT_PS {
+		if err = writeSampleRateIndex(bw, extSampleRateIndex(config)); err != nil {
+			return
+		}
+		if objectType = config.CoreObjectType; objectType == 0 {
+			objectType = AOT_AAC_LC
+		}
+		if err = writeObjectType(bw, objectType); err != nil {
+			return
+		}
+	}
+
+	switch objectType {
+	case AOT_AAC_MAIN, AOT_AAC_LC, AOT_AAC_SSR, AOT_AAC_LTP, AOT_AAC_SCALABLE, AOT_TWINVQ:
+		// GASpecificConfig, dependsOnCoreCoder and extensionFlag unset
+		var flag uint
+		if config.FrameLengthFlag {
+			flag = 1
+		}
+		if err = bw.WriteBits(flag<<2, 3); err != nil {
+			return
+		}
+	}
+
+	if config.SBR && objectType == config.ObjectType {
+		// backward compatible signalling
+		if err = bw.WriteBits(0x2b7, 11); err != nil {
+			return
+		}
+		if err = writeObjectType(bw, AOT_SBR); err != nil {
+			return
+		}
+		if err = bw.WriteBits(1, 1); err != nil {
+			return
+		}
+		if err = writeSampleRateIndex(bw, extSampleRateIndex(config)); err != nil {
+			return
+		}
+		if config.PS {
+			if err = bw.WriteBits(0x548, 11); err != nil {
+				return
+			}
+			if err = bw.WriteBits(1, 1); err != nil {
+				return
+			}
+		}
+	}
+
 	if err = bw.FlushBits(); err != nil {
 		return
 	}
 	return
 }
 
+// raw AAC-LC frames decoding to silence, by channel config
+var silentFrames = map[uint][]byte{
+	1: {0x00, 0xc8, 0x00, 0x80, 0x23, 0x80},
+	2: {0x21, 0x00, 0x49, 0x90, 0x02, 0x19, 0x00, 0x23, 0x80},
+	3: {0x00, 0xc8, 0x00, 0x80, 0x20, 0x84, 0x01, 0x26, 0x40, 0x08, 0x64, 0x00, 0x8e},
+	4: {0x00, 0xc8, 0x00, 0x80, 0x20, 0x84, 0x01, 0x26, 0x40, 0x08, 0x64, 0x00, 0x80, 0x2c, 0x80, 0x08, 0x02, 0x38},
+	5: {0x00, 0xc8, 0x00, 0x80, 0x20, 0x84, 0x01, 0x26, 0x40, 0x08, 0x64, 0x00, 0x82, 0x30, 0x04, 0x99, 0x00, 0x21, 0x90, 0x02, 0x38},
+	6: {0x00, 0xc8, 0x00, 0x80, 0x20, 0x84, 0x01, 0x26, 0x40, 0x08, 0x64, 0x00, 0x82, 0x30, 0x04, 0x99, 0x00, 0x21, 0x90, 0x02, 0x00, 0xb2, 0x00, 0x20, 0x08, 0xe0},
+}
+
+// SilentFrame returns a raw frame decoding to silence. Only AAC-LC with
+// 1024 samples per frame is supported, the frame does not depend on the
+// sample rate.
+func SilentFrame(config MPEG4AudioConfig) (b []byte, err error) {
+	if config.ObjectType != AOT_AAC_LC || config.SBR || config.FrameLengthFlag {
+		err = fmt.Errorf("aacparser: no silent frame for object type %d", config.ObjectType)
+		return
+	}
+	var ok bool
+	if b, ok = silentFrames[config.ChannelConfig]; !ok {
+		err = fmt.Errorf("aacparser: no silent frame for channel config %d", config.ChannelConfig)
+		return
+	}
+	return
+}
+
 type Codec struct {
 	ConfigBytes []byte
 	Config      MPEG4AudioConfig
 }
 
 func PacketDuration(config MPEG4AudioConfig, data []byte) (dur time.Duration) {
-	return time.Duration(1024) * time.Second / time.Duration(config.SampleRate)
+	return time.Duration(config.FrameSamples()) * time.Second / time.Duration(config.SampleRate)
 }
 
 func FromMPEG4AudioConfigBytes(b []byte) (c *Codec, err error) {
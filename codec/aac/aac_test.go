@@ -0,0 +1,118 @@
+package aac
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestObjectTypes(t *testing.T) {
+	// ISO 14496-3 Table 1.1
+	for _, c := range []struct{ got, want uint }{
+		{AOT_HVXC, 9}, {AOT_TTSI, 12}, {AOT_ER_AAC_LC, 17}, {AOT_ER_AAC_LTP, 19},
+		{AOT_PS, 29}, {AOT_ESCAPE, 31}, {AOT_L1, 32}, {AOT_ER_AAC_ELD, 39}, {AOT_USAC, 45},
+	} {
+		if c.got != c.want {
+			t.Fatalf("object type %d, want %d", c.got, c.want)
+		}
+	}
+}
+
+func TestEscapeObjectType(t *testing.T) {
+	// ER AAC ELD, 48000Hz, stereo
+	data := []byte{0xf8, 0xe6, 0x40}
+	config, err := ParseMPEG4AudioConfigBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ObjectType != AOT_ER_AAC_ELD || config.SampleRate != 48000 || config.ChannelConfig != 2 {
+		t.Fatalf("config %+v", config)
+	}
+	buf := &bytes.Buffer{}
+	if err := WriteMPEG4AudioConfig(buf, config); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("wrote %x, want %x", buf.Bytes(), data)
+	}
+}
+
+func TestMPEG4AudioConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		data   []byte
+		config MPEG4AudioConfig
+	}{
+		{
+			name: "lc",
+			data: []byte{0x12, 0x10},
+			config: MPEG4AudioConfig{
+				ObjectType: AOT_AAC_LC, SampleRateIndex: 4, SampleRate: 44100,
+				ChannelConfig: 2, ChannelLayout: CH_STEREO,
+			},
+		},
+		{
+			name: "960 samples",
+			data: []byte{0x12, 0x14},
+			config: MPEG4AudioConfig{
+				ObjectType: AOT_AAC_LC, SampleRateIndex: 4, SampleRate: 44100,
+				ChannelConfig: 2, ChannelLayout: CH_STEREO, FrameLengthFlag: true,
+			},
+		},
+		{
+			name: "he-aac explicit",
+			data: []byte{0x2b, 0x11, 0x88, 0x00},
+			config: MPEG4AudioConfig{
+				ObjectType: AOT_SBR, SampleRateIndex: 6, SampleRate: 24000,
+				ChannelConfig: 2, ChannelLayout: CH_STEREO,
+				SBR: true, ExtSampleRate: 48000, CoreObjectType: AOT_AAC_LC,
+			},
+		},
+		{
+			name: "he-aacv2 explicit",
+			data: []byte{0xeb, 0x09, 0x88, 0x00},
+			config: MPEG4AudioConfig{
+				ObjectType: AOT_PS, SampleRateIndex: 6, SampleRate: 24000,
+				ChannelConfig: 1, ChannelLayout: CH_MONO,
+				SBR: true, PS: true, ExtSampleRate: 48000, CoreObjectType: AOT_AAC_LC,
+			},
+		},
+		{
+			name: "he-aacv2 backward compatible",
+			data: []byte{0x13, 0x10, 0x56, 0xe5, 0x9d, 0x48, 0x80},
+			config: MPEG4AudioConfig{
+				ObjectType: AOT_AAC_LC, SampleRateIndex: 6, SampleRate: 24000,
+				ChannelConfig: 2, ChannelLayout: CH_STEREO,
+				SBR: true, PS: true, ExtSampleRate: 48000,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseMPEG4AudioConfigBytes(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config != tt.config {
+				t.Fatalf("config %+v, want %+v", config, tt.config)
+			}
+			buf := &bytes.Buffer{}
+			if err := WriteMPEG4AudioConfig(buf, config); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), tt.data) {
+				t.Fatalf("wrote %x, want %x", buf.Bytes(), tt.data)
+			}
+		})
+	}
+}
+
+func TestFrameSamples(t *testing.T) {
+	config, err := ParseMPEG4AudioConfigBytes([]byte{0x2b, 0x11, 0x88, 0x00})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.FrameSamples() != 1024 || config.OutputSampleRate() != 48000 {
+		t.Fatalf("frame samples %d output rate %d", config.FrameSamples(), config.OutputSampleRate())
+	}
+}
@@ -0,0 +1,782 @@
+package av1
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/nareix/joy5/utils/bits"
+	"github.com/nareix/joy5/utils/bits/pio"
+)
+
+const (
+	OBU_SEQUENCE_HEADER        = 1
+	OBU_TEMPORAL_DELIMITER     = 2
+	OBU_FRAME_HEADER           = 3
+	OBU_TILE_GROUP             = 4
+	OBU_METADATA               = 5
+	OBU_FRAME                  = 6
+	OBU_REDUNDANT_FRAME_HEADER = 7
+	OBU_TILE_LIST              = 8
+	OBU_PADDING                = 15
+)
+
+const (
+	PROFILE_MAIN         = 0
+	PROFILE_HIGH         = 1
+	PROFILE_PROFESSIONAL = 2
+)
+
+var ProfileMap = map[uint]string{
+	PROFILE_MAIN:         "Main",
+	PROFILE_HIGH:         "High",
+	PROFILE_PROFESSIONAL: "Professional",
+}
+
+const (
+	CP_BT_709      = 1
+	CP_UNSPECIFIED = 2
+	TC_UNSPECIFIED = 2
+	TC_SRGB        = 13
+	MC_IDENTITY    = 0
+	MC_UNSPECIFIED = 2
+)
+
+var TemporalDelimiter = []byte{0x12, 0x00}
+
+func OBUType(b []byte) byte {
+	if len(b) == 0 {
+		return 0
+	}
+	return (b[0] >> 3) & 0xf
+}
+
+func OBUTypeString(i byte) string {
+	switch i {
+	case OBU_SEQUENCE_HEADER:
+		return "SequenceHeader"
+	case OBU_TEMPORAL_DELIMITER:
+		return "TemporalDelimiter"
+	case OBU_FRAME_HEADER:
+		return "FrameHeader"
+	case OBU_TILE_GROUP:
+		return "TileGroup"
+	case OBU_METADATA:
+		return "Metadata"
+	case OBU_FRAME:
+		return "Frame"
+	case OBU_REDUNDANT_FRAME_HEADER:
+		return "RedundantFrameHeader"
+	case OBU_TILE_LIST:
+		return "TileList"
+	case OBU_PADDING:
+		return "Padding"
+	default:
+		return fmt.Sprint(i)
+	}
+}
+
+func ReadLEB128(b []byte, n *int) (v uint64, err error) {
+	for i := 0; i < 8; i++ {
+		var c uint8
+		if c, err = pio.ReadU8(b, n); err != nil {
+			return
+		}
+		v |= uint64(c&0x7f) << (uint(i) * 7)
+		if c&0x80 == 0 {
+			return
+		}
+	}
+	err = fmt.Errorf("av1: leb128 too long")
+	return
+}
+
+func WriteLEB128(b []byte, n *int, v uint64) {
+	for {
+		c := uint8(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		pio.WriteU8(b, n, c)
+		if v == 0 {
+			return
+		}
+	}
+}
+
+/*
+	obu_header() {
+		obu_forbidden_bit f(1)
+		obu_type f(4)
+		obu_extension_flag f(1)
+		obu_has_size_field f(1)
+		obu_reserved_1bit f(1)
+		if ( obu_extension_flag == 1 ) {
+			temporal_id f(3)
+			spatial_id f(2)
+			extension_header_reserved_3bits f(3)
+		}
+	}
+*/
+type OBUHeader struct {
+	Type         byte
+	HasExtension bool
+	HasSize      bool
+	TemporalId   uint8
+	SpatialId    uint8
+}
+
+// ParseOBUHeader returns the OBU header, the header length (including the
+// leb128 size field) and the payload size.
+func ParseOBUHeader(b []byte) (h OBUHeader, hdrlen int, size int, err error) {
+	n := 0
+	var v uint8
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	if v&0x80 != 0 {
+		err = fmt.Errorf("av1: obu forbidden bit set")
+		return
+	}
+	h.Type = (v >> 3) & 0xf
+	h.HasExtension = v&0x4 != 0
+	h.HasSize = v&0x2 != 0
+
+	if h.HasExtension {
+		if v, err = pio.ReadU8(b, &n); err != nil {
+			return
+		}
+		h.TemporalId = v >> 5
+		h.SpatialId = (v >> 3) & 0x3
+	}
+
+	if h.HasSize {
+		var u uint64
+		if u, err = ReadLEB128(b, &n); err != nil {
+			return
+		}
+		size = int(u)
+	} else {
+		size = len(b) - n
+	}
+
+	if n+size > len(b) {
+		err = fmt.Errorf("av1: obu size %d exceeds buffer", size)
+		return
+	}
+	hdrlen = n
+	return
+}
+
+// SplitOBUs splits a low overhead bitstream into OBUs, each including its header.
+func SplitOBUs(b []byte) (obus [][]byte, err error) {
+	for len(b) > 0 {
+		var hdrlen, size int
+		if _, hdrlen, size, err = ParseOBUHeader(b); err != nil {
+			return
+		}
+		obus = append(obus, b[:hdrlen+size])
+		b = b[hdrlen+size:]
+	}
+	return
+}
+
+// OBUPayload returns the OBU data after the header and size field.
+func OBUPayload(obu []byte) (payload []byte, err error) {
+	var hdrlen, size int
+	if _, hdrlen, size, err = ParseOBUHeader(obu); err != nil {
+		return
+	}
+	payload = obu[hdrlen : hdrlen+size]
+	return
+}
+
+// JoinOBUs writes OBUs back as a low overhead bitstream. OBUs without
+// obu_has_size_field get a size field added.
+func JoinOBUs(obus [][]byte) []byte {
+	out := []byte{}
+	for _, obu := range obus {
+		h, hdrlen, size, err := ParseOBUHeader(obu)
+		if err != nil {
+			continue
+		}
+		if h.HasSize {
+			out = append(out, obu[:hdrlen+size]...)
+			continue
+		}
+		out = append(out, obu[0]|0x2)
+		if h.HasExtension {
+			out = append(out, obu[1])
+		}
+		b := make([]byte, 8)
+		n := 0
+		WriteLEB128(b, &n, uint64(size))
+		out = append(out, b[:n]...)
+		out = append(out, obu[hdrlen:hdrlen+size]...)
+	}
+	return out
+}
+
+func FindOBU(b []byte, typ byte) []byte {
+	obus, _ := SplitOBUs(b)
+	for _, obu := range obus {
+		if OBUType(obu) == typ {
+			return obu
+		}
+	}
+	return nil
+}
+
+type ColorConfig struct {
+	BitDepth                uint
+	MonoChrome              uint
+	ColorDescriptionPresent uint
+	ColorPrimaries          uint
+	TransferCharacteristics uint
+	MatrixCoefficients      uint
+	ColorRange              uint
+	SubsamplingX            uint
+	SubsamplingY            uint
+	ChromaSamplePosition    uint
+	SeparateUVDeltaQ        uint
+}
+
+type SequenceHeader struct {
+	Profile                   uint
+	StillPicture              uint
+	ReducedStillPictureHeader uint
+	LevelIdx                  uint // seq_level_idx[0]
+	Tier                      uint // seq_tier[0]
+
+	InitialDisplayDelayPresent uint
+	InitialDisplayDelayMinus1  uint // of operating point 0
+
+	NumUnitsInDisplayTick uint
+	TimeScale             uint
+
+	MaxFrameWidthMinus1  uint
+	MaxFrameHeightMinus1 uint
+
+	ColorConfig
+	FilmGrainParamsPresent uint
+
+	Width  uint
+	Height uint
+}
+
+func readUVLC(r *bits.GolombBitReader) (v uint, err error) {
+	leadingZeros := 0
+	for {
+		var b uint
+		if b, err = r.ReadBit(); err != nil {
+			return
+		}
+		if b != 0 {
+			break
+		}
+		leadingZeros++
+	}
+	if leadingZeros >= 32 {
+		v = 1<<32 - 1
+		return
+	}
+	if v, err = r.ReadBits(leadingZeros); err != nil {
+		return
+	}
+	v += 1<<uint(leadingZeros) - 1
+	return
+}
+
+func readBitsList(r *bits.GolombBitReader, lens ...int) (err error) {
+	for _, l := range lens {
+		if _, err = r.ReadBits(l); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// ParseSequenceHeader parses a sequence header OBU, header included.
+func ParseSequenceHeader(obu []byte) (s SequenceHeader, err error) {
+	var h OBUHeader
+	var hdrlen, size int
+	if h, hdrlen, size, err = ParseOBUHeader(obu); err != nil {
+		return
+	}
+	if h.Type != OBU_SEQUENCE_HEADER {
+		err = fmt.Errorf("av1: not sequence header obu")
+		return
+	}
+	return ParseSequenceHeaderPayload(obu[hdrlen : hdrlen+size])
+}
+
+func ParseSequenceHeaderPayload(data []byte) (s SequenceHeader, err error) {
+	r := &bits.GolombBitReader{R: bytes.NewReader(data)}
+
+	if s.Profile, err = r.ReadBits(3); err != nil {
+		return
+	}
+	if s.StillPicture, err = r.ReadBit(); err != nil {
+		return
+	}
+	if s.ReducedStillPictureHeader, err = r.ReadBit(); err != nil {
+		return
+	}
+
+	if s.ReducedStillPictureHeader != 0 {
+		if s.LevelIdx, err = r.ReadBits(5); err != nil {
+			return
+		}
+	} else {
+		var timingInfoPresent, decoderModelInfoPresent uint
+		var bufferDelayLengthMinus1 uint
+
+		if timingInfoPresent, err = r.ReadBit(); err != nil {
+			return
+		}
+		if timingInfoPresent != 0 {
+			if s.NumUnitsInDisplayTick, err = r.ReadBits(32); err != nil {
+				return
+			}
+			if s.TimeScale, err = r.ReadBits(32); err != nil {
+				return
+			}
+			var equalPictureInterval uint
+			if equalPictureInterval, err = r.ReadBit(); err != nil {
+				return
+			}
+			if equalPictureInterval != 0 {
+				// num_ticks_per_picture_minus_1
+				if _, err = readUVLC(r); err != nil {
+					return
+				}
+			}
+
+			if decoderModelInfoPresent, err = r.ReadBit(); err != nil {
+				return
+			}
+			if decoderModelInfoPresent != 0 {
+				if bufferDelayLengthMinus1, err = r.ReadBits(5); err != nil {
+					return
+				}
+				// num_units_in_decoding_tick
+				// buffer_removal_time_length_minus_1
+				// frame_presentation_time_length_minus_1
+				if err = readBitsList(r, 32, 5, 5); err != nil {
+					return
+				}
+			}
+		}
+
+		if s.InitialDisplayDelayPresent, err = r.ReadBit(); err != nil {
+			return
+		}
+
+		var operatingPointsCntMinus1 uint
+		if operatingPointsCntMinus1, err = r.ReadBits(5); err != nil {
+			return
+		}
+
+		for i := uint(0); i <= operatingPointsCntMinus1; i++ {
+			// operating_point_idc
+			if _, err = r.ReadBits(12); err != nil {
+				return
+			}
+			var levelIdx, tier uint
+			if levelIdx, err = r.ReadBits(5); err != nil {
+				return
+			}
+			if levelIdx > 7 {
+				if tier, err = r.ReadBit(); err != nil {
+					return
+				}
+			}
+			if i == 0 {
+				s.LevelIdx = levelIdx
+				s.Tier = tier
+			}
+
+			if decoderModelInfoPresent != 0 {
+				var decoderModelPresent uint
+				if decoderModelPresent, err = r.ReadBit(); err != nil {
+					return
+				}
+				if decoderModelPresent != 0 {
+					n := int(bufferDelayLengthMinus1 + 1)
+					// decoder_buffer_delay, encoder_buffer_delay, low_delay_mode_flag
+					if err = readBitsList(r, n, n, 1); err != nil {
+						return
+					}
+				}
+			}
+
+			if s.InitialDisplayDelayPresent != 0 {
+				var present uint
+				if present, err = r.ReadBit(); err != nil {
+					return
+				}
+				if present != 0 {
+					var v uint
+					if v, err = r.ReadBits(4); err != nil {
+						return
+					}
+					if i == 0 {
+						s.InitialDisplayDelayMinus1 = v
+					}
+				}
+			}
+		}
+	}
+
+	var frameWidthBitsMinus1, frameHeightBitsMinus1 uint
+	if frameWidthBitsMinus1, err = r.ReadBits(4); err != nil {
+		return
+	}
+	if frameHeightBitsMinus1, err = r.ReadBits(4); err != nil {
+		return
+	}
+	if s.MaxFrameWidthMinus1, err = r.ReadBits(int(frameWidthBitsMinus1 + 1)); err != nil {
+		return
+	}
+	if s.MaxFrameHeightMinus1, err = r.ReadBits(int(frameHeightBitsMinus1 + 1)); err != nil {
+		return
+	}
+	s.Width = s.MaxFrameWidthMinus1 + 1
+	s.Height = s.MaxFrameHeightMinus1 + 1
+
+	if s.ReducedStillPictureHeader == 0 {
+		var frameIdNumbersPresent uint
+		if frameIdNumbersPresent, err = r.ReadBit(); err != nil {
+			return
+		}
+		if frameIdNumbersPresent != 0 {
+			// delta_frame_id_length_minus_2, additional_frame_id_length_minus_1
+			if err = readBitsList(r, 4, 3); err != nil {
+				return
+			}
+		}
+	}
+
+	// use_128x128_superblock, enable_filter_intra, enable_intra_edge_filter
+	if err = readBitsList(r, 1, 1, 1); err != nil {
+		return
+	}
+
+	if s.ReducedStillPictureHeader == 0 {
+		// enable_interintra_compound, enable_masked_compound
+		// enable_warped_motion, enable_dual_filter
+		if err = readBitsList(r, 1, 1, 1, 1); err != nil {
+			return
+		}
+		var enableOrderHint uint
+		if enableOrderHint, err = r.ReadBit(); err != nil {
+			return
+		}
+		if enableOrderHint != 0 {
+			// enable_jnt_comp, enable_ref_frame_mvs
+			if err = readBitsList(r, 1, 1); err != nil {
+				return
+			}
+		}
+
+		var chooseScreenContentTools uint
+		if chooseScreenContentTools, err = r.ReadBit(); err != nil {
+			return
+		}
+		forceScreenContentTools := uint(2)
+		if chooseScreenContentTools == 0 {
+			if forceScreenContentTools, err = r.ReadBit(); err != nil {
+				return
+			}
+		}
+		if forceScreenContentTools > 0 {
+			var chooseIntegerMv uint
+			if chooseIntegerMv, err = r.ReadBit(); err != nil {
+				return
+			}
+			if chooseIntegerMv == 0 {
+				// seq_force_integer_mv
+				if _, err = r.ReadBit(); err != nil {
+					return
+				}
+			}
+		}
+
+		if enableOrderHint != 0 {
+			// order_hint_bits_minus_1
+			if _, err = r.ReadBits(3); err != nil {
+				return
+			}
+		}
+	}
+
+	// enable_superres, enable_cdef, enable_restoration
+	if err = readBitsList(r, 1, 1, 1); err != nil {
+		return
+	}
+
+	if s.ColorConfig, err = parseColorConfig(r, s.Profile); err != nil {
+		return
+	}
+
+	if s.FilmGrainParamsPresent, err = r.ReadBit(); err != nil {
+		return
+	}
+
+	return
+}
+
+func parseColorConfig(r *bits.GolombBitReader, profile uint) (c ColorConfig, err error) {
+	var highBitdepth uint
+	if highBitdepth, err = r.ReadBit(); err != nil {
+		return
+	}
+	c.BitDepth = 8
+	if profile == PROFILE_PROFESSIONAL && highBitdepth != 0 {
+		var twelveBit uint
+		if twelveBit, err = r.ReadBit(); err != nil {
+			return
+		}
+		if twelveBit != 0 {
+			c.BitDepth = 12
+		} else {
+			c.BitDepth = 10
+		}
+	} else if highBitdepth != 0 {
+		c.BitDepth = 10
+	}
+
+	if profile != PROFILE_HIGH {
+		if c.MonoChrome, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	if c.ColorDescriptionPresent, err = r.ReadBit(); err != nil {
+		return
+	}
+	if c.ColorDescriptionPresent != 0 {
+		if c.ColorPrimaries, err = r.ReadBits(8); err != nil {
+			return
+		}
+		if c.TransferCharacteristics, err = r.ReadBits(8); err != nil {
+			return
+		}
+		if c.MatrixCoefficients, err = r.ReadBits(8); err != nil {
+			return
+		}
+	} else {
+		c.ColorPrimaries = CP_UNSPECIFIED
+		c.TransferCharacteristics = TC_UNSPECIFIED
+		c.MatrixCoefficients = MC_UNSPECIFIED
+	}
+
+	if c.MonoChrome != 0 {
+		if c.ColorRange, err = r.ReadBit(); err != nil {
+			return
+		}
+		c.SubsamplingX = 1
+		c.SubsamplingY = 1
+		return
+	}
+
+	if c.ColorPrimaries == CP_BT_709 &&
+		c.TransferCharacteristics == TC_SRGB &&
+		c.MatrixCoefficients == MC_IDENTITY {
+		c.ColorRange = 1
+	} else {
+		if c.ColorRange, err = r.ReadBit(); err != nil {
+			return
+		}
+		switch profile {
+		case PROFILE_MAIN:
+			c.SubsamplingX = 1
+			c.SubsamplingY = 1
+		case PROFILE_HIGH:
+		default:
+			if c.BitDepth == 12 {
+				if c.SubsamplingX, err = r.ReadBit(); err != nil {
+					return
+				}
+				if c.SubsamplingX != 0 {
+					if c.SubsamplingY, err = r.ReadBit(); err != nil {
+						return
+					}
+				}
+			} else {
+				c.SubsamplingX = 1
+			}
+		}
+		if c.SubsamplingX != 0 && c.SubsamplingY != 0 {
+			if c.ChromaSamplePosition, err = r.ReadBits(2); err != nil {
+				return
+			}
+		}
+	}
+
+	if c.SeparateUVDeltaQ, err = r.ReadBit(); err != nil {
+		return
+	}
+	return
+}
+
+/*
+	aligned(8) class AV1CodecConfigurationRecord {
+		unsigned int(1) marker = 1;
+		unsigned int(7) version = 1;
+		unsigned int(3) seq_profile;
+		unsigned int(5) seq_level_idx_0;
+		unsigned int(1) seq_tier_0;
+		unsigned int(1) high_bitdepth;
+		unsigned int(1) twelve_bit;
+		unsigned int(1) monochrome;
+		unsigned int(1) chroma_subsampling_x;
+		unsigned int(1) chroma_subsampling_y;
+		unsigned int(2) chroma_sample_position;
+		unsigned int(3) reserved = 0;
+		unsigned int(1) initial_presentation_delay_present;
+		if (initial_presentation_delay_present) {
+			unsigned int(4) initial_presentation_delay_minus_one;
+		} else {
+			unsigned int(4) reserved = 0;
+		}
+		unsigned int(8) configOBUs[];
+	}
+*/
+type DecoderConfigInfo struct {
+	Profile                          uint
+	LevelIdx                         uint
+	Tier                             uint
+	HighBitdepth                     uint
+	TwelveBit                        uint
+	MonoChrome                       uint
+	SubsamplingX                     uint
+	SubsamplingY                     uint
+	ChromaSamplePosition             uint
+	InitialPresentationDelayPresent  uint
+	InitialPresentationDelayMinusOne uint
+}
+
+func (s SequenceHeader) DecoderConfigInfo() (info DecoderConfigInfo) {
+	info.Profile = s.Profile
+	info.LevelIdx = s.LevelIdx
+	info.Tier = s.Tier
+	if s.BitDepth > 8 {
+		info.HighBitdepth = 1
+	}
+	if s.BitDepth == 12 {
+		info.TwelveBit = 1
+	}
+	info.MonoChrome = s.MonoChrome
+	info.SubsamplingX = s.SubsamplingX
+	info.SubsamplingY = s.SubsamplingY
+	info.ChromaSamplePosition = s.ChromaSamplePosition
+	return
+}
+
+func WriteDecoderConfig(b []byte, n *int, info DecoderConfigInfo, configOBUs []byte) {
+	pio.WriteU8(b, n, 0x81)
+	pio.WriteU8(b, n, uint8(info.Profile<<5|info.LevelIdx&0x1f))
+	pio.WriteU8(b, n, uint8(info.Tier<<7|info.HighBitdepth<<6|info.TwelveBit<<5|
+		info.MonoChrome<<4|info.SubsamplingX<<3|info.SubsamplingY<<2|info.ChromaSamplePosition&0x3))
+	if info.InitialPresentationDelayPresent != 0 {
+		pio.WriteU8(b, n, uint8(0x10|info.InitialPresentationDelayMinusOne&0xf))
+	} else {
+		pio.WriteU8(b, n, 0)
+	}
+	pio.WriteBytes(b, n, configOBUs)
+}
+
+func ParseDecoderConfig(b []byte) (info DecoderConfigInfo, configOBUs []byte, err error) {
+	n := 0
+	var v uint8
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	if v&0x80 == 0 {
+		err = fmt.Errorf("av1: av1C marker not set")
+		return
+	}
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	info.Profile = uint(v >> 5)
+	info.LevelIdx = uint(v & 0x1f)
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	info.Tier = uint(v>>7) & 0x1
+	info.HighBitdepth = uint(v>>6) & 0x1
+	info.TwelveBit = uint(v>>5) & 0x1
+	info.MonoChrome = uint(v>>4) & 0x1
+	info.SubsamplingX = uint(v>>3) & 0x1
+	info.SubsamplingY = uint(v>>2) & 0x1
+	info.ChromaSamplePosition = uint(v & 0x3)
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	info.InitialPresentationDelayPresent = uint(v>>4) & 0x1
+	if info.InitialPresentationDelayPresent != 0 {
+		info.InitialPresentationDelayMinusOne = uint(v & 0xf)
+	}
+
+	configOBUs = b[n:]
+	return
+}
+
+type Codec struct {
+	ConfigBytes []byte
+	SeqHdr      []byte // sequence header OBU
+	Info        SequenceHeader
+	W, H        int
+}
+
+func (c Codec) Equal(b Codec) bool {
+	return bytes.Compare(c.SeqHdr, b.SeqHdr) == 0
+}
+
+func (c Codec) ToConfig(b []byte, n *int) {
+	WriteDecoderConfig(b, n, c.Info.DecoderConfigInfo(), c.SeqHdr)
+}
+
+func FromSequenceHeader(obu []byte) (c *Codec, err error) {
+	var s SequenceHeader
+	if s, err = ParseSequenceHeader(obu); err != nil {
+		return
+	}
+	nc := &Codec{
+		SeqHdr: JoinOBUs([][]byte{obu}),
+		Info:   s,
+		W:      int(s.Width),
+		H:      int(s.Height),
+	}
+	b := make([]byte, 4+len(nc.SeqHdr))
+	n := 0
+	nc.ToConfig(b, &n)
+	nc.ConfigBytes = b[:n]
+	c = nc
+	return
+}
+
+func FromDecoderConfig(b []byte) (c *Codec, err error) {
+	var configOBUs []byte
+	if _, configOBUs, err = ParseDecoderConfig(b); err != nil {
+		return
+	}
+
+	seqhdr := FindOBU(configOBUs, OBU_SEQUENCE_HEADER)
+	if seqhdr == nil {
+		err = fmt.Errorf("av1: av1C has no sequence header")
+		return
+	}
+
+	if c, err = FromSequenceHeader(seqhdr); err != nil {
+		return
+	}
+	c.ConfigBytes = b
+	return
+}
@@ -0,0 +1,280 @@
+package av1
+
+import (
+	"bytes"
+	"testing"
+)
+
+type seqWriter struct {
+	b []byte
+	n int
+}
+
+func (sw *seqWriter) bits(v uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if sw.n%8 == 0 {
+			sw.b = append(sw.b, 0)
+		}
+		sw.b[len(sw.b)-1] |= byte(v>>uint(i)&1) << uint(7-sw.n%8)
+		sw.n++
+	}
+}
+
+type testSeq struct {
+	profile      uint
+	reduced      bool
+	level, tier  uint
+	w, h         uint
+	highBitdepth uint
+	twelveBit    uint
+	mono         uint
+	subX, subY   uint
+	timing       bool
+}
+
+// payload returns the sequence header OBU payload and the number of bits
+// before trailing_bits.
+func (s testSeq) payload() ([]byte, int) {
+	sw := &seqWriter{}
+	sw.bits(s.profile, 3)
+	if s.reduced {
+		sw.bits(1, 1) // still_picture
+		sw.bits(1, 1)
+		sw.bits(s.level, 5)
+	} else {
+		sw.bits(0, 2)
+		if s.timing {
+			sw.bits(1, 1)
+			sw.bits(1001, 32) // num_units_in_display_tick
+			sw.bits(60000, 32)
+			sw.bits(1, 1) // equal_picture_interval
+			sw.bits(1, 1) // num_ticks_per_picture_minus_1 uvlc 0
+			sw.bits(0, 1) // decoder_model_info_present_flag
+		} else {
+			sw.bits(0, 1)
+		}
+		sw.bits(0, 1)  // initial_display_delay_present_flag
+		sw.bits(0, 5)  // operating_points_cnt_minus_1
+		sw.bits(0, 12) // operating_point_idc
+		sw.bits(s.level, 5)
+		if s.level > 7 {
+			sw.bits(s.tier, 1)
+		}
+	}
+	sw.bits(15, 4) // frame_width_bits_minus_1
+	sw.bits(15, 4)
+	sw.bits(s.w-1, 16)
+	sw.bits(s.h-1, 16)
+	if !s.reduced {
+		sw.bits(0, 1) // frame_id_numbers_present_flag
+	}
+	sw.bits(0, 3)
+	if !s.reduced {
+		sw.bits(0, 4)
+		sw.bits(1, 1) // enable_order_hint
+		sw.bits(0, 2)
+		sw.bits(1, 1) // seq_choose_screen_content_tools
+		sw.bits(1, 1) // seq_choose_integer_mv
+		sw.bits(6, 3) // order_hint_bits_minus_1
+	}
+	sw.bits(0, 3)
+
+	sw.bits(s.highBitdepth, 1)
+	if s.profile == PROFILE_PROFESSIONAL && s.highBitdepth != 0 {
+		sw.bits(s.twelveBit, 1)
+	}
+	if s.profile != PROFILE_HIGH {
+		sw.bits(s.mono, 1)
+	}
+	sw.bits(0, 1) // color_description_present_flag
+	sw.bits(0, 1) // color_range
+	if s.mono == 0 {
+		if s.profile == PROFILE_PROFESSIONAL && s.twelveBit != 0 {
+			sw.bits(s.subX, 1)
+			if s.subX != 0 {
+				sw.bits(s.subY, 1)
+			}
+		}
+		if s.subX != 0 && s.subY != 0 {
+			sw.bits(0, 2) // chroma_sample_position
+		}
+		sw.bits(0, 1) // separate_uv_delta_q
+	}
+	sw.bits(0, 1) // film_grain_params_present
+	n := sw.n
+	sw.bits(1, 1) // trailing_one_bit
+	return sw.b, n
+}
+
+func (s testSeq) obu() []byte {
+	payload, _ := s.payload()
+	return JoinOBUs([][]byte{append([]byte{OBU_SEQUENCE_HEADER << 3}, payload...)})
+}
+
+var testSeqs = []struct {
+	name     string
+	seq      testSeq
+	bitDepth uint
+}{
+	{"main", testSeq{profile: PROFILE_MAIN, level: 8, tier: 1, w: 1920, h: 1080, subX: 1, subY: 1, timing: true}, 8},
+	{"main 10bit", testSeq{profile: PROFILE_MAIN, level: 5, w: 1280, h: 720, highBitdepth: 1, subX: 1, subY: 1}, 10},
+	{"main mono", testSeq{profile: PROFILE_MAIN, level: 4, w: 640, h: 360, mono: 1, subX: 1, subY: 1}, 8},
+	{"high 444", testSeq{profile: PROFILE_HIGH, level: 9, w: 3840, h: 2160, highBitdepth: 1}, 10},
+	{"professional 12bit 422", testSeq{profile: PROFILE_PROFESSIONAL, level: 12, tier: 1, w: 4096, h: 2176, highBitdepth: 1, twelveBit: 1, subX: 1}, 12},
+	{"professional 10bit", testSeq{profile: PROFILE_PROFESSIONAL, level: 8, w: 1920, h: 1080, highBitdepth: 1, subX: 1}, 10},
+	{"still picture", testSeq{profile: PROFILE_MAIN, reduced: true, level: 2, w: 512, h: 512, subX: 1, subY: 1}, 8},
+}
+
+func TestParseSequenceHeader(t *testing.T) {
+	for _, tt := range testSeqs {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSequenceHeader(tt.seq.obu())
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.seq
+			if s.Profile != want.profile || s.Width != want.w || s.Height != want.h || s.BitDepth != tt.bitDepth ||
+				s.LevelIdx != want.level || s.Tier != want.tier || s.MonoChrome != want.mono ||
+				s.SubsamplingX != want.subX || s.SubsamplingY != want.subY {
+				t.Fatalf("sequence header %+v", s)
+			}
+			if want.timing && (s.NumUnitsInDisplayTick != 1001 || s.TimeScale != 60000) {
+				t.Fatalf("timing %d/%d", s.NumUnitsInDisplayTick, s.TimeScale)
+			}
+		})
+	}
+}
+
+func TestDecoderConfig(t *testing.T) {
+	for _, tt := range testSeqs {
+		t.Run(tt.name, func(t *testing.T) {
+			obu := tt.seq.obu()
+			c, err := FromSequenceHeader(obu)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c2, err := FromDecoderConfig(c.ConfigBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !c2.Equal(*c) || c2.Info != c.Info || c2.W != int(tt.seq.w) || c2.H != int(tt.seq.h) {
+				t.Fatalf("codec %+v, want %+v", c2, c)
+			}
+			info, configOBUs, err := ParseDecoderConfig(c.ConfigBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info != c.Info.DecoderConfigInfo() || !bytes.Equal(configOBUs, obu) {
+				t.Fatalf("av1C %+v obus %x", info, configOBUs)
+			}
+		})
+	}
+
+	t.Run("initial presentation delay", func(t *testing.T) {
+		info := DecoderConfigInfo{
+			Profile: PROFILE_PROFESSIONAL, LevelIdx: 13, Tier: 1, HighBitdepth: 1, TwelveBit: 1,
+			SubsamplingX: 1, SubsamplingY: 1, ChromaSamplePosition: 2,
+			InitialPresentationDelayPresent: 1, InitialPresentationDelayMinusOne: 9,
+		}
+		b := make([]byte, 4)
+		n := 0
+		WriteDecoderConfig(b, &n, info, nil)
+		if want := []byte{0x81, 0x4d, 0xee, 0x19}; !bytes.Equal(b, want) {
+			t.Fatalf("av1C %x, want %x", b, want)
+		}
+		got, _, err := ParseDecoderConfig(b)
+		if err != nil || got != info {
+			t.Fatalf("info %+v err %v", got, err)
+		}
+	})
+}
+
+func TestLEB128(t *testing.T) {
+	tests := []struct {
+		data []byte
+		v    uint64
+		n    int
+		err  bool
+	}{
+		{data: []byte{0x00}, v: 0, n: 1},
+		{data: []byte{0x7f}, v: 127, n: 1},
+		{data: []byte{0x80, 0x01}, v: 128, n: 2},
+		{data: []byte{0xe5, 0x8e, 0x26}, v: 624485, n: 3},
+		// padded encodings are allowed
+		{data: []byte{0x80, 0x80, 0x00}, v: 0, n: 3},
+		{data: []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f}, v: 1<<56 - 1, n: 8},
+		{data: []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01}, err: true},
+		{data: []byte{0x80}, err: true},
+		{data: []byte{}, err: true},
+	}
+	for _, tt := range tests {
+		n := 0
+		v, err := ReadLEB128(tt.data, &n)
+		if tt.err {
+			if err == nil {
+				t.Fatalf("%x: no error", tt.data)
+			}
+			continue
+		}
+		if err != nil || v != tt.v || n != tt.n {
+			t.Fatalf("%x: %d n %d err %v", tt.data, v, n, err)
+		}
+		if tt.data[len(tt.data)-1] == 0 && len(tt.data) > 1 {
+			continue
+		}
+		b := make([]byte, 8)
+		n = 0
+		WriteLEB128(b, &n, tt.v)
+		if !bytes.Equal(b[:n], tt.data) {
+			t.Fatalf("wrote %x, want %x", b[:n], tt.data)
+		}
+	}
+}
+
+func TestOBUs(t *testing.T) {
+	frame := []byte{OBU_FRAME<<3 | 0x4, 0x28, 1, 2, 3}
+	b := JoinOBUs([][]byte{TemporalDelimiter, frame})
+	want := []byte{0x12, 0x00, 0x36, 0x28, 0x03, 1, 2, 3}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("joined %x, want %x", b, want)
+	}
+	obus, err := SplitOBUs(b)
+	if err != nil || len(obus) != 2 {
+		t.Fatalf("split %x err %v", obus, err)
+	}
+	h, hdrlen, size, err := ParseOBUHeader(obus[1])
+	if err != nil || h.Type != OBU_FRAME || !h.HasExtension || h.TemporalId != 1 || h.SpatialId != 1 || hdrlen != 3 || size != 3 {
+		t.Fatalf("header %+v hdrlen %d size %d err %v", h, hdrlen, size, err)
+	}
+	if _, _, _, err := ParseOBUHeader([]byte{0x80 | OBU_FRAME<<3}); err == nil {
+		t.Fatal("forbidden bit not rejected")
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	for _, tt := range testSeqs {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, nbits := tt.seq.payload()
+			for i := 0; i < (nbits+7)/8; i++ {
+				if _, err := ParseSequenceHeaderPayload(payload[:i]); err == nil {
+					t.Fatalf("payload cut at %d parsed", i)
+				}
+			}
+			obu := tt.seq.obu()
+			for i := 0; i < len(obu); i++ {
+				if _, err := ParseSequenceHeader(obu[:i]); err == nil {
+					t.Fatalf("obu cut at %d parsed", i)
+				}
+				if _, err := SplitOBUs(obu[:i]); err == nil && i > 0 {
+					t.Fatalf("obus cut at %d split", i)
+				}
+			}
+			c, _ := FromSequenceHeader(obu)
+			for i := 0; i < len(c.ConfigBytes); i++ {
+				if _, err := FromDecoderConfig(c.ConfigBytes[:i]); err == nil {
+					t.Fatalf("av1C cut at %d parsed", i)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package g711
+
+import (
+	"time"
+)
+
+const (
+	ALAW  = 1
+	MULAW = 2
+)
+
+var LawMap = map[int]string{
+	ALAW:  "A-law",
+	MULAW: "u-law",
+}
+
+// FLV only carries 8kHz G.711
+const DefaultSampleRate = 8000
+
+type Codec struct {
+	Law        int
+	SampleRate int
+	Channels   int
+}
+
+func NewCodec(law int) *Codec {
+	return &Codec{
+		Law:        law,
+		SampleRate: DefaultSampleRate,
+		Channels:   1,
+	}
+}
+
+// PacketDuration returns the duration of a G.711 payload, one byte per
+// sample per channel.
+func PacketDuration(sampleRate, channels int, data []byte) (dur time.Duration) {
+	if sampleRate <= 0 || channels <= 0 {
+		return
+	}
+	samples := len(data) / channels
+	return time.Duration(samples) * time.Second / time.Duration(sampleRate)
+}
+
+func (c Codec) PacketDuration(data []byte) time.Duration {
+	return PacketDuration(c.SampleRate, c.Channels, data)
+}
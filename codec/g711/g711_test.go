@@ -0,0 +1,38 @@
+package g711
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacketDuration(t *testing.T) {
+	tests := []struct {
+		sampleRate int
+		channels   int
+		size       int
+		dur        time.Duration
+	}{
+		{8000, 1, 160, 20 * time.Millisecond},
+		{8000, 1, 1, 125 * time.Microsecond},
+		{8000, 2, 320, 20 * time.Millisecond},
+		// a trailing half sample frame does not count
+		{8000, 2, 321, 20 * time.Millisecond},
+		{16000, 1, 160, 10 * time.Millisecond},
+		{8000, 1, 0, 0},
+		{0, 1, 160, 0},
+		{8000, 0, 160, 0},
+	}
+	for _, tt := range tests {
+		if dur := PacketDuration(tt.sampleRate, tt.channels, make([]byte, tt.size)); dur != tt.dur {
+			t.Fatalf("%dHz %dch %d bytes: %v, want %v", tt.sampleRate, tt.channels, tt.size, dur, tt.dur)
+		}
+	}
+
+	c := NewCodec(MULAW)
+	if c.SampleRate != DefaultSampleRate || c.Channels != 1 || LawMap[c.Law] != "u-law" {
+		t.Fatalf("codec %+v", c)
+	}
+	if dur := c.PacketDuration(make([]byte, 400)); dur != 50*time.Millisecond {
+		t.Fatalf("codec duration %v", dur)
+	}
+}
@@ -92,6 +92,29 @@ func IsDataNALU(b []byte) bool {
 	return typ >= 1 && typ <= 5
 }
 
+func NALURefIdc(b []byte) byte {
+	if len(b) > 0 {
+		return b[0] >> 5 & 0x3
+	}
+	return 0
+}
+
+// IsNonRefFrame reports whether all slices of the frame have nal_ref_idc 0,
+// so no other frame depends on it and it can be dropped.
+func IsNonRefFrame(b []byte) bool {
+	nalus, _ := SplitNALUs(b)
+	slices := 0
+	for _, nalu := range nalus {
+		if IsDataNALU(nalu) {
+			if NALURefIdc(nalu) != 0 {
+				return false
+			}
+			slices++
+		}
+	}
+	return slices > 0
+}
+
 /*
 From: http://stackoverflow.com/questions/24884827/possible-locations-for-sequence-picture-parameter-sets-for-h-264-stream
 
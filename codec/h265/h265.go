@@ -0,0 +1,711 @@
+package h265
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+
+	"github.com/nareix/joy5/codec/h264"
+	"github.com/nareix/joy5/utils/bits"
+	"github.com/nareix/joy5/utils/bits/pio"
+)
+
+const (
+	NALU_TRAIL_N    = 0
+	NALU_TRAIL_R    = 1
+	NALU_TSA_N      = 2
+	NALU_TSA_R      = 3
+	NALU_STSA_N     = 4
+	NALU_STSA_R     = 5
+	NALU_RADL_N     = 6
+	NALU_RADL_R     = 7
+	NALU_RASL_N     = 8
+	NALU_RASL_R     = 9
+	NALU_BLA_W_LP   = 16
+	NALU_BLA_W_RADL = 17
+	NALU_BLA_N_LP   = 18
+	NALU_IDR_W_RADL = 19
+	NALU_IDR_N_LP   = 20
+	NALU_CRA        = 21
+	NALU_VPS        = 32
+	NALU_SPS        = 33
+	NALU_PPS        = 34
+	NALU_AUD        = 35
+	NALU_EOS        = 36
+	NALU_EOB        = 37
+	NALU_FD         = 38
+	NALU_SEI_PREFIX = 39
+	NALU_SEI_SUFFIX = 40
+)
+
+const (
+	PROFILE_MAIN               = 1
+	PROFILE_MAIN_10            = 2
+	PROFILE_MAIN_STILL_PICTURE = 3
+	PROFILE_REXT               = 4
+)
+
+var ProfileMap = map[uint]string{
+	PROFILE_MAIN:               "Main",
+	PROFILE_MAIN_10:            "Main 10",
+	PROFILE_MAIN_STILL_PICTURE: "Main Still Picture",
+	PROFILE_REXT:               "Rext",
+}
+
+var AUDBytes = []byte{0, 0, 0, 1, 0x46, 0x01, 0x50} // AUD
+
+func NALUType(b []byte) byte {
+	if len(b) > 0 {
+		return (b[0] >> 1) & 0x3f
+	}
+	return 0
+}
+
+func NALUTypeString(i byte) string {
+	switch i {
+	case NALU_TRAIL_N:
+		return "TRAIL_N"
+	case NALU_TRAIL_R:
+		return "TRAIL_R"
+	case NALU_BLA_W_LP:
+		return "BLA_W_LP"
+	case NALU_BLA_W_RADL:
+		return "BLA_W_RADL"
+	case NALU_BLA_N_LP:
+		return "BLA_N_LP"
+	case NALU_IDR_W_RADL:
+		return "IDR_W_RADL"
+	case NALU_IDR_N_LP:
+		return "IDR_N_LP"
+	case NALU_CRA:
+		return "CRA"
+	case NALU_VPS:
+		return "VPS"
+	case NALU_SPS:
+		return "SPS"
+	case NALU_PPS:
+		return "PPS"
+	case NALU_AUD:
+		return "AUD"
+	case NALU_SEI_PREFIX:
+		return "SEI_PREFIX"
+	case NALU_SEI_SUFFIX:
+		return "SEI_SUFFIX"
+	default:
+		return fmt.Sprint(i)
+	}
+}
+
+// VCL NAL units are types 0-31.
+func IsDataNALU(b []byte) bool {
+	return len(b) > 0 && NALUType(b) < NALU_VPS
+}
+
+// IRAP pictures (BLA, IDR, CRA) are types 16-23.
+func IsKeyFrameNALU(b []byte) bool {
+	typ := NALUType(b)
+	return len(b) > 0 && typ >= NALU_BLA_W_LP && typ <= 23
+}
+
+func IsParamSetNALU(b []byte) bool {
+	switch NALUType(b) {
+	case NALU_VPS, NALU_SPS, NALU_PPS:
+		return true
+	}
+	return false
+}
+
+/*
+	profile_tier_level( profilePresentFlag, maxNumSubLayersMinus1 ) {
+		general_profile_space                       u(2)
+		general_tier_flag                           u(1)
+		general_profile_idc                         u(5)
+		for( j = 0; j < 32; j++ )
+			general_profile_compatibility_flag[ j ] u(1)
+		general_progressive_source_flag ...         u(48) (constraint indicator flags)
+		general_level_idc                           u(8)
+		for( i = 0; i < maxNumSubLayersMinus1; i++ ) {
+			sub_layer_profile_present_flag[ i ]     u(1)
+			sub_layer_level_present_flag[ i ]       u(1)
+		}
+		if( maxNumSubLayersMinus1 > 0 )
+			for( i = maxNumSubLayersMinus1; i < 8; i++ )
+				reserved_zero_2bits[ i ]            u(2)
+		for( i = 0; i < maxNumSubLayersMinus1; i++ ) {
+			if( sub_layer_profile_present_flag[ i ] )
+				...                                 u(88)
+			if( sub_layer_level_present_flag[ i ] )
+				sub_layer_level_idc[ i ]            u(8)
+		}
+	}
+*/
+type ProfileTierLevel struct {
+	ProfileSpace             uint
+	TierFlag                 uint
+	ProfileIdc               uint
+	CompatibilityFlags       uint32
+	ConstraintIndicatorFlags uint64
+	LevelIdc                 uint
+}
+
+func readBits64(r *bits.GolombBitReader, n int) (v uint64, err error) {
+	for n > 0 {
+		m := n
+		if m > 32 {
+			m = 32
+		}
+		var u uint
+		if u, err = r.ReadBits(m); err != nil {
+			return
+		}
+		v = v<<uint(m) | uint64(u)
+		n -= m
+	}
+	return
+}
+
+func parseProfileTierLevel(r *bits.GolombBitReader, maxSubLayersMinus1 uint) (ptl ProfileTierLevel, err error) {
+	if ptl.ProfileSpace, err = r.ReadBits(2); err != nil {
+		return
+	}
+	if ptl.TierFlag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if ptl.ProfileIdc, err = r.ReadBits(5); err != nil {
+		return
+	}
+	var v uint
+	if v, err = r.ReadBits(32); err != nil {
+		return
+	}
+	ptl.CompatibilityFlags = uint32(v)
+	if ptl.ConstraintIndicatorFlags, err = readBits64(r, 48); err != nil {
+		return
+	}
+	if ptl.LevelIdc, err = r.ReadBits(8); err != nil {
+		return
+	}
+
+	var profilePresent, levelPresent [8]uint
+	for i := uint(0); i < maxSubLayersMinus1; i++ {
+		if profilePresent[i], err = r.ReadBit(); err != nil {
+			return
+		}
+		if levelPresent[i], err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+	if maxSubLayersMinus1 > 0 {
+		for i := maxSubLayersMinus1; i < 8; i++ {
+			// reserved_zero_2bits
+			if _, err = r.ReadBits(2); err != nil {
+				return
+			}
+		}
+	}
+	for i := uint(0); i < maxSubLayersMinus1; i++ {
+		if profilePresent[i] != 0 {
+			if _, err = readBits64(r, 88); err != nil {
+				return
+			}
+		}
+		if levelPresent[i] != 0 {
+			if _, err = r.ReadBits(8); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+type VPSInfo struct {
+	Id                 uint
+	MaxSubLayersMinus1 uint
+	TemporalIdNesting  uint
+	ProfileTierLevel
+}
+
+func ParseVPS(data []byte) (s VPSInfo, err error) {
+	data = h264.RemoveH264orH265EmulationBytes(data)
+	r := &bits.GolombBitReader{R: bytes.NewReader(data)}
+
+	// nal_unit_header
+	if _, err = r.ReadBits(16); err != nil {
+		return
+	}
+
+	// vps_video_parameter_set_id
+	if s.Id, err = r.ReadBits(4); err != nil {
+		return
+	}
+
+	// vps_base_layer_internal_flag, vps_base_layer_available_flag, vps_max_layers_minus1
+	if _, err = r.ReadBits(8); err != nil {
+		return
+	}
+
+	if s.MaxSubLayersMinus1, err = r.ReadBits(3); err != nil {
+		return
+	}
+
+	if s.TemporalIdNesting, err = r.ReadBit(); err != nil {
+		return
+	}
+
+	// vps_reserved_0xffff_16bits
+	if _, err = r.ReadBits(16); err != nil {
+		return
+	}
+
+	if s.ProfileTierLevel, err = parseProfileTierLevel(r, s.MaxSubLayersMinus1); err != nil {
+		return
+	}
+
+	return
+}
+
+type SPSInfo struct {
+	Id                 uint
+	VPSId              uint
+	MaxSubLayersMinus1 uint
+	TemporalIdNesting  uint
+	ProfileTierLevel
+
+	ChromaFormatIdc        uint
+	SeparateColourPlane    uint
+	BitDepthLumaMinus8     uint
+	BitDepthChromaMinus8   uint
+	PicWidthInLumaSamples  uint
+	PicHeightInLumaSamples uint
+
+	ConfWinLeftOffset   uint
+	ConfWinRightOffset  uint
+	ConfWinTopOffset    uint
+	ConfWinBottomOffset uint
+
+	Width  uint
+	Height uint
+}
+
+func ParseSPS(data []byte) (s SPSInfo, err error) {
+	data = h264.RemoveH264orH265EmulationBytes(data)
+	r := &bits.GolombBitReader{R: bytes.NewReader(data)}
+
+	// nal_unit_header
+	if _, err = r.ReadBits(16); err != nil {
+		return
+	}
+
+	// sps_video_parameter_set_id
+	if s.VPSId, err = r.ReadBits(4); err != nil {
+		return
+	}
+
+	// sps_max_sub_layers_minus1
+	if s.MaxSubLayersMinus1, err = r.ReadBits(3); err != nil {
+		return
+	}
+
+	// sps_temporal_id_nesting_flag
+	if s.TemporalIdNesting, err = r.ReadBit(); err != nil {
+		return
+	}
+
+	if s.ProfileTierLevel, err = parseProfileTierLevel(r, s.MaxSubLayersMinus1); err != nil {
+		return
+	}
+
+	// sps_seq_parameter_set_id
+	if s.Id, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+
+	if s.ChromaFormatIdc, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if s.ChromaFormatIdc == 3 {
+		if s.SeparateColourPlane, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	if s.PicWidthInLumaSamples, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if s.PicHeightInLumaSamples, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+
+	var conformance_window_flag uint
+	if conformance_window_flag, err = r.ReadBit(); err != nil {
+		return
+	}
+	if conformance_window_flag != 0 {
+		if s.ConfWinLeftOffset, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if s.ConfWinRightOffset, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if s.ConfWinTopOffset, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+		if s.ConfWinBottomOffset, err = r.ReadExponentialGolombCode(); err != nil {
+			return
+		}
+	}
+
+	if s.BitDepthLumaMinus8, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+	if s.BitDepthChromaMinus8, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+
+	// conformance window offsets are in chroma sample units
+	subWidthC, subHeightC := uint(1), uint(1)
+	if s.SeparateColourPlane == 0 {
+		switch s.ChromaFormatIdc {
+		case 1:
+			subWidthC, subHeightC = 2, 2
+		case 2:
+			subWidthC = 2
+		}
+	}
+	cropW := subWidthC * (s.ConfWinLeftOffset + s.ConfWinRightOffset)
+	cropH := subHeightC * (s.ConfWinTopOffset + s.ConfWinBottomOffset)
+	if cropW >= s.PicWidthInLumaSamples || cropH >= s.PicHeightInLumaSamples {
+		err = fmt.Errorf("h265: conformance window %dx%d exceeds picture %dx%d",
+			cropW, cropH, s.PicWidthInLumaSamples, s.PicHeightInLumaSamples)
+		return
+	}
+	s.Width = s.PicWidthInLumaSamples - cropW
+	s.Height = s.PicHeightInLumaSamples - cropH
+
+	return
+}
+
+func (s SPSInfo) BitDepthLuma() uint {
+	return s.BitDepthLumaMinus8 + 8
+}
+
+func (s SPSInfo) BitDepthChroma() uint {
+	return s.BitDepthChromaMinus8 + 8
+}
+
+type PPSInfo struct {
+	Id    uint
+	SPSId uint
+}
+
+func ParsePPS(data []byte) (self PPSInfo, err error) {
+	data = h264.RemoveH264orH265EmulationBytes(data)
+	r := &bits.GolombBitReader{R: bytes.NewReader(data)}
+
+	// nal_unit_header
+	if _, err = r.ReadBits(16); err != nil {
+		return
+	}
+
+	// pps_pic_parameter_set_id
+	if self.Id, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+
+	// pps_seq_parameter_set_id
+	if self.SPSId, err = r.ReadExponentialGolombCode(); err != nil {
+		return
+	}
+
+	return
+}
+
+type Codec struct {
+	ConfigBytes   []byte
+	VPS, SPS, PPS map[int][]byte
+	hash          []byte
+	W, H          int
+}
+
+func NewCodec() (c *Codec) {
+	return &Codec{
+		VPS: map[int][]byte{},
+		SPS: map[int][]byte{},
+		PPS: map[int][]byte{},
+	}
+}
+
+func (c Codec) Equal(b Codec) bool {
+	return bytes.Compare(c.hash, b.hash) == 0
+}
+
+func (c *Codec) AddVPSSPSPPS(b []byte) {
+	switch NALUType(b) {
+	case NALU_VPS:
+		if info, err := ParseVPS(b); err == nil {
+			c.VPS[int(info.Id)] = append([]byte(nil), b...)
+		}
+
+	case NALU_SPS:
+		if si, err := ParseSPS(b); err == nil {
+			c.W = int(si.Width)
+			c.H = int(si.Height)
+			c.SPS[int(si.Id)] = append([]byte(nil), b...)
+		}
+
+	case NALU_PPS:
+		if info, err := ParsePPS(b); err == nil {
+			c.PPS[int(info.Id)] = append([]byte(nil), b...)
+		}
+	}
+
+	h := sha1.New()
+	for _, b := range h264.Map2arr(c.VPS) {
+		h.Write(b)
+	}
+	for _, b := range h264.Map2arr(c.SPS) {
+		h.Write(b)
+	}
+	for _, b := range h264.Map2arr(c.PPS) {
+		h.Write(b)
+	}
+	c.hash = h.Sum(nil)
+}
+
+/*
+	aligned(8) class HEVCDecoderConfigurationRecord {
+		unsigned int(8) configurationVersion = 1;
+		unsigned int(2) general_profile_space;
+		unsigned int(1) general_tier_flag;
+		unsigned int(5) general_profile_idc;
+		unsigned int(32) general_profile_compatibility_flags;
+		unsigned int(48) general_constraint_indicator_flags;
+		unsigned int(8) general_level_idc;
+		bit(4) reserved = '1111'b;
+		unsigned int(12) min_spatial_segmentation_idc;
+		bit(6) reserved = '111111'b;
+		unsigned int(2) parallelismType;
+		bit(6) reserved = '111111'b;
+		unsigned int(2) chromaFormat;
+		bit(5) reserved = '11111'b;
+		unsigned int(3) bitDepthLumaMinus8;
+		bit(5) reserved = '11111'b;
+		unsigned int(3) bitDepthChromaMinus8;
+		bit(16) avgFrameRate;
+		bit(2) constantFrameRate;
+		bit(3) numTemporalLayers;
+		bit(1) temporalIdNested;
+		unsigned int(2) lengthSizeMinusOne;
+		unsigned int(8) numOfArrays;
+		for (j=0; j < numOfArrays; j++) {
+			bit(1) array_completeness;
+			unsigned int(1) reserved = 0;
+			unsigned int(6) NAL_unit_type;
+			unsigned int(16) numNalus;
+			for (i=0; i< numNalus; i++) {
+				unsigned int(16) nalUnitLength;
+				bit(8*nalUnitLength) nalUnit;
+			}
+		}
+	}
+*/
+type DecoderConfigInfo struct {
+	ProfileTierLevel
+	ChromaFormat         uint
+	BitDepthLumaMinus8   uint
+	BitDepthChromaMinus8 uint
+	NumTemporalLayers    uint
+	TemporalIdNested     uint
+	LengthSizeMinusOne   uint
+}
+
+func (c Codec) ToConfig(b []byte, n *int) {
+	var info DecoderConfigInfo
+	info.LengthSizeMinusOne = 3
+	info.NumTemporalLayers = 1
+	info.ChromaFormat = 1
+
+	for _, sps := range h264.Map2arr(c.SPS) {
+		if si, err := ParseSPS(sps); err == nil {
+			info.ProfileTierLevel = si.ProfileTierLevel
+			info.ChromaFormat = si.ChromaFormatIdc
+			info.BitDepthLumaMinus8 = si.BitDepthLumaMinus8
+			info.BitDepthChromaMinus8 = si.BitDepthChromaMinus8
+			info.NumTemporalLayers = si.MaxSubLayersMinus1 + 1
+			info.TemporalIdNested = si.TemporalIdNesting
+		}
+		break
+	}
+
+	pio.WriteU8(b, n, 1)
+	pio.WriteU8(b, n, uint8(info.ProfileSpace<<6|info.TierFlag<<5|info.ProfileIdc))
+	pio.WriteU32BE(b, n, info.CompatibilityFlags)
+	pio.WriteU16BE(b, n, uint16(info.ConstraintIndicatorFlags>>32))
+	pio.WriteU32BE(b, n, uint32(info.ConstraintIndicatorFlags))
+	pio.WriteU8(b, n, uint8(info.LevelIdc))
+	pio.WriteU16BE(b, n, 0xf000)
+	pio.WriteU8(b, n, 0xfc)
+	pio.WriteU8(b, n, 0xfc|uint8(info.ChromaFormat))
+	pio.WriteU8(b, n, 0xf8|uint8(info.BitDepthLumaMinus8))
+	pio.WriteU8(b, n, 0xf8|uint8(info.BitDepthChromaMinus8))
+	pio.WriteU16BE(b, n, 0)
+	pio.WriteU8(b, n, uint8(info.NumTemporalLayers<<3|info.TemporalIdNested<<2|info.LengthSizeMinusOne))
+
+	arrays := []struct {
+		typ byte
+		m   map[int][]byte
+	}{
+		{NALU_VPS, c.VPS},
+		{NALU_SPS, c.SPS},
+		{NALU_PPS, c.PPS},
+	}
+	numOfArrays := 0
+	for _, a := range arrays {
+		if len(a.m) > 0 {
+			numOfArrays++
+		}
+	}
+	pio.WriteU8(b, n, uint8(numOfArrays))
+
+	for _, a := range arrays {
+		if len(a.m) == 0 {
+			continue
+		}
+		pio.WriteU8(b, n, 0x80|a.typ)
+		pio.WriteU16BE(b, n, uint16(len(a.m)))
+		for _, nalu := range h264.Map2arr(a.m) {
+			pio.WriteU16BE(b, n, uint16(len(nalu)))
+			pio.WriteBytes(b, n, nalu)
+		}
+	}
+
+	return
+}
+
+func ParseDecoderConfig(b []byte) (info DecoderConfigInfo, nalus [][]byte, err error) {
+	n := 0
+	var v uint8
+
+	// configurationVersion
+	if _, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	info.ProfileSpace = uint(v >> 6)
+	info.TierFlag = uint(v>>5) & 0x1
+	info.ProfileIdc = uint(v) & 0x1f
+
+	var u32 uint32
+	if u32, err = pio.ReadU32BE(b, &n); err != nil {
+		return
+	}
+	info.CompatibilityFlags = u32
+
+	var u16 uint16
+	if u16, err = pio.ReadU16BE(b, &n); err != nil {
+		return
+	}
+	if u32, err = pio.ReadU32BE(b, &n); err != nil {
+		return
+	}
+	info.ConstraintIndicatorFlags = uint64(u16)<<32 | uint64(u32)
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	info.LevelIdc = uint(v)
+
+	// min_spatial_segmentation_idc, parallelismType
+	if _, err = pio.ReadBytes(b, &n, 3); err != nil {
+		return
+	}
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	info.ChromaFormat = uint(v & 0x3)
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	info.BitDepthLumaMinus8 = uint(v & 0x7)
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	info.BitDepthChromaMinus8 = uint(v & 0x7)
+
+	// avgFrameRate
+	if _, err = pio.ReadU16BE(b, &n); err != nil {
+		return
+	}
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	info.NumTemporalLayers = uint(v>>3) & 0x7
+	info.TemporalIdNested = uint(v>>2) & 0x1
+	info.LengthSizeMinusOne = uint(v & 0x3)
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	numOfArrays := int(v)
+
+	for i := 0; i < numOfArrays; i++ {
+		// array_completeness, NAL_unit_type
+		if _, err = pio.ReadU8(b, &n); err != nil {
+			return
+		}
+		var numNalus uint16
+		if numNalus, err = pio.ReadU16BE(b, &n); err != nil {
+			return
+		}
+		for j := 0; j < int(numNalus); j++ {
+			var nalulen uint16
+			if nalulen, err = pio.ReadU16BE(b, &n); err != nil {
+				return
+			}
+			var nalu []byte
+			if nalu, err = pio.ReadBytes(b, &n, int(nalulen)); err != nil {
+				return
+			}
+			nalus = append(nalus, nalu)
+		}
+	}
+
+	return
+}
+
+func FromDecoderConfig(b []byte) (c *Codec, err error) {
+	var nalus [][]byte
+	if _, nalus, err = ParseDecoderConfig(b); err != nil {
+		return
+	}
+
+	nc := NewCodec()
+	for _, nalu := range nalus {
+		nc.AddVPSSPSPPS(nalu)
+	}
+	nc.ConfigBytes = b
+
+	c = nc
+	return
+}
+
+func FromOld(old Codec) *Codec {
+	c := NewCodec()
+	for _, vps := range old.VPS {
+		c.AddVPSSPSPPS(vps)
+	}
+	for _, sps := range old.SPS {
+		c.AddVPSSPSPPS(sps)
+	}
+	for _, pps := range old.PPS {
+		c.AddVPSSPSPPS(pps)
+	}
+	return c
+}
@@ -0,0 +1,149 @@
+package h265
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/nareix/joy5/utils/bits"
+)
+
+type nalWriter struct {
+	buf bytes.Buffer
+	w   *bits.Writer
+}
+
+func newNALWriter(typ byte) *nalWriter {
+	nw := &nalWriter{}
+	nw.w = &bits.Writer{W: &nw.buf}
+	nw.bits(uint(typ)<<9|1, 16) // nal_unit_header, nuh_temporal_id_plus1 1
+	return nw
+}
+
+func (nw *nalWriter) bits(v uint, n int) {
+	nw.w.WriteBits(v, n)
+}
+
+func (nw *nalWriter) ue(v uint) {
+	n := 0
+	for x := v + 1; x > 1; x >>= 1 {
+		n++
+	}
+	nw.bits(0, n)
+	nw.bits(v+1, n+1)
+}
+
+func (nw *nalWriter) ptl() {
+	nw.bits(1, 8)           // profile_space 0, tier 0, Main
+	nw.bits(0x60000000, 32) // compatibility
+	nw.bits(0x9000, 16)     // constraint indicator flags
+	nw.bits(0, 32)
+	nw.bits(93, 8) // level 3.1
+}
+
+func (nw *nalWriter) bytes() []byte {
+	nw.bits(1, 1) // rbsp_stop_one_bit
+	nw.w.FlushBits()
+	return nw.buf.Bytes()
+}
+
+func testVPS() []byte {
+	nw := newNALWriter(NALU_VPS)
+	nw.bits(0, 4)    // vps_video_parameter_set_id
+	nw.bits(0xc0, 8) // base layer flags, vps_max_layers_minus1
+	nw.bits(0, 3)    // vps_max_sub_layers_minus1
+	nw.bits(1, 1)    // vps_temporal_id_nesting_flag
+	nw.bits(0xffff, 16)
+	nw.ptl()
+	return nw.bytes()
+}
+
+func testSPS(w, h uint, win [4]uint) []byte {
+	nw := newNALWriter(NALU_SPS)
+	nw.bits(0, 4) // sps_video_parameter_set_id
+	nw.bits(0, 3) // sps_max_sub_layers_minus1
+	nw.bits(1, 1) // sps_temporal_id_nesting_flag
+	nw.ptl()
+	nw.ue(0) // sps_seq_parameter_set_id
+	nw.ue(1) // chroma_format_idc 4:2:0
+	nw.ue(w)
+	nw.ue(h)
+	if win == [4]uint{} {
+		nw.bits(0, 1)
+	} else {
+		nw.bits(1, 1)
+		for _, v := range win {
+			nw.ue(v)
+		}
+	}
+	nw.ue(0) // bit_depth_luma_minus8
+	nw.ue(0) // bit_depth_chroma_minus8
+	return nw.bytes()
+}
+
+func testPPS() []byte {
+	nw := newNALWriter(NALU_PPS)
+	nw.ue(0) // pps_pic_parameter_set_id
+	nw.ue(0) // pps_seq_parameter_set_id
+	return nw.bytes()
+}
+
+func TestParseSPS(t *testing.T) {
+	// 1920x1088 cropped to 1080, offsets in chroma samples
+	s, err := ParseSPS(testSPS(1920, 1088, [4]uint{0, 0, 0, 4}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Width != 1920 || s.Height != 1080 || s.ProfileIdc != 1 || s.LevelIdc != 93 || s.ChromaFormatIdc != 1 {
+		t.Fatalf("sps %+v", s)
+	}
+
+	if _, err := ParseSPS(testSPS(64, 64, [4]uint{20, 20, 0, 0})); err == nil {
+		t.Fatal("want error for conformance window wider than picture")
+	}
+}
+
+func TestDecoderConfig(t *testing.T) {
+	vps, sps, pps := testVPS(), testSPS(1280, 720, [4]uint{}), testPPS()
+	if v, err := ParseVPS(vps); err != nil || v.TemporalIdNesting != 1 || v.ProfileIdc != 1 {
+		t.Fatalf("vps %+v %v", v, err)
+	}
+
+	c := NewCodec()
+	c.AddVPSSPSPPS(vps)
+	c.AddVPSSPSPPS(sps)
+	c.AddVPSSPSPPS(pps)
+	if c.W != 1280 || c.H != 720 {
+		t.Fatalf("size %dx%d", c.W, c.H)
+	}
+
+	n := 0
+	c.ToConfig(nil, &n)
+	cfg := make([]byte, n)
+	n = 0
+	c.ToConfig(cfg, &n)
+
+	info, nalus, err := ParseDecoderConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.ProfileIdc != 1 || info.LevelIdc != 93 || info.ChromaFormat != 1 || info.LengthSizeMinusOne != 3 {
+		t.Fatalf("config %+v", info)
+	}
+	want := [][]byte{vps, sps, pps}
+	if len(nalus) != len(want) {
+		t.Fatalf("%d nalus", len(nalus))
+	}
+	for i := range want {
+		if !bytes.Equal(nalus[i], want[i]) {
+			t.Fatalf("nalu %d %x", i, nalus[i])
+		}
+	}
+
+	c2, err := FromDecoderConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c2.Equal(*c) || c2.W != 1280 || c2.H != 720 {
+		t.Fatalf("codec from config differs")
+	}
+}
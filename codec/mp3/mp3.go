@@ -0,0 +1,190 @@
+package mp3
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	VERSION_2_5 = 0
+	VERSION_2   = 2
+	VERSION_1   = 3
+)
+
+const (
+	LAYER_3 = 1
+	LAYER_2 = 2
+	LAYER_1 = 3
+)
+
+const (
+	CHANNEL_STEREO       = 0
+	CHANNEL_JOINT_STEREO = 1
+	CHANNEL_DUAL         = 2
+	CHANNEL_MONO         = 3
+)
+
+const HeaderLength = 4
+
+var VersionMap = map[uint]string{
+	VERSION_2_5: "MPEG-2.5",
+	VERSION_2:   "MPEG-2",
+	VERSION_1:   "MPEG-1",
+}
+
+var LayerMap = map[uint]string{
+	LAYER_3: "Layer III",
+	LAYER_2: "Layer II",
+	LAYER_1: "Layer I",
+}
+
+// kbps, indexed by [mpeg1 ? 0 : 1][layer][bitrate_index]
+var bitrateTable = [2][4][16]int{
+	{
+		{},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+	},
+	{
+		{},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+	},
+}
+
+var sampleRateTable = map[uint][3]int{
+	VERSION_1:   {44100, 48000, 32000},
+	VERSION_2:   {22050, 24000, 16000},
+	VERSION_2_5: {11025, 12000, 8000},
+}
+
+/*
+AAAAAAAA AAABBCCD EEEEFFGH IIJJKLMM
+A sync, B version, C layer, D protection absent, E bitrate index,
+F sample rate index, G padding, H private, I channel mode,
+J mode extension, K copyright, L original, M emphasis
+*/
+type FrameHeader struct {
+	Version         uint
+	Layer           uint
+	Protected       bool
+	Bitrate         int // bits per second
+	SampleRate      int
+	Padding         bool
+	ChannelMode     uint
+	SamplesPerFrame int
+	FrameLength     int // including header
+}
+
+func (h FrameHeader) ChannelCount() int {
+	if h.ChannelMode == CHANNEL_MONO {
+		return 1
+	}
+	return 2
+}
+
+func (h FrameHeader) Duration() time.Duration {
+	return time.Duration(h.SamplesPerFrame) * time.Second / time.Duration(h.SampleRate)
+}
+
+func (h FrameHeader) String() string {
+	return fmt.Sprintf("%s %s %dHz %dch %dbps", VersionMap[h.Version], LayerMap[h.Layer],
+		h.SampleRate, h.ChannelCount(), h.Bitrate)
+}
+
+func ParseFrameHeader(b []byte) (h FrameHeader, err error) {
+	if len(b) < HeaderLength {
+		err = fmt.Errorf("mp3: header too short")
+		return
+	}
+	if b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		err = fmt.Errorf("mp3: sync not found")
+		return
+	}
+
+	h.Version = uint(b[1]>>3) & 0x3
+	h.Layer = uint(b[1]>>1) & 0x3
+	h.Protected = b[1]&0x1 == 0
+	if h.Version == 1 {
+		err = fmt.Errorf("mp3: reserved version")
+		return
+	}
+	if h.Layer == 0 {
+		err = fmt.Errorf("mp3: reserved layer")
+		return
+	}
+
+	bitrateIndex := b[2] >> 4
+	srIndex := (b[2] >> 2) & 0x3
+	h.Padding = b[2]&0x2 != 0
+	h.ChannelMode = uint(b[3] >> 6)
+
+	if bitrateIndex == 0 || bitrateIndex == 0xf {
+		err = fmt.Errorf("mp3: unsupported bitrate index %d", bitrateIndex)
+		return
+	}
+	if srIndex == 3 {
+		err = fmt.Errorf("mp3: reserved sample rate index")
+		return
+	}
+
+	tab := 1
+	if h.Version == VERSION_1 {
+		tab = 0
+	}
+	h.Bitrate = bitrateTable[tab][h.Layer][bitrateIndex] * 1000
+	h.SampleRate = sampleRateTable[h.Version][srIndex]
+
+	padding := 0
+	if h.Padding {
+		padding = 1
+	}
+
+	switch h.Layer {
+	case LAYER_1:
+		h.SamplesPerFrame = 384
+		h.FrameLength = (12*h.Bitrate/h.SampleRate + padding) * 4
+	case LAYER_2:
+		h.SamplesPerFrame = 1152
+		h.FrameLength = 144*h.Bitrate/h.SampleRate + padding
+	case LAYER_3:
+		if h.Version == VERSION_1 {
+			h.SamplesPerFrame = 1152
+			h.FrameLength = 144*h.Bitrate/h.SampleRate + padding
+		} else {
+			h.SamplesPerFrame = 576
+			h.FrameLength = 72*h.Bitrate/h.SampleRate + padding
+		}
+	}
+
+	return
+}
+
+// SplitFrames splits a buffer of back to back frames. A truncated last frame
+// is returned as is.
+func SplitFrames(b []byte) (frames [][]byte, hdrs []FrameHeader, err error) {
+	for len(b) > 0 {
+		var h FrameHeader
+		if h, err = ParseFrameHeader(b); err != nil {
+			return
+		}
+		n := h.FrameLength
+		if n <= 0 || n > len(b) {
+			n = len(b)
+		}
+		frames = append(frames, b[:n])
+		hdrs = append(hdrs, h)
+		b = b[n:]
+	}
+	return
+}
+
+func PacketDuration(data []byte) (dur time.Duration) {
+	_, hdrs, _ := SplitFrames(data)
+	for _, h := range hdrs {
+		dur += h.Duration()
+	}
+	return
+}
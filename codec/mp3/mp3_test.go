@@ -0,0 +1,97 @@
+package mp3
+
+import (
+	"testing"
+	"time"
+)
+
+func testHeader(version, layer uint, bitrateIndex, srIndex byte, padding bool, mode uint) []byte {
+	b := []byte{0xff, 0xe0 | byte(version)<<3 | byte(layer)<<1 | 1, bitrateIndex<<4 | srIndex<<2, byte(mode) << 6}
+	if padding {
+		b[2] |= 0x2
+	}
+	return b
+}
+
+func TestParseFrameHeader(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         []byte
+		bitrate      int
+		sampleRate   int
+		samples      int
+		length       int
+		channelCount int
+	}{
+		{"mpeg1 layer3", testHeader(VERSION_1, LAYER_3, 9, 0, false, CHANNEL_JOINT_STEREO), 128000, 44100, 1152, 417, 2},
+		{"mpeg1 layer3 padding", testHeader(VERSION_1, LAYER_3, 9, 0, true, CHANNEL_STEREO), 128000, 44100, 1152, 418, 2},
+		{"mpeg1 layer3 max", testHeader(VERSION_1, LAYER_3, 14, 1, false, CHANNEL_STEREO), 320000, 48000, 1152, 960, 2},
+		{"mpeg1 layer2", testHeader(VERSION_1, LAYER_2, 10, 1, false, CHANNEL_DUAL), 192000, 48000, 1152, 576, 2},
+		{"mpeg1 layer1", testHeader(VERSION_1, LAYER_1, 14, 2, false, CHANNEL_MONO), 448000, 32000, 384, 672, 1},
+		{"mpeg2 layer3", testHeader(VERSION_2, LAYER_3, 8, 0, false, CHANNEL_MONO), 64000, 22050, 576, 208, 1},
+		{"mpeg2 layer2", testHeader(VERSION_2, LAYER_2, 14, 2, false, CHANNEL_STEREO), 160000, 16000, 1152, 1440, 2},
+		{"mpeg2 layer1 padding", testHeader(VERSION_2, LAYER_1, 14, 1, true, CHANNEL_STEREO), 256000, 24000, 384, 516, 2},
+		{"mpeg2.5 layer3", testHeader(VERSION_2_5, LAYER_3, 1, 2, false, CHANNEL_MONO), 8000, 8000, 576, 72, 1},
+		{"mpeg2.5 layer3 11025", testHeader(VERSION_2_5, LAYER_3, 4, 0, false, CHANNEL_JOINT_STEREO), 32000, 11025, 576, 208, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := ParseFrameHeader(tt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h.Bitrate != tt.bitrate || h.SampleRate != tt.sampleRate || h.SamplesPerFrame != tt.samples ||
+				h.FrameLength != tt.length || h.ChannelCount() != tt.channelCount || h.Protected {
+				t.Fatalf("header %+v", h)
+			}
+			if want := time.Duration(tt.samples) * time.Second / time.Duration(tt.sampleRate); h.Duration() != want {
+				t.Fatalf("duration %v, want %v", h.Duration(), want)
+			}
+		})
+	}
+}
+
+func TestParseFrameHeaderInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"free format", testHeader(VERSION_1, LAYER_3, 0, 0, false, CHANNEL_STEREO)},
+		{"bad bitrate", testHeader(VERSION_1, LAYER_3, 15, 0, false, CHANNEL_STEREO)},
+		{"reserved version", testHeader(1, LAYER_3, 9, 0, false, CHANNEL_STEREO)},
+		{"reserved layer", testHeader(VERSION_1, 0, 9, 0, false, CHANNEL_STEREO)},
+		{"reserved sample rate", testHeader(VERSION_2, LAYER_3, 9, 3, false, CHANNEL_STEREO)},
+		{"no sync", []byte{0xff, 0x1b, 0x90, 0x00}},
+		{"short", []byte{0xff, 0xfb, 0x90}},
+	}
+	for _, tt := range tests {
+		if h, err := ParseFrameHeader(tt.data); err == nil {
+			t.Fatalf("%s: parsed %+v", tt.name, h)
+		}
+	}
+}
+
+func TestSplitFrames(t *testing.T) {
+	frame := func(h []byte, n int) []byte {
+		return append(h, make([]byte, n-HeaderLength)...)
+	}
+	a := frame(testHeader(VERSION_1, LAYER_3, 9, 0, false, CHANNEL_STEREO), 417)
+	b := frame(testHeader(VERSION_1, LAYER_3, 9, 0, true, CHANNEL_STEREO), 418)
+	// the last frame is cut short
+	data := append(append(append([]byte{}, a...), b...), a[:100]...)
+
+	frames, hdrs, err := SplitFrames(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 3 || len(frames[0]) != 417 || len(frames[1]) != 418 || len(frames[2]) != 100 || !hdrs[1].Padding {
+		t.Fatalf("%d frames", len(frames))
+	}
+	if dur, want := PacketDuration(data), 3*(1152*time.Second/44100); dur != want {
+		t.Fatalf("duration %v, want %v", dur, want)
+	}
+
+	if _, _, err := SplitFrames(append(a, 0, 0, 0, 0)); err == nil {
+		t.Fatal("garbage after frame split")
+	}
+}
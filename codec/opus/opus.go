@@ -0,0 +1,278 @@
+package opus
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/nareix/joy5/utils/bits/pio"
+)
+
+// Opus always decodes at 48kHz
+const SampleRate = 48000
+
+var OpusHeadMagic = []byte("OpusHead")
+
+/*
+OpusHead (RFC 7845), little endian:
+
+	Magic Signature "OpusHead"
+	Version 8
+	Output Channel Count 8
+	Pre-skip 16
+	Input Sample Rate 32
+	Output Gain 16
+	Channel Mapping Family 8
+	Optional Channel Mapping Table:
+		Stream Count 8
+		Coupled Count 8
+		Channel Mapping 8*C
+
+dOps (Opus in ISOBMFF), big endian:
+
+	Version 8 = 0
+	OutputChannelCount 8
+	PreSkip 16
+	InputSampleRate 32
+	OutputGain 16
+	ChannelMappingFamily 8
+	if (ChannelMappingFamily != 0) {
+		StreamCount 8
+		CoupledCount 8
+		ChannelMapping 8*OutputChannelCount
+	}
+*/
+type Config struct {
+	ChannelCount         int
+	PreSkip              int
+	InputSampleRate      int
+	OutputGain           int16
+	ChannelMappingFamily int
+	StreamCount          int
+	CoupledCount         int
+	ChannelMapping       []byte
+}
+
+func ParseOpusHead(b []byte) (c Config, err error) {
+	if len(b) < 19 || bytes.Compare(b[:8], OpusHeadMagic) != 0 {
+		err = fmt.Errorf("opus: invalid OpusHead")
+		return
+	}
+	n := 8
+
+	// version
+	if _, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+
+	var v uint8
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	c.ChannelCount = int(v)
+
+	var u16 uint16
+	if u16, err = pio.ReadU16LE(b, &n); err != nil {
+		return
+	}
+	c.PreSkip = int(u16)
+
+	var u32 uint32
+	if u32, err = pio.ReadU32LE(b, &n); err != nil {
+		return
+	}
+	c.InputSampleRate = int(u32)
+
+	if u16, err = pio.ReadU16LE(b, &n); err != nil {
+		return
+	}
+	c.OutputGain = int16(u16)
+
+	err = c.parseChannelMapping(b, &n)
+	return
+}
+
+func ParseDOps(b []byte) (c Config, err error) {
+	n := 0
+
+	// version
+	if _, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+
+	var v uint8
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	c.ChannelCount = int(v)
+
+	var u16 uint16
+	if u16, err = pio.ReadU16BE(b, &n); err != nil {
+		return
+	}
+	c.PreSkip = int(u16)
+
+	var u32 uint32
+	if u32, err = pio.ReadU32BE(b, &n); err != nil {
+		return
+	}
+	c.InputSampleRate = int(u32)
+
+	if u16, err = pio.ReadU16BE(b, &n); err != nil {
+		return
+	}
+	c.OutputGain = int16(u16)
+
+	err = c.parseChannelMapping(b, &n)
+	return
+}
+
+func (c *Config) parseChannelMapping(b []byte, n *int) (err error) {
+	var v uint8
+	if v, err = pio.ReadU8(b, n); err != nil {
+		return
+	}
+	c.ChannelMappingFamily = int(v)
+
+	if c.ChannelMappingFamily == 0 {
+		c.StreamCount = 1
+		if c.ChannelCount > 1 {
+			c.CoupledCount = 1
+		}
+		return
+	}
+
+	if v, err = pio.ReadU8(b, n); err != nil {
+		return
+	}
+	c.StreamCount = int(v)
+	if v, err = pio.ReadU8(b, n); err != nil {
+		return
+	}
+	c.CoupledCount = int(v)
+	if c.ChannelMapping, err = pio.ReadBytes(b, n, c.ChannelCount); err != nil {
+		return
+	}
+	return
+}
+
+// ParseConfig accepts either an OpusHead or a dOps payload.
+func ParseConfig(b []byte) (c Config, err error) {
+	if bytes.HasPrefix(b, OpusHeadMagic) {
+		return ParseOpusHead(b)
+	}
+	return ParseDOps(b)
+}
+
+func (c Config) writeChannelMapping(b []byte, n *int) {
+	pio.WriteU8(b, n, uint8(c.ChannelMappingFamily))
+	if c.ChannelMappingFamily != 0 {
+		pio.WriteU8(b, n, uint8(c.StreamCount))
+		pio.WriteU8(b, n, uint8(c.CoupledCount))
+		pio.WriteBytes(b, n, c.ChannelMapping)
+	}
+}
+
+func WriteOpusHead(b []byte, n *int, c Config) {
+	pio.WriteBytes(b, n, OpusHeadMagic)
+	pio.WriteU8(b, n, 1)
+	pio.WriteU8(b, n, uint8(c.ChannelCount))
+	pio.WriteU16LE(b, n, uint16(c.PreSkip))
+	pio.WriteU32LE(b, n, uint32(c.InputSampleRate))
+	pio.WriteU16LE(b, n, uint16(c.OutputGain))
+	c.writeChannelMapping(b, n)
+}
+
+func WriteDOps(b []byte, n *int, c Config) {
+	pio.WriteU8(b, n, 0)
+	pio.WriteU8(b, n, uint8(c.ChannelCount))
+	pio.WriteU16BE(b, n, uint16(c.PreSkip))
+	pio.WriteU32BE(b, n, uint32(c.InputSampleRate))
+	pio.WriteU16BE(b, n, uint16(c.OutputGain))
+	c.writeChannelMapping(b, n)
+}
+
+func (c Config) OpusHead() []byte {
+	n := 0
+	WriteOpusHead(nil, &n, c)
+	b := make([]byte, n)
+	n = 0
+	WriteOpusHead(b, &n, c)
+	return b
+}
+
+func (c Config) DOps() []byte {
+	n := 0
+	WriteDOps(nil, &n, c)
+	b := make([]byte, n)
+	n = 0
+	WriteDOps(b, &n, c)
+	return b
+}
+
+var silkFrameSizes = []time.Duration{
+	10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 60 * time.Millisecond,
+}
+
+var celtFrameSizes = []time.Duration{
+	2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond,
+}
+
+// FrameDuration returns the frame size signalled by the TOC byte config.
+func FrameDuration(toc byte) time.Duration {
+	config := toc >> 3
+	switch {
+	case config < 12:
+		return silkFrameSizes[config%4]
+	case config < 16:
+		return silkFrameSizes[config%2]
+	default:
+		return celtFrameSizes[config%4]
+	}
+}
+
+// FrameCount returns the number of frames in the packet (RFC 6716 3.1).
+func FrameCount(data []byte) (n int, err error) {
+	if len(data) < 1 {
+		err = fmt.Errorf("opus: empty packet")
+		return
+	}
+	switch data[0] & 0x3 {
+	case 0:
+		n = 1
+	case 1, 2:
+		n = 2
+	case 3:
+		if len(data) < 2 {
+			err = fmt.Errorf("opus: code 3 packet missing frame count")
+			return
+		}
+		n = int(data[1] & 0x3f)
+	}
+	return
+}
+
+func PacketDuration(data []byte) (dur time.Duration) {
+	n, err := FrameCount(data)
+	if err != nil {
+		return
+	}
+	return time.Duration(n) * FrameDuration(data[0])
+}
+
+type Codec struct {
+	ConfigBytes []byte
+	Config      Config
+}
+
+func FromDecoderConfig(b []byte) (c *Codec, err error) {
+	var config Config
+	if config, err = ParseConfig(b); err != nil {
+		return
+	}
+	c = &Codec{
+		Config:      config,
+		ConfigBytes: b,
+	}
+	return
+}
@@ -0,0 +1,140 @@
+package opus
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFrameDuration(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		config byte
+		dur    time.Duration
+	}{
+		// SILK NB, MB, WB
+		{0, 10 * ms}, {1, 20 * ms}, {2, 40 * ms}, {3, 60 * ms}, {7, 60 * ms}, {11, 60 * ms},
+		// Hybrid SWB, FB
+		{12, 10 * ms}, {13, 20 * ms}, {14, 10 * ms}, {15, 20 * ms},
+		// CELT NB to FB
+		{16, 2500 * time.Microsecond}, {17, 5 * ms}, {18, 10 * ms}, {19, 20 * ms}, {31, 20 * ms},
+	}
+	for _, tt := range tests {
+		// stereo flag and frame count code do not change the frame size
+		for _, low := range []byte{0, 0x4, 0x3, 0x7} {
+			if got := FrameDuration(tt.config<<3 | low); got != tt.dur {
+				t.Fatalf("config %d toc %x: %v, want %v", tt.config, tt.config<<3|low, got, tt.dur)
+			}
+		}
+	}
+}
+
+func TestPacketDuration(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  []byte
+		count int
+		dur   time.Duration
+		err   bool
+	}{
+		{name: "code 0", data: []byte{1<<3 | 0, 0xff}, count: 1, dur: 20 * time.Millisecond},
+		{name: "code 1", data: []byte{19<<3 | 1, 0xff, 0xff}, count: 2, dur: 40 * time.Millisecond},
+		{name: "code 2", data: []byte{16<<3 | 2, 1, 0xff, 0xff}, count: 2, dur: 5 * time.Millisecond},
+		{name: "code 3", data: []byte{18<<3 | 3, 6, 0xff}, count: 6, dur: 60 * time.Millisecond},
+		// VBR and padding flags above the count
+		{name: "code 3 vbr padding", data: []byte{17<<3 | 3, 0xc0 | 24, 0}, count: 24, dur: 120 * time.Millisecond},
+		{name: "code 3 no count", data: []byte{18<<3 | 3}, err: true},
+		{name: "empty", data: []byte{}, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := FrameCount(tt.data)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("count %d, want error", n)
+				}
+				if dur := PacketDuration(tt.data); dur != 0 {
+					t.Fatalf("duration %v", dur)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.count {
+				t.Fatalf("count %d, want %d", n, tt.count)
+			}
+			if dur := PacketDuration(tt.data); dur != tt.dur {
+				t.Fatalf("duration %v, want %v", dur, tt.dur)
+			}
+		})
+	}
+}
+
+func TestConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		head   []byte
+		dops   []byte
+	}{
+		{
+			name:   "stereo",
+			config: Config{ChannelCount: 2, PreSkip: 312, InputSampleRate: 48000, StreamCount: 1, CoupledCount: 1},
+			head:   []byte{'O', 'p', 'u', 's', 'H', 'e', 'a', 'd', 1, 2, 0x38, 0x01, 0x80, 0xbb, 0, 0, 0, 0, 0},
+			dops:   []byte{0, 2, 0x01, 0x38, 0, 0, 0xbb, 0x80, 0, 0, 0},
+		},
+		{
+			name:   "mono gain",
+			config: Config{ChannelCount: 1, PreSkip: 3840, InputSampleRate: 44100, OutputGain: -256, StreamCount: 1},
+			head:   []byte{'O', 'p', 'u', 's', 'H', 'e', 'a', 'd', 1, 1, 0x00, 0x0f, 0x44, 0xac, 0, 0, 0x00, 0xff, 0},
+			dops:   []byte{0, 1, 0x0f, 0x00, 0, 0, 0xac, 0x44, 0xff, 0x00, 0},
+		},
+		{
+			name: "family 1 5.1",
+			config: Config{
+				ChannelCount: 6, PreSkip: 312, InputSampleRate: 48000, ChannelMappingFamily: 1,
+				StreamCount: 4, CoupledCount: 2, ChannelMapping: []byte{0, 4, 1, 2, 3, 5},
+			},
+			head: []byte{'O', 'p', 'u', 's', 'H', 'e', 'a', 'd', 1, 6, 0x38, 0x01, 0x80, 0xbb, 0, 0, 0, 0,
+				1, 4, 2, 0, 4, 1, 2, 3, 5},
+			dops: []byte{0, 6, 0x01, 0x38, 0, 0, 0xbb, 0x80, 0, 0,
+				1, 4, 2, 0, 4, 1, 2, 3, 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if b := tt.config.OpusHead(); !bytes.Equal(b, tt.head) {
+				t.Fatalf("OpusHead %x, want %x", b, tt.head)
+			}
+			if b := tt.config.DOps(); !bytes.Equal(b, tt.dops) {
+				t.Fatalf("dOps %x, want %x", b, tt.dops)
+			}
+			for _, b := range [][]byte{tt.head, tt.dops} {
+				c, err := FromDecoderConfig(b)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if fmt.Sprintf("%+v", c.Config) != fmt.Sprintf("%+v", tt.config) {
+					t.Fatalf("config %+v, want %+v", c.Config, tt.config)
+				}
+			}
+			// dOps to OpusHead and back
+			c, _ := ParseDOps(tt.dops)
+			if h, _ := ParseOpusHead(c.OpusHead()); !bytes.Equal(h.DOps(), tt.dops) {
+				t.Fatalf("dOps %x after OpusHead, want %x", h.DOps(), tt.dops)
+			}
+			for i := 0; i < len(tt.dops); i++ {
+				if _, err := ParseDOps(tt.dops[:i]); err == nil {
+					t.Fatalf("dOps cut at %d parsed", i)
+				}
+			}
+			for i := 0; i < len(tt.head); i++ {
+				if _, err := ParseOpusHead(tt.head[:i]); err == nil {
+					t.Fatalf("OpusHead cut at %d parsed", i)
+				}
+			}
+		})
+	}
+}
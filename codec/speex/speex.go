@@ -0,0 +1,22 @@
+package speex
+
+import (
+	"time"
+)
+
+// Speex in FLV is always 16kHz mono wideband
+const SampleRate = 16000
+
+// Samples of one wideband frame
+const FrameSamples = 320
+
+const FrameDuration = time.Duration(FrameSamples) * time.Second / SampleRate
+
+// PacketDuration assumes one frame per packet, which is what Flash Player
+// and FFmpeg send.
+func PacketDuration(data []byte) time.Duration {
+	if len(data) == 0 {
+		return 0
+	}
+	return FrameDuration
+}
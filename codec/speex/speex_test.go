@@ -0,0 +1,22 @@
+package speex
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPacketDuration(t *testing.T) {
+	if FrameDuration != 20*time.Millisecond {
+		t.Fatalf("frame duration %v", FrameDuration)
+	}
+	for _, tt := range []struct {
+		size int
+		dur  time.Duration
+	}{
+		{0, 0}, {1, 20 * time.Millisecond}, {42, 20 * time.Millisecond}, {106, 20 * time.Millisecond},
+	} {
+		if dur := PacketDuration(make([]byte, tt.size)); dur != tt.dur {
+			t.Fatalf("%d bytes: %v, want %v", tt.size, dur, tt.dur)
+		}
+	}
+}
@@ -0,0 +1,508 @@
+package vp9
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/nareix/joy5/utils/bits"
+	"github.com/nareix/joy5/utils/bits/pio"
+)
+
+const (
+	FRAME_KEY     = 0
+	FRAME_NON_KEY = 1
+)
+
+const (
+	CS_UNKNOWN   = 0
+	CS_BT_601    = 1
+	CS_BT_709    = 2
+	CS_SMPTE_170 = 3
+	CS_SMPTE_240 = 4
+	CS_BT_2020   = 5
+	CS_RESERVED  = 6
+	CS_RGB       = 7
+)
+
+var ColorSpaceMap = map[uint]string{
+	CS_UNKNOWN:   "Unknown",
+	CS_BT_601:    "BT.601",
+	CS_BT_709:    "BT.709",
+	CS_SMPTE_170: "SMPTE-170",
+	CS_SMPTE_240: "SMPTE-240",
+	CS_BT_2020:   "BT.2020",
+	CS_RESERVED:  "Reserved",
+	CS_RGB:       "RGB",
+}
+
+// vpcC chromaSubsampling
+const (
+	CHROMA_420_VERTICAL  = 0
+	CHROMA_420_COLOCATED = 1
+	CHROMA_422           = 2
+	CHROMA_444           = 3
+)
+
+const (
+	frameSyncCode         = 0x498342
+	superframeMarkerMask  = 0xe0
+	superframeMarkerValue = 0xc0
+)
+
+/*
+	uncompressed_header() {
+		frame_marker f(2)
+		profile_low_bit f(1)
+		profile_high_bit f(1)
+		if ( Profile == 3 )
+			reserved_zero f(1)
+		show_existing_frame f(1)
+		if ( show_existing_frame == 1 ) {
+			frame_to_show_map_idx f(3)
+			...
+			return
+		}
+		frame_type f(1)
+		show_frame f(1)
+		error_resilient_mode f(1)
+		if ( frame_type == KEY_FRAME ) {
+			frame_sync_code()
+			color_config()
+			frame_size()
+			...
+		} else {
+			if ( show_frame == 0 )
+				intra_only f(1)
+			if ( error_resilient_mode == 0 )
+				reset_frame_context f(2)
+			if ( intra_only == 1 ) {
+				frame_sync_code()
+				if ( Profile > 0 )
+					color_config()
+				refresh_frame_flags f(8)
+				frame_size()
+				...
+			}
+			...
+		}
+	}
+*/
+type FrameHeader struct {
+	Profile           uint
+	ShowExistingFrame uint
+	FrameType         uint
+	ShowFrame         uint
+	ErrorResilient    uint
+	IntraOnly         uint
+
+	BitDepth     uint
+	ColorSpace   uint
+	ColorRange   uint
+	SubsamplingX uint
+	SubsamplingY uint
+
+	// Only set for key frames and intra only frames
+	Width  uint
+	Height uint
+}
+
+func (h FrameHeader) IsKeyFrame() bool {
+	return h.ShowExistingFrame == 0 && h.FrameType == FRAME_KEY
+}
+
+func ParseFrameHeader(b []byte) (h FrameHeader, err error) {
+	r := &bits.GolombBitReader{R: bytes.NewReader(b)}
+
+	var frameMarker uint
+	if frameMarker, err = r.ReadBits(2); err != nil {
+		return
+	}
+	if frameMarker != 2 {
+		err = fmt.Errorf("vp9: invalid frame marker")
+		return
+	}
+
+	var lo, hi uint
+	if lo, err = r.ReadBit(); err != nil {
+		return
+	}
+	if hi, err = r.ReadBit(); err != nil {
+		return
+	}
+	h.Profile = hi<<1 | lo
+	if h.Profile == 3 {
+		if _, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+
+	if h.ShowExistingFrame, err = r.ReadBit(); err != nil {
+		return
+	}
+	if h.ShowExistingFrame != 0 {
+		return
+	}
+
+	if h.FrameType, err = r.ReadBit(); err != nil {
+		return
+	}
+	if h.ShowFrame, err = r.ReadBit(); err != nil {
+		return
+	}
+	if h.ErrorResilient, err = r.ReadBit(); err != nil {
+		return
+	}
+
+	if h.FrameType == FRAME_KEY {
+		if err = readFrameSyncCode(r); err != nil {
+			return
+		}
+		if err = h.readColorConfig(r); err != nil {
+			return
+		}
+		if err = h.readFrameSize(r); err != nil {
+			return
+		}
+		return
+	}
+
+	if h.ShowFrame == 0 {
+		if h.IntraOnly, err = r.ReadBit(); err != nil {
+			return
+		}
+	}
+	if h.ErrorResilient == 0 {
+		// reset_frame_context
+		if _, err = r.ReadBits(2); err != nil {
+			return
+		}
+	}
+	if h.IntraOnly != 0 {
+		if err = readFrameSyncCode(r); err != nil {
+			return
+		}
+		if h.Profile > 0 {
+			if err = h.readColorConfig(r); err != nil {
+				return
+			}
+		} else {
+			h.BitDepth = 8
+			h.ColorSpace = CS_BT_601
+			h.SubsamplingX = 1
+			h.SubsamplingY = 1
+		}
+		// refresh_frame_flags
+		if _, err = r.ReadBits(8); err != nil {
+			return
+		}
+		if err = h.readFrameSize(r); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func readFrameSyncCode(r *bits.GolombBitReader) (err error) {
+	var v uint
+	if v, err = r.ReadBits(24); err != nil {
+		return
+	}
+	if v != frameSyncCode {
+		err = fmt.Errorf("vp9: invalid frame sync code")
+		return
+	}
+	return
+}
+
+func (h *FrameHeader) readColorConfig(r *bits.GolombBitReader) (err error) {
+	h.BitDepth = 8
+	if h.Profile >= 2 {
+		var tenOrTwelveBit uint
+		if tenOrTwelveBit, err = r.ReadBit(); err != nil {
+			return
+		}
+		if tenOrTwelveBit != 0 {
+			h.BitDepth = 12
+		} else {
+			h.BitDepth = 10
+		}
+	}
+
+	if h.ColorSpace, err = r.ReadBits(3); err != nil {
+		return
+	}
+
+	if h.ColorSpace != CS_RGB {
+		if h.ColorRange, err = r.ReadBit(); err != nil {
+			return
+		}
+		if h.Profile == 1 || h.Profile == 3 {
+			if h.SubsamplingX, err = r.ReadBit(); err != nil {
+				return
+			}
+			if h.SubsamplingY, err = r.ReadBit(); err != nil {
+				return
+			}
+			if _, err = r.ReadBit(); err != nil {
+				return
+			}
+		} else {
+			h.SubsamplingX = 1
+			h.SubsamplingY = 1
+		}
+	} else {
+		h.ColorRange = 1
+		if h.Profile == 1 || h.Profile == 3 {
+			if _, err = r.ReadBit(); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (h *FrameHeader) readFrameSize(r *bits.GolombBitReader) (err error) {
+	var v uint
+	if v, err = r.ReadBits(16); err != nil {
+		return
+	}
+	h.Width = v + 1
+	if v, err = r.ReadBits(16); err != nil {
+		return
+	}
+	h.Height = v + 1
+	return
+}
+
+// SplitSuperframe splits a superframe into frames. A normal frame is
+// returned as is.
+func SplitSuperframe(b []byte) (frames [][]byte, err error) {
+	if len(b) == 0 {
+		return
+	}
+
+	marker := b[len(b)-1]
+	if marker&superframeMarkerMask != superframeMarkerValue {
+		frames = [][]byte{b}
+		return
+	}
+
+	nframes := int(marker&0x7) + 1
+	mag := int(marker>>3&0x3) + 1
+	idxsize := 2 + mag*nframes
+	if len(b) < idxsize || b[len(b)-idxsize] != marker {
+		frames = [][]byte{b}
+		return
+	}
+
+	idx := b[len(b)-idxsize+1 : len(b)-1]
+	data := b[:len(b)-idxsize]
+	for i := 0; i < nframes; i++ {
+		size := 0
+		for j := 0; j < mag; j++ {
+			size |= int(idx[i*mag+j]) << (uint(j) * 8)
+		}
+		if size > len(data) {
+			err = fmt.Errorf("vp9: superframe size %d exceeds buffer", size)
+			return
+		}
+		frames = append(frames, data[:size])
+		data = data[size:]
+	}
+	return
+}
+
+// IsKeyFrame reports whether the first frame of a (super)frame is a key frame.
+func IsKeyFrame(b []byte) bool {
+	frames, err := SplitSuperframe(b)
+	if err != nil || len(frames) == 0 {
+		return false
+	}
+	h, err := ParseFrameHeader(frames[0])
+	if err != nil {
+		return false
+	}
+	return h.IsKeyFrame()
+}
+
+/*
+	aligned (8) class VPCodecConfigurationRecord {
+		unsigned int (8) profile;
+		unsigned int (8) level;
+		unsigned int (4) bitDepth;
+		unsigned int (3) chromaSubsampling;
+		unsigned int (1) videoFullRangeFlag;
+		unsigned int (8) colourPrimaries;
+		unsigned int (8) transferCharacteristics;
+		unsigned int (8) matrixCoefficients;
+		unsigned int (16) codecInitializationDataSize;
+		unsigned int (8)[] codecInitializationData;
+	}
+
+It is written with the vpcC FullBox version (1) and flags (0) in front.
+*/
+type DecoderConfigInfo struct {
+	Profile                 uint
+	Level                   uint
+	BitDepth                uint
+	ChromaSubsampling       uint
+	VideoFullRange          uint
+	ColourPrimaries         uint
+	TransferCharacteristics uint
+	MatrixCoefficients      uint
+	CodecInitializationData []byte
+}
+
+func WriteDecoderConfig(b []byte, n *int, info DecoderConfigInfo) {
+	pio.WriteU32BE(b, n, 1<<24)
+	pio.WriteU8(b, n, uint8(info.Profile))
+	pio.WriteU8(b, n, uint8(info.Level))
+	pio.WriteU8(b, n, uint8(info.BitDepth<<4|(info.ChromaSubsampling&0x7)<<1|info.VideoFullRange&0x1))
+	pio.WriteU8(b, n, uint8(info.ColourPrimaries))
+	pio.WriteU8(b, n, uint8(info.TransferCharacteristics))
+	pio.WriteU8(b, n, uint8(info.MatrixCoefficients))
+	pio.WriteU16BE(b, n, uint16(len(info.CodecInitializationData)))
+	pio.WriteBytes(b, n, info.CodecInitializationData)
+}
+
+func ParseDecoderConfig(b []byte) (info DecoderConfigInfo, err error) {
+	n := 0
+
+	// version 1, flags 0. Some muxers leave the FullBox header out.
+	if len(b) >= 4 && b[0] == 1 && b[1] == 0 && b[2] == 0 && b[3] == 0 {
+		n += 4
+	}
+
+	var v uint8
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	info.Profile = uint(v)
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	info.Level = uint(v)
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	info.BitDepth = uint(v >> 4)
+	info.ChromaSubsampling = uint(v>>1) & 0x7
+	info.VideoFullRange = uint(v) & 0x1
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	info.ColourPrimaries = uint(v)
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	info.TransferCharacteristics = uint(v)
+
+	if v, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	info.MatrixCoefficients = uint(v)
+
+	var size uint16
+	if size, err = pio.ReadU16BE(b, &n); err != nil {
+		return
+	}
+	if info.CodecInitializationData, err = pio.ReadBytes(b, &n, int(size)); err != nil {
+		return
+	}
+	return
+}
+
+// ISO/IEC 23091-4 MatrixCoefficients of VP9 color spaces
+var colorSpaceMatrixCoefficients = map[uint]uint{
+	CS_UNKNOWN:   2,
+	CS_BT_601:    6,
+	CS_BT_709:    1,
+	CS_SMPTE_170: 6,
+	CS_SMPTE_240: 7,
+	CS_BT_2020:   9,
+	CS_RESERVED:  2,
+	CS_RGB:       0,
+}
+
+func (h FrameHeader) DecoderConfigInfo() (info DecoderConfigInfo) {
+	info.Profile = h.Profile
+	info.BitDepth = h.BitDepth
+	switch {
+	case h.SubsamplingX == 1 && h.SubsamplingY == 1:
+		info.ChromaSubsampling = CHROMA_420_COLOCATED
+	case h.SubsamplingX == 1:
+		info.ChromaSubsampling = CHROMA_422
+	default:
+		info.ChromaSubsampling = CHROMA_444
+	}
+	info.VideoFullRange = h.ColorRange
+	info.ColourPrimaries = 2
+	info.TransferCharacteristics = 2
+	info.MatrixCoefficients = colorSpaceMatrixCoefficients[h.ColorSpace]
+	return
+}
+
+type Codec struct {
+	ConfigBytes []byte
+	Info        DecoderConfigInfo
+	W, H        int
+}
+
+func (c Codec) Equal(b Codec) bool {
+	return bytes.Compare(c.ConfigBytes, b.ConfigBytes) == 0
+}
+
+func (c Codec) ToConfig(b []byte, n *int) {
+	WriteDecoderConfig(b, n, c.Info)
+}
+
+func FromDecoderConfig(b []byte) (c *Codec, err error) {
+	var info DecoderConfigInfo
+	if info, err = ParseDecoderConfig(b); err != nil {
+		return
+	}
+	c = &Codec{
+		ConfigBytes: b,
+		Info:        info,
+	}
+	return
+}
+
+// FromKeyFrame builds a codec from the header of a key frame, for streams
+// that carry no vpcC.
+func FromKeyFrame(b []byte) (c *Codec, err error) {
+	var frames [][]byte
+	if frames, err = SplitSuperframe(b); err != nil {
+		return
+	}
+	if len(frames) == 0 {
+		err = fmt.Errorf("vp9: empty frame")
+		return
+	}
+
+	var h FrameHeader
+	if h, err = ParseFrameHeader(frames[0]); err != nil {
+		return
+	}
+	if !h.IsKeyFrame() {
+		err = fmt.Errorf("vp9: not key frame")
+		return
+	}
+
+	nc := &Codec{
+		Info: h.DecoderConfigInfo(),
+		W:    int(h.Width),
+		H:    int(h.Height),
+	}
+	cb := make([]byte, 12)
+	n := 0
+	nc.ToConfig(cb, &n)
+	nc.ConfigBytes = cb[:n]
+	c = nc
+	return
+}
@@ -0,0 +1,296 @@
+package vp9
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+type bitWriter struct {
+	b []byte
+	n int
+}
+
+func (w *bitWriter) bits(v uint, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.n%8 == 0 {
+			w.b = append(w.b, 0)
+		}
+		w.b[len(w.b)-1] |= byte(v>>uint(i)&1) << uint(7-w.n%8)
+		w.n++
+	}
+}
+
+func (w *bitWriter) colorConfig(h FrameHeader) {
+	if h.Profile >= 2 {
+		if h.BitDepth == 12 {
+			w.bits(1, 1)
+		} else {
+			w.bits(0, 1)
+		}
+	}
+	w.bits(h.ColorSpace, 3)
+	if h.ColorSpace != CS_RGB {
+		w.bits(h.ColorRange, 1)
+		if h.Profile == 1 || h.Profile == 3 {
+			w.bits(h.SubsamplingX, 1)
+			w.bits(h.SubsamplingY, 1)
+			w.bits(0, 1)
+		}
+	} else if h.Profile == 1 || h.Profile == 3 {
+		w.bits(0, 1)
+	}
+}
+
+// testFrame writes the uncompressed header of h followed by some frame data.
+func testFrame(h FrameHeader) []byte {
+	w := &bitWriter{}
+	w.bits(2, 2) // frame_marker
+	w.bits(h.Profile&1, 1)
+	w.bits(h.Profile>>1, 1)
+	if h.Profile == 3 {
+		w.bits(0, 1)
+	}
+	w.bits(h.ShowExistingFrame, 1)
+	if h.ShowExistingFrame != 0 {
+		w.bits(1, 3) // frame_to_show_map_idx
+		return w.b
+	}
+	w.bits(h.FrameType, 1)
+	w.bits(h.ShowFrame, 1)
+	w.bits(h.ErrorResilient, 1)
+	if h.FrameType == FRAME_KEY {
+		w.bits(frameSyncCode, 24)
+		w.colorConfig(h)
+	} else {
+		if h.ShowFrame == 0 {
+			w.bits(h.IntraOnly, 1)
+		}
+		if h.ErrorResilient == 0 {
+			w.bits(0, 2) // reset_frame_context
+		}
+		if h.IntraOnly == 0 {
+			w.bits(0x5a, 8)
+			return w.b
+		}
+		w.bits(frameSyncCode, 24)
+		if h.Profile > 0 {
+			w.colorConfig(h)
+		}
+		w.bits(0xff, 8) // refresh_frame_flags
+	}
+	w.bits(h.Width-1, 16)
+	w.bits(h.Height-1, 16)
+	w.bits(0x5a, 8)
+	return w.b
+}
+
+func TestParseFrameHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		h    FrameHeader
+		key  bool
+	}{
+		{
+			name: "key profile 0",
+			h:    FrameHeader{ShowFrame: 1, BitDepth: 8, ColorSpace: CS_BT_709, SubsamplingX: 1, SubsamplingY: 1, Width: 1280, Height: 720},
+			key:  true,
+		},
+		{
+			name: "key profile 1 444",
+			h:    FrameHeader{Profile: 1, ShowFrame: 1, BitDepth: 8, ColorSpace: CS_BT_601, ColorRange: 1, Width: 640, Height: 480},
+			key:  true,
+		},
+		{
+			name: "key profile 1 rgb",
+			h:    FrameHeader{Profile: 1, ShowFrame: 1, ErrorResilient: 1, BitDepth: 8, ColorSpace: CS_RGB, ColorRange: 1, Width: 320, Height: 240},
+			key:  true,
+		},
+		{
+			name: "key profile 2 10bit",
+			h:    FrameHeader{Profile: 2, ShowFrame: 1, BitDepth: 10, ColorSpace: CS_BT_2020, SubsamplingX: 1, SubsamplingY: 1, Width: 3840, Height: 2160},
+			key:  true,
+		},
+		{
+			name: "key profile 3 12bit 422",
+			h:    FrameHeader{Profile: 3, ShowFrame: 1, BitDepth: 12, ColorSpace: CS_BT_709, SubsamplingX: 1, Width: 1920, Height: 1080},
+			key:  true,
+		},
+		{
+			name: "intra only profile 0",
+			h:    FrameHeader{FrameType: FRAME_NON_KEY, IntraOnly: 1, BitDepth: 8, ColorSpace: CS_BT_601, SubsamplingX: 1, SubsamplingY: 1, Width: 352, Height: 288},
+		},
+		{
+			name: "intra only profile 2",
+			h:    FrameHeader{Profile: 2, FrameType: FRAME_NON_KEY, IntraOnly: 1, BitDepth: 12, ColorSpace: CS_SMPTE_170, SubsamplingX: 1, SubsamplingY: 1, Width: 1024, Height: 576},
+		},
+		{
+			name: "intra only profile 3",
+			h:    FrameHeader{Profile: 3, FrameType: FRAME_NON_KEY, ErrorResilient: 1, IntraOnly: 1, BitDepth: 10, ColorSpace: CS_BT_709, Width: 800, Height: 600},
+		},
+		{
+			name: "inter profile 0",
+			h:    FrameHeader{FrameType: FRAME_NON_KEY, ShowFrame: 1},
+		},
+		{
+			name: "inter profile 3",
+			h:    FrameHeader{Profile: 3, FrameType: FRAME_NON_KEY, ShowFrame: 1, ErrorResilient: 1},
+		},
+		{
+			name: "show existing",
+			h:    FrameHeader{Profile: 1, ShowExistingFrame: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := testFrame(tt.h)
+			h, err := ParseFrameHeader(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if h != tt.h {
+				t.Fatalf("header %+v, want %+v", h, tt.h)
+			}
+			if h.IsKeyFrame() != tt.key || IsKeyFrame(b) != tt.key {
+				t.Fatalf("key frame %v", h.IsKeyFrame())
+			}
+			// up to the frame size
+			for i := 0; tt.h.Width != 0 && i < len(b)-1; i++ {
+				if _, err := ParseFrameHeader(b[:i]); err == nil {
+					t.Fatalf("header cut at %d parsed", i)
+				}
+			}
+		})
+	}
+
+	bad := testFrame(FrameHeader{ShowFrame: 1, Width: 16, Height: 16})
+	bad[2] ^= 0x10
+	if _, err := ParseFrameHeader(bad); err == nil {
+		t.Fatal("bad sync code parsed")
+	}
+	if _, err := ParseFrameHeader([]byte{0x40}); err == nil {
+		t.Fatal("bad frame marker parsed")
+	}
+}
+
+func TestSplitSuperframe(t *testing.T) {
+	key := testFrame(FrameHeader{ShowFrame: 1, BitDepth: 8, SubsamplingX: 1, SubsamplingY: 1, Width: 64, Height: 64})
+	hidden := append(append([]byte(nil), key...), make([]byte, 300)...)
+	inter := testFrame(FrameHeader{FrameType: FRAME_NON_KEY, ShowFrame: 1})
+
+	concat := func(b ...[]byte) []byte {
+		return bytes.Join(b, nil)
+	}
+	tests := []struct {
+		name   string
+		data   []byte
+		frames [][]byte
+		err    bool
+	}{
+		{
+			name:   "frame",
+			data:   key,
+			frames: [][]byte{key},
+		},
+		{
+			name:   "two frames",
+			data:   concat(key, inter, []byte{0xc1, byte(len(key)), byte(len(inter)), 0xc1}),
+			frames: [][]byte{key, inter},
+		},
+		{
+			name:   "two byte sizes",
+			data:   concat(hidden, inter, []byte{0xc9, byte(len(hidden)), byte(len(hidden) >> 8), byte(len(inter)), 0, 0xc9}),
+			frames: [][]byte{hidden, inter},
+		},
+		{
+			// the first byte of the index does not match the marker
+			name:   "bad index",
+			data:   concat(key, inter, []byte{0xc2, byte(len(key)), byte(len(inter)), 0xc1}),
+			frames: [][]byte{concat(key, inter, []byte{0xc2, byte(len(key)), byte(len(inter)), 0xc1})},
+		},
+		{
+			name:   "short index",
+			data:   []byte{0xc7, 0xc7},
+			frames: [][]byte{{0xc7, 0xc7}},
+		},
+		{
+			name: "oversized frame",
+			data: concat(key, inter, []byte{0xc1, byte(len(key)), byte(len(inter) + 1), 0xc1}),
+			err:  true,
+		},
+		{
+			name: "empty",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, err := SplitSuperframe(tt.data)
+			if tt.err {
+				if err == nil {
+					t.Fatal("no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprint(frames) != fmt.Sprint(tt.frames) {
+				t.Fatalf("frames %x, want %x", frames, tt.frames)
+			}
+		})
+	}
+
+	if !IsKeyFrame(tests[1].data) || IsKeyFrame(tests[5].data) {
+		t.Fatal("superframe key frame")
+	}
+}
+
+func TestDecoderConfig(t *testing.T) {
+	info := DecoderConfigInfo{
+		Profile: 2, Level: 41, BitDepth: 10, ChromaSubsampling: CHROMA_420_COLOCATED, VideoFullRange: 1,
+		ColourPrimaries: 9, TransferCharacteristics: 16, MatrixCoefficients: 9,
+		CodecInitializationData: []byte{1, 2},
+	}
+	b := make([]byte, 14)
+	n := 0
+	WriteDecoderConfig(b, &n, info)
+	want := []byte{1, 0, 0, 0, 2, 41, 0xa3, 9, 16, 9, 0, 2, 1, 2}
+	if n != len(want) || !bytes.Equal(b, want) {
+		t.Fatalf("vpcC %x, want %x", b[:n], want)
+	}
+
+	for _, data := range [][]byte{b, b[4:]} {
+		got, err := ParseDecoderConfig(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%+v", got) != fmt.Sprintf("%+v", info) {
+			t.Fatalf("info %+v, want %+v", got, info)
+		}
+	}
+	for i := 0; i < len(b); i++ {
+		if _, err := ParseDecoderConfig(b[:i]); err == nil {
+			t.Fatalf("vpcC cut at %d parsed", i)
+		}
+	}
+
+	key := testFrame(FrameHeader{Profile: 3, ShowFrame: 1, BitDepth: 12, ColorSpace: CS_BT_709, SubsamplingX: 1, Width: 1920, Height: 1080})
+	c, err := FromKeyFrame(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.W != 1920 || c.H != 1080 || c.Info.Profile != 3 || c.Info.BitDepth != 12 ||
+		c.Info.ChromaSubsampling != CHROMA_422 || c.Info.MatrixCoefficients != 1 {
+		t.Fatalf("codec %+v", c)
+	}
+	c2, err := FromDecoderConfig(c.ConfigBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c2.Equal(*c) || fmt.Sprintf("%+v", c2.Info) != fmt.Sprintf("%+v", c.Info) {
+		t.Fatalf("codec %+v, want %+v", c2, c)
+	}
+	if _, err := FromKeyFrame(testFrame(FrameHeader{FrameType: FRAME_NON_KEY, ShowFrame: 1})); err == nil {
+		t.Fatal("inter frame made a codec")
+	}
+}
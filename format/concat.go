@@ -0,0 +1,272 @@
+package format
+
+import (
+	"io"
+	"time"
+
+	"github.com/nareix/joy5/av"
+)
+
+type concatTrack struct {
+	video bool
+	idx   int
+}
+
+type concatCfg struct {
+	typ, idx int
+}
+
+type concatLast struct {
+	time time.Duration
+	dur  time.Duration
+}
+
+const DefaultConcatStartWindow = time.Second
+
+// ConcatReader plays URLs back to back. Frame times of each input continue
+// from the end of the previous one, the end being the latest frame time plus
+// the last frame duration of its track. Audio frame durations come from the
+// codec when known.
+//
+// The earliest frame of all tracks of an input is placed at the end. To find
+// it frames are held until each probed stream has one, or for StartWindow
+// when the input can not be probed.
+//
+// Config packets are only sent again when they differ from the ones already
+// sent for the track, metadata only from the first input. Inputs missing a
+// track just leave a gap in it.
+type ConcatReader struct {
+	URLs   []string
+	Opener *URLOpener
+
+	StartWindow time.Duration
+
+	cur     *Reader
+	i       int
+	started bool
+	offset  time.Duration
+	latest  time.Duration
+	tracks  map[concatTrack]*concatLast
+	cfgs    map[concatCfg][]byte
+	streams []av.Stream
+
+	holding   bool
+	held      []av.Packet
+	heldFirst time.Duration
+	heldLast  time.Duration
+	seen      map[concatTrack]bool
+	nstreams  int
+	pending   []av.Packet
+}
+
+func NewConcatReader(o *URLOpener, urls []string) *ConcatReader {
+	return &ConcatReader{
+		URLs:        urls,
+		Opener:      o,
+		StartWindow: DefaultConcatStartWindow,
+		tracks:      map[concatTrack]*concatLast{},
+		cfgs:        map[concatCfg][]byte{},
+	}
+}
+
+func (r *ConcatReader) end() (end time.Duration) {
+	for _, t := range r.tracks {
+		if e := t.time + t.dur; e > end {
+			end = e
+		}
+	}
+	return
+}
+
+func (r *ConcatReader) open() (err error) {
+	if r.cur, err = r.Opener.Open(r.URLs[r.i]); err != nil {
+		return
+	}
+	r.i++
+	r.started = false
+	r.holding = true
+	r.held = nil
+	r.seen = map[concatTrack]bool{}
+	r.nstreams = 0
+	if streams, err := r.cur.Streams(); err == nil {
+		r.nstreams = len(streams)
+	}
+	return
+}
+
+func (r *ConcatReader) hold(pkt av.Packet) {
+	r.held = append(r.held, pkt)
+	if pkt.Type == av.Metadata || av.IsConfig(pkt.Type) {
+		return
+	}
+	if len(r.seen) == 0 || pkt.Time < r.heldFirst {
+		r.heldFirst = pkt.Time
+	}
+	if len(r.seen) == 0 || pkt.Time > r.heldLast {
+		r.heldLast = pkt.Time
+	}
+	r.seen[concatTrack{av.IsVideo(pkt.Type), pkt.Idx}] = true
+	if r.nstreams > 0 && len(r.seen) >= r.nstreams || r.heldLast-r.heldFirst >= r.StartWindow {
+		r.release()
+	}
+}
+
+func (r *ConcatReader) release() {
+	r.holding = false
+	for _, pkt := range r.held {
+		if r.handle(&pkt) {
+			r.pending = append(r.pending, pkt)
+		}
+	}
+	r.held = nil
+}
+
+// Streams returns the streams of all inputs by track, with the codec of the
+// first input having the track. Inputs after the first are opened just to
+// probe them.
+func (r *ConcatReader) Streams() (streams []av.Stream, err error) {
+	if r.streams != nil {
+		streams = r.streams
+		return
+	}
+	if r.cur == nil {
+		if r.i > 0 || len(r.URLs) == 0 {
+			err = io.EOF
+			return
+		}
+		if err = r.open(); err != nil {
+			return
+		}
+	}
+	var first []av.Stream
+	if first, err = r.cur.Streams(); err != nil {
+		return
+	}
+	streams = mergeStreams(nil, first)
+	for _, u := range r.URLs[r.i:] {
+		var more []av.Stream
+		if more, err = r.probe(u); err != nil {
+			return
+		}
+		streams = mergeStreams(streams, more)
+	}
+	r.streams = streams
+	return
+}
+
+func (r *ConcatReader) probe(u string) (streams []av.Stream, err error) {
+	var fr *Reader
+	if fr, err = r.Opener.Open(u); err != nil {
+		return
+	}
+	defer fr.Close()
+	return fr.Streams()
+}
+
+func mergeStreams(streams, more []av.Stream) []av.Stream {
+	for _, s := range more {
+		found := false
+		for _, t := range streams {
+			if t.IsVideo() == s.IsVideo() && t.Idx == s.Idx {
+				found = true
+				break
+			}
+		}
+		if !found {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+func (r *ConcatReader) handle(pkt *av.Packet) bool {
+	switch {
+	case pkt.Type == av.Metadata:
+		if r.i > 1 {
+			return false
+		}
+		pkt.Time = r.latest
+
+	case av.IsConfig(pkt.Type):
+		k := concatCfg{pkt.Type, pkt.Idx}
+		if last, ok := r.cfgs[k]; ok && av.ConfigEqual(pkt.Type, last, pkt.Data) {
+			return false
+		}
+		r.cfgs[k] = pkt.Data
+		if r.started {
+			pkt.Time = r.latest
+		} else {
+			pkt.Time = r.end()
+		}
+
+	default:
+		if !r.started {
+			r.started = true
+			r.offset = r.end() - r.heldFirst
+		}
+		pkt.Time += r.offset
+
+		k := concatTrack{av.IsVideo(pkt.Type), pkt.Idx}
+		t := r.tracks[k]
+		if t == nil {
+			t = &concatLast{time: pkt.Time}
+			r.tracks[k] = t
+		}
+		if d := pkt.Time - t.time; d > 0 {
+			t.dur = d
+			t.time = pkt.Time
+		}
+		if d := av.PacketDuration(*pkt); d > 0 && pkt.Time == t.time {
+			t.dur = d
+		}
+		if pkt.Time > r.latest {
+			r.latest = pkt.Time
+		}
+	}
+	return true
+}
+
+func (r *ConcatReader) ReadPacket() (pkt av.Packet, err error) {
+	for {
+		if len(r.pending) > 0 {
+			pkt = r.pending[0]
+			r.pending = r.pending[1:]
+			return
+		}
+
+		if r.cur == nil {
+			if r.i >= len(r.URLs) {
+				err = io.EOF
+				return
+			}
+			if err = r.open(); err != nil {
+				return
+			}
+		}
+
+		if pkt, err = r.cur.ReadPacket(); err != nil {
+			if err != io.EOF {
+				return
+			}
+			r.release()
+			r.cur.Close()
+			r.cur = nil
+			continue
+		}
+
+		if r.holding {
+			r.hold(pkt)
+			continue
+		}
+		if r.handle(&pkt) {
+			return
+		}
+	}
+}
+
+func (r *ConcatReader) Close() error {
+	if r.cur != nil {
+		return r.cur.Close()
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package format
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/h264"
+	"github.com/nareix/joy5/format/flv"
+)
+
+var testSPS = []byte{0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x03, 0xc0, 0xf1, 0x83, 0x19, 0x60}
+
+func testH264Config(level byte) []byte {
+	sps := append([]byte(nil), testSPS...)
+	sps[3] = level
+	c := h264.NewCodec()
+	c.AddSPSPPS(sps)
+	c.AddSPSPPS([]byte{0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0})
+	n := 0
+	c.ToConfig(nil, &n)
+	b := make([]byte, n)
+	n = 0
+	c.ToConfig(b, &n)
+	return b
+}
+
+// writeInput writes an FLV of 10 video frames 40ms apart, with the H264
+// level of the SPS, and 44.1kHz AAC every 20ms up to 300ms unless no audio.
+func writeInput(t *testing.T, path string, level byte, audio bool) {
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	m := flv.NewMuxer(f)
+	m.HasVideo, m.HasAudio = true, audio
+
+	pkts := []av.Packet{{Type: av.H264DecoderConfig, Data: testH264Config(level)}}
+	if audio {
+		pkts = append(pkts, av.Packet{Type: av.AACDecoderConfig, Data: []byte{0x12, 0x10}})
+	}
+	at := time.Duration(0)
+	for i := 0; i < 10; i++ {
+		vt := time.Duration(i) * 40 * time.Millisecond
+		for ; audio && at <= vt && at < 300*time.Millisecond; at += 20 * time.Millisecond {
+			pkts = append(pkts, av.Packet{Type: av.AAC, Time: at, Data: []byte{1}})
+		}
+		pkts = append(pkts, av.Packet{Type: av.H264, IsKeyFrame: i == 0, Time: vt, Data: []byte{0, 0, 0, 1, 0x65}})
+	}
+	for _, pkt := range pkts {
+		if err := m.WritePacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConcatReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "concat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, b, c := filepath.Join(dir, "a.flv"), filepath.Join(dir, "b.flv"), filepath.Join(dir, "c.flv")
+	writeInput(t, a, 0x1f, true)
+	writeInput(t, b, 0x1f, true)
+	writeInput(t, c, 0x28, false)
+
+	t.Run("streams of all inputs", func(t *testing.T) {
+		r := NewConcatReader(&URLOpener{}, []string{c, a})
+		defer r.Close()
+		streams, err := r.Streams()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(streams) != 2 || !streams[0].IsVideo() || !streams[1].IsAudio() {
+			t.Fatalf("streams %v", streams)
+		}
+		// the first input is read from the start
+		pkt, err := r.ReadPacket()
+		if err != nil || pkt.Type != av.H264DecoderConfig || pkt.Data[3] != 0x28 {
+			t.Fatalf("first packet %v err %v", pkt.String(), err)
+		}
+	})
+
+	r := NewConcatReader(&URLOpener{}, []string{a, b, c})
+	defer r.Close()
+	if streams, err := r.Streams(); err != nil || len(streams) != 2 {
+		t.Fatalf("streams %v err %v", streams, err)
+	}
+
+	var video, audio []time.Duration
+	var cfgs []string
+	for {
+		pkt, err := r.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch pkt.Type {
+		case av.H264DecoderConfig:
+			cfgs = append(cfgs, fmt.Sprintf("H264 %v level %x", pkt.Time, pkt.Data[3]))
+		case av.AACDecoderConfig:
+			cfgs = append(cfgs, fmt.Sprintf("AAC %v %x", pkt.Time, pkt.Data))
+		case av.H264:
+			video = append(video, pkt.Time)
+		case av.AAC:
+			audio = append(audio, pkt.Time)
+		}
+	}
+	// ends after the last packet
+	if _, err := r.ReadPacket(); err != io.EOF {
+		t.Fatalf("read after end %v", err)
+	}
+
+	// each input starts where the last frame of the previous one ends
+	for i, tm := range video {
+		if want := time.Duration(i) * 40 * time.Millisecond; tm != want {
+			t.Fatalf("video %d at %v, want %v", i, tm, want)
+		}
+	}
+	if len(video) != 30 {
+		t.Fatalf("%d video frames", len(video))
+	}
+	for i, tm := range audio {
+		if want := time.Duration(i/15)*400*time.Millisecond + time.Duration(i%15)*20*time.Millisecond; tm != want {
+			t.Fatalf("audio %d at %v, want %v", i, tm, want)
+		}
+	}
+	if len(audio) != 30 {
+		t.Fatalf("%d audio frames", len(audio))
+	}
+
+	// the same configs of b are not sent again, the new SPS of c is
+	want := []string{"H264 0s level 1f", "AAC 0s 1210", "H264 800ms level 28"}
+	if fmt.Sprint(cfgs) != fmt.Sprint(want) {
+		t.Fatalf("configs %q, want %q", cfgs, want)
+	}
+}
@@ -1,11 +1,15 @@
 package flv
 
 import (
+	"fmt"
 	"io"
 	"io/ioutil"
 
 	"github.com/nareix/joy5/av"
 	"github.com/nareix/joy5/codec/aac"
+	"github.com/nareix/joy5/codec/g711"
+	"github.com/nareix/joy5/codec/mp3"
+	"github.com/nareix/joy5/codec/vp9"
 	"github.com/nareix/joy5/format/flv/flvio"
 )
 
@@ -49,6 +53,7 @@ type Muxer struct {
 	HasVideo       bool
 	HasAudio       bool
 	Publishing     bool
+	Enhanced       bool // write HEVC with Enhanced RTMP FourCC headers instead of codec id 12
 }
 
 func NewMuxer(w io.Writer) *Muxer {
@@ -59,6 +64,20 @@ func NewMuxer(w io.Writer) *Muxer {
 	return m
 }
 
+// SetStreams sets the file header flags from the streams.
+func (w *Muxer) SetStreams(streams []av.Stream) {
+	w.HasVideo = false
+	w.HasAudio = false
+	for _, s := range streams {
+		if s.IsVideo() {
+			w.HasVideo = true
+		}
+		if s.IsAudio() {
+			w.HasAudio = true
+		}
+	}
+}
+
 func (w *Muxer) WriteFileHeader() (err error) {
 	if w.filehdrwritten {
 		return
@@ -107,7 +126,229 @@ func AACTagFromCodec(aac *aac.Codec) flvio.Tag {
 	return tag
 }
 
-func WritePacket(pkt av.Packet, writeTag func(flvio.Tag) error, publishing bool) (err error) {
+// MP3TagFromFrame derives SoundRate/SoundType from the MPEG audio frame header.
+func MP3TagFromFrame(b []byte) flvio.Tag {
+	tag := flvio.Tag{
+		Type:        flvio.TAG_AUDIO,
+		SoundFormat: flvio.SOUND_MP3,
+		SoundRate:   flvio.SOUND_44Khz,
+		SoundSize:   flvio.SOUND_16BIT,
+		SoundType:   flvio.SOUND_STEREO,
+	}
+	h, err := mp3.ParseFrameHeader(b)
+	if err != nil {
+		return tag
+	}
+	// SoundRate only has 5.5, 11, 22 and 44kHz, other rates get the one below
+	// them, 32kHz is 22kHz and 48kHz is 44kHz. Players take the rate from the
+	// MP3 frame header.
+	switch {
+	case h.SampleRate == 8000:
+		tag.SoundFormat = flvio.SOUND_MP3_8KHZ
+		tag.SoundRate = flvio.SOUND_5_5Khz
+	case h.SampleRate >= 44100:
+		tag.SoundRate = flvio.SOUND_44Khz
+	case h.SampleRate >= 22050:
+		tag.SoundRate = flvio.SOUND_22Khz
+	case h.SampleRate >= 11025:
+		tag.SoundRate = flvio.SOUND_11Khz
+	default:
+		tag.SoundRate = flvio.SOUND_5_5Khz
+	}
+	if h.ChannelCount() == 1 {
+		tag.SoundType = flvio.SOUND_MONO
+	}
+	return tag
+}
+
+func G711TagFromCodec(pkttype int, c *g711.Codec) flvio.Tag {
+	tag := flvio.Tag{
+		Type:        flvio.TAG_AUDIO,
+		SoundFormat: flvio.SOUND_ALAW,
+		SoundRate:   flvio.SOUND_5_5Khz,
+		SoundSize:   flvio.SOUND_8BIT,
+		SoundType:   flvio.SOUND_MONO,
+	}
+	if pkttype == av.MULAW {
+		tag.SoundFormat = flvio.SOUND_MULAW
+	}
+	if c != nil && c.Channels > 1 {
+		tag.SoundType = flvio.SOUND_STEREO
+	}
+	return tag
+}
+
+func G711CodecFromTag(tag flvio.Tag) *g711.Codec {
+	law := g711.ALAW
+	if tag.SoundFormat == flvio.SOUND_MULAW {
+		law = g711.MULAW
+	}
+	c := g711.NewCodec(law)
+	if tag.SoundType == flvio.SOUND_STEREO {
+		c.Channels = 2
+	}
+	return c
+}
+
+func videoFormat(pkttype int) uint8 {
+	switch pkttype {
+	case av.H265, av.H265DecoderConfig:
+		return flvio.VIDEO_H265
+	default:
+		return flvio.VIDEO_H264
+	}
+}
+
+// exVideoFourCC reports whether the packet is written with an Enhanced RTMP
+// ExVideoTagHeader. HEVC uses the legacy codec id 12 unless enhanced is set,
+// AV1 and VP9 have no legacy codec id.
+func exVideoFourCC(pkttype int, enhanced bool) (fourcc uint32, isconfig bool, ok bool) {
+	switch pkttype {
+	case av.H265DecoderConfig:
+		return flvio.FOURCC_HVC1, true, enhanced
+	case av.H265:
+		return flvio.FOURCC_HVC1, false, enhanced
+	case av.AV1DecoderConfig:
+		return flvio.FOURCC_AV01, true, true
+	case av.AV1:
+		return flvio.FOURCC_AV01, false, true
+	case av.VP9DecoderConfig:
+		return flvio.FOURCC_VP09, true, true
+	case av.VP9:
+		return flvio.FOURCC_VP09, false, true
+	}
+	return
+}
+
+func exVideoTag(pkt av.Packet, fourcc uint32, isconfig bool) flvio.Tag {
+	tag := flvio.Tag{
+		Type:       flvio.TAG_VIDEO,
+		IsExHeader: true,
+		FourCC:     fourcc,
+		Time:       uint32(flvio.TimeToTs(pkt.Time)),
+		Data:       pkt.Data,
+	}
+	if isconfig {
+		tag.FrameType = flvio.FRAME_KEY
+		tag.PacketType = flvio.PKTTYPE_SEQUENCE_START
+		return tag
+	}
+	if pkt.IsKeyFrame {
+		tag.FrameType = flvio.FRAME_KEY
+	} else {
+		tag.FrameType = flvio.FRAME_INTER
+	}
+	tag.PacketType = flvio.PKTTYPE_CODED_FRAMES
+	tag.CTime = int32(flvio.TimeToTs(pkt.CTime))
+	switch fourcc {
+	case flvio.FOURCC_AVC1, flvio.FOURCC_HVC1:
+		if tag.CTime == 0 {
+			tag.PacketType = flvio.PKTTYPE_CODED_FRAMES_X
+		}
+	}
+	return tag
+}
+
+// exAudioFourCC reports whether the packet is written with an Enhanced RTMP
+// ExAudioTagHeader.
+func exAudioFourCC(pkttype int) (fourcc uint32, isconfig bool, ok bool) {
+	switch pkttype {
+	case av.OpusConfig:
+		return flvio.FOURCC_OPUS, true, true
+	case av.Opus:
+		return flvio.FOURCC_OPUS, false, true
+	}
+	return
+}
+
+func exAudioTag(pkt av.Packet, fourcc uint32, isconfig bool) flvio.Tag {
+	tag := flvio.Tag{
+		Type:        flvio.TAG_AUDIO,
+		SoundFormat: flvio.SOUND_EX_HEADER,
+		IsExHeader:  true,
+		FourCC:      fourcc,
+		Time:        uint32(flvio.TimeToTs(pkt.Time)),
+		Data:        pkt.Data,
+	}
+	if isconfig {
+		tag.PacketType = flvio.PKTTYPE_SEQUENCE_START
+	} else {
+		tag.PacketType = flvio.PKTTYPE_CODED_FRAMES
+	}
+	return tag
+}
+
+// multitrackFourCC is the FourCC used for packets of tracks other than 0.
+func multitrackFourCC(pkttype int) (fourcc uint32, isconfig bool, ok bool) {
+	if fourcc, isconfig, ok = exVideoFourCC(pkttype, true); ok {
+		return
+	}
+	if fourcc, isconfig, ok = exAudioFourCC(pkttype); ok {
+		return
+	}
+	switch pkttype {
+	case av.H264DecoderConfig:
+		return flvio.FOURCC_AVC1, true, true
+	case av.H264:
+		return flvio.FOURCC_AVC1, false, true
+	case av.AACDecoderConfig:
+		return flvio.FOURCC_MP4A, true, true
+	case av.AAC:
+		return flvio.FOURCC_MP4A, false, true
+	case av.MP3:
+		return flvio.FOURCC_MP3, false, true
+	}
+	return
+}
+
+// multitrackTag writes a packet with Idx != 0 as a OneTrack multitrack tag.
+func multitrackTag(pkt av.Packet) (tag flvio.Tag, err error) {
+	fourcc, isconfig, ok := multitrackFourCC(pkt.Type)
+	if !ok || pkt.Idx < 0 || pkt.Idx > 0xff {
+		err = fmt.Errorf("flv: can not write %s to track %d", av.PacketTypeString[pkt.Type], pkt.Idx)
+		return
+	}
+	if av.IsVideo(pkt.Type) {
+		if pkt.Type == av.VP9 {
+			pkt.IsKeyFrame = vp9.IsKeyFrame(pkt.Data)
+		}
+		tag = exVideoTag(pkt, fourcc, isconfig)
+	} else {
+		tag = exAudioTag(pkt, fourcc, isconfig)
+	}
+	if tag.PacketType == flvio.PKTTYPE_CODED_FRAMES_X {
+		tag.PacketType = flvio.PKTTYPE_CODED_FRAMES
+	}
+	tag.IsMultitrack = true
+	tag.MultitrackType = flvio.MULTITRACK_ONE_TRACK
+	tag.FillTracks([]flvio.Track{{
+		FourCC:  fourcc,
+		TrackId: uint8(pkt.Idx),
+		CTime:   tag.CTime,
+		Data:    pkt.Data,
+	}})
+	tag.CTime = 0
+	return
+}
+
+func WritePacket(pkt av.Packet, writeTag func(flvio.Tag) error, publishing bool, enhanced bool) (err error) {
+	if pkt.Idx != 0 && pkt.Type != av.Metadata {
+		var tag flvio.Tag
+		if tag, err = multitrackTag(pkt); err != nil {
+			return
+		}
+		return writeTag(tag)
+	}
+	if fourcc, isconfig, ok := exVideoFourCC(pkt.Type, enhanced); ok {
+		if pkt.Type == av.VP9 {
+			pkt.IsKeyFrame = vp9.IsKeyFrame(pkt.Data)
+		}
+		return writeTag(exVideoTag(pkt, fourcc, isconfig))
+	}
+	if fourcc, isconfig, ok := exAudioFourCC(pkt.Type); ok {
+		return writeTag(exAudioTag(pkt, fourcc, isconfig))
+	}
+
 	switch pkt.Type {
 	case av.AAC:
 		tag := AACTagFromCodec(pkt.AAC)
@@ -116,22 +357,48 @@ func WritePacket(pkt av.Packet, writeTag func(flvio.Tag) error, publishing bool)
 		tag.Data = pkt.Data
 		return writeTag(tag)
 
-	case av.H264DecoderConfig:
+	case av.MP3:
+		tag := MP3TagFromFrame(pkt.Data)
+		tag.Time = uint32(flvio.TimeToTs(pkt.Time))
+		tag.Data = pkt.Data
+		return writeTag(tag)
+
+	case av.ALAW, av.MULAW:
+		tag := G711TagFromCodec(pkt.Type, pkt.G711)
+		tag.Time = uint32(flvio.TimeToTs(pkt.Time))
+		tag.Data = pkt.Data
+		return writeTag(tag)
+
+	case av.Speex:
+		// Speex is always 16kHz mono, which SoundRate can not tell. The
+		// spec has it 0 and players ignore it.
+		tag := flvio.Tag{
+			Type:        flvio.TAG_AUDIO,
+			SoundFormat: flvio.SOUND_SPEEX,
+			SoundRate:   flvio.SOUND_5_5Khz,
+			SoundSize:   flvio.SOUND_16BIT,
+			SoundType:   flvio.SOUND_MONO,
+			Time:        uint32(flvio.TimeToTs(pkt.Time)),
+			Data:        pkt.Data,
+		}
+		return writeTag(tag)
+
+	case av.H264DecoderConfig, av.H265DecoderConfig:
 		tag := flvio.Tag{
 			Type:          flvio.TAG_VIDEO,
 			FrameType:     flvio.FRAME_KEY,
 			AVCPacketType: flvio.AVC_SEQHDR,
-			VideoFormat:   flvio.VIDEO_H264,
+			VideoFormat:   videoFormat(pkt.Type),
 			Data:          pkt.Data,
 			Time:          uint32(flvio.TimeToTs(pkt.Time)),
 		}
 		return writeTag(tag)
 
-	case av.H264:
+	case av.H264, av.H265:
 		tag := flvio.Tag{
 			Type:          flvio.TAG_VIDEO,
 			AVCPacketType: flvio.AVC_NALU,
-			VideoFormat:   flvio.VIDEO_H264,
+			VideoFormat:   videoFormat(pkt.Type),
 			CTime:         int32(flvio.TimeToTs(pkt.CTime)),
 		}
 		if pkt.IsKeyFrame {
@@ -173,7 +440,7 @@ func WritePacket(pkt av.Packet, writeTag func(flvio.Tag) error, publishing bool)
 }
 
 func (w *Muxer) WritePacket(pkt av.Packet) (err error) {
-	return WritePacket(pkt, w.WriteTag, w.Publishing)
+	return WritePacket(pkt, w.WriteTag, w.Publishing, w.Enhanced)
 }
 
 type Demuxer struct {
@@ -181,6 +448,7 @@ type Demuxer struct {
 	b          []byte
 	gotfilehdr bool
 	Malloc     func(int) ([]byte, error)
+	pr         *PacketReader
 
 	LogHeaderEvent func(flags uint8)
 }
@@ -193,6 +461,7 @@ func NewDemuxer(r io.Reader) *Demuxer {
 			return make([]byte, n), nil
 		},
 	}
+	d.pr = NewPacketReader(d.ReadTag)
 	return d
 }
 
@@ -211,6 +480,9 @@ func (r *Demuxer) ReadFileHeader() (err error) {
 	if r.LogHeaderEvent != nil {
 		r.LogHeaderEvent(flags)
 	}
+	if flags&(flvio.FILE_HAS_VIDEO|flvio.FILE_HAS_AUDIO) != 0 {
+		r.pr.SetHint(flags&flvio.FILE_HAS_VIDEO != 0, flags&flvio.FILE_HAS_AUDIO != 0)
+	}
 	if _, err = io.CopyN(ioutil.Discard, r.r, int64(skip)); err != nil {
 		return
 	}
@@ -228,70 +500,250 @@ func (r *Demuxer) ReadTag() (tag flvio.Tag, err error) {
 	return
 }
 
-func ReadPacket(readTag func() (flvio.Tag, error)) (pkt av.Packet, err error) {
-	for {
-		var tag flvio.Tag
-		if tag, err = readTag(); err != nil {
+func exVideoPacket(tag flvio.Tag) (pkt av.Packet, ok bool) {
+	var typ, cfgtyp int
+	switch tag.FourCC {
+	case flvio.FOURCC_AVC1:
+		typ, cfgtyp = av.H264, av.H264DecoderConfig
+	case flvio.FOURCC_HVC1:
+		typ, cfgtyp = av.H265, av.H265DecoderConfig
+	case flvio.FOURCC_AV01:
+		typ, cfgtyp = av.AV1, av.AV1DecoderConfig
+	case flvio.FOURCC_VP09:
+		typ, cfgtyp = av.VP9, av.VP9DecoderConfig
+	default:
+		return
+	}
+
+	switch tag.PacketType {
+	case flvio.PKTTYPE_SEQUENCE_START:
+		pkt = av.Packet{
+			Type: cfgtyp,
+			Data: tag.Data,
+		}
+		ok = true
+	case flvio.PKTTYPE_CODED_FRAMES, flvio.PKTTYPE_CODED_FRAMES_X:
+		pkt = av.Packet{
+			Type:       typ,
+			Data:       tag.Data,
+			Time:       flvio.TsToTime(int64(tag.Time)),
+			CTime:      flvio.TsToTime(int64(tag.CTime)),
+			IsKeyFrame: tag.FrameType == flvio.FRAME_KEY,
+		}
+		if typ == av.VP9 {
+			pkt.IsKeyFrame = vp9.IsKeyFrame(tag.Data)
+		}
+		ok = true
+	}
+	return
+}
+
+func exAudioPacket(tag flvio.Tag) (pkt av.Packet, ok bool) {
+	var typ, cfgtyp int
+	switch tag.FourCC {
+	case flvio.FOURCC_MP4A:
+		typ, cfgtyp = av.AAC, av.AACDecoderConfig
+	case flvio.FOURCC_OPUS:
+		typ, cfgtyp = av.Opus, av.OpusConfig
+	case flvio.FOURCC_MP3:
+		typ, cfgtyp = av.MP3, -1
+	default:
+		return
+	}
+
+	switch tag.PacketType {
+	case flvio.PKTTYPE_SEQUENCE_START:
+		if cfgtyp < 0 {
+			return
+		}
+		pkt = av.Packet{
+			Type: cfgtyp,
+			Data: tag.Data,
+		}
+		ok = true
+	case flvio.PKTTYPE_CODED_FRAMES:
+		pkt = av.Packet{
+			Type: typ,
+			Data: tag.Data,
+			Time: flvio.TsToTime(int64(tag.Time)),
+		}
+		ok = true
+	}
+	return
+}
+
+// TagPacket converts a single track tag to a packet.
+func TagPacket(tag flvio.Tag) (pkt av.Packet, ok bool) {
+	switch tag.Type {
+	case flvio.TAG_AMF0, flvio.TAG_AMF3:
+		data := convertToAMF0Metadata(tag.Data, tag.Type == flvio.TAG_AMF3)
+		if data != nil {
+			pkt = av.Packet{
+				Type: av.Metadata,
+				Data: data,
+				Time: flvio.TsToTime(int64(tag.Time)),
+			}
+			ok = true
 			return
 		}
 
-		switch tag.Type {
-		case flvio.TAG_AMF0, flvio.TAG_AMF3:
-			data := convertToAMF0Metadata(tag.Data, tag.Type == flvio.TAG_AMF3)
-			if data != nil {
+	case flvio.TAG_VIDEO:
+		if tag.IsExHeader {
+			return exVideoPacket(tag)
+		}
+
+		switch tag.VideoFormat {
+		case flvio.VIDEO_H264, flvio.VIDEO_H265:
+			h265 := tag.VideoFormat == flvio.VIDEO_H265
+			switch tag.AVCPacketType {
+			case flvio.AVC_SEQHDR:
 				pkt = av.Packet{
-					Type: av.Metadata,
-					Data: data,
-					Time: flvio.TsToTime(int64(tag.Time)),
+					Type: av.H264DecoderConfig,
+					Data: tag.Data,
+				}
+				if h265 {
+					pkt.Type = av.H265DecoderConfig
+				}
+				ok = true
+				return
+			case flvio.AVC_NALU:
+				pkt = av.Packet{
+					Type:       av.H264,
+					Data:       tag.Data,
+					Time:       flvio.TsToTime(int64(tag.Time)),
+					CTime:      flvio.TsToTime(int64(tag.CTime)),
+					IsKeyFrame: tag.FrameType == flvio.FRAME_KEY,
 				}
+				if h265 {
+					pkt.Type = av.H265
+				}
+				ok = true
 				return
 			}
+		}
+
+	case flvio.TAG_AUDIO:
+		if tag.IsExHeader {
+			return exAudioPacket(tag)
+		}
 
-		case flvio.TAG_VIDEO:
-			switch tag.VideoFormat {
-			case flvio.VIDEO_H264:
-				switch tag.AVCPacketType {
-				case flvio.AVC_SEQHDR:
-					pkt = av.Packet{
-						Type: av.H264DecoderConfig,
-						Data: tag.Data,
-					}
-					return
-				case flvio.AVC_NALU:
-					pkt = av.Packet{
-						Type:       av.H264,
-						Data:       tag.Data,
-						Time:       flvio.TsToTime(int64(tag.Time)),
-						CTime:      flvio.TsToTime(int64(tag.CTime)),
-						IsKeyFrame: tag.FrameType == flvio.FRAME_KEY,
-					}
-					return
+		switch tag.SoundFormat {
+		case flvio.SOUND_AAC:
+			switch tag.AACPacketType {
+			case flvio.AAC_SEQHDR:
+				pkt = av.Packet{
+					Type: av.AACDecoderConfig,
+					Data: tag.Data,
+				}
+				ok = true
+				return
+			case flvio.AAC_RAW:
+				pkt = av.Packet{
+					Type: av.AAC,
+					Data: tag.Data,
+					Time: flvio.TsToTime(int64(tag.Time)),
 				}
+				ok = true
+				return
 			}
 
-		case flvio.TAG_AUDIO:
-			switch tag.SoundFormat {
-			case flvio.SOUND_AAC:
-				switch tag.AACPacketType {
-				case flvio.AAC_SEQHDR:
-					pkt = av.Packet{
-						Type: av.AACDecoderConfig,
-						Data: tag.Data,
-					}
-					return
-				case flvio.AAC_RAW:
-					pkt = av.Packet{
-						Type: av.AAC,
-						Data: tag.Data,
-						Time: flvio.TsToTime(int64(tag.Time)),
-					}
-					return
-				}
+		case flvio.SOUND_MP3, flvio.SOUND_MP3_8KHZ:
+			pkt = av.Packet{
+				Type: av.MP3,
+				Data: tag.Data,
+				Time: flvio.TsToTime(int64(tag.Time)),
+			}
+			ok = true
+			return
+
+		case flvio.SOUND_ALAW, flvio.SOUND_MULAW:
+			pkt = av.Packet{
+				Type: av.ALAW,
+				Data: tag.Data,
+				Time: flvio.TsToTime(int64(tag.Time)),
+				G711: G711CodecFromTag(tag),
+			}
+			if tag.SoundFormat == flvio.SOUND_MULAW {
+				pkt.Type = av.MULAW
+			}
+			ok = true
+			return
+
+		case flvio.SOUND_SPEEX:
+			pkt = av.Packet{
+				Type: av.Speex,
+				Data: tag.Data,
+				Time: flvio.TsToTime(int64(tag.Time)),
+			}
+			ok = true
+			return
+		}
+	}
+
+	return
+}
+
+// tagPackets converts a tag to packets, one per track for multitrack tags.
+func tagPackets(tag flvio.Tag) (pkts []av.Packet) {
+	if !tag.IsMultitrack {
+		if pkt, ok := TagPacket(tag); ok {
+			pkts = append(pkts, pkt)
+		}
+		return
+	}
+
+	tracks, err := tag.ParseTracks()
+	if err != nil {
+		return
+	}
+	for _, tr := range tracks {
+		ttag := tag
+		ttag.IsMultitrack = false
+		ttag.FourCC = tr.FourCC
+		ttag.CTime = tr.CTime
+		ttag.Data = tr.Data
+		if pkt, ok := TagPacket(ttag); ok {
+			pkt.Idx = int(tr.TrackId)
+			pkts = append(pkts, pkt)
+		}
+	}
+	return
+}
+
+// ReadPackets reads until a tag gives packets. Multitrack tags give a
+// packet per track.
+func ReadPackets(readTag func() (flvio.Tag, error)) (pkts []av.Packet, err error) {
+	for {
+		var tag flvio.Tag
+		if tag, err = readTag(); err != nil {
+			return
+		}
+		if pkts = tagPackets(tag); len(pkts) > 0 {
+			return
+		}
+	}
+}
+
+// ReadPacket only returns packets of track 0.
+func ReadPacket(readTag func() (flvio.Tag, error)) (pkt av.Packet, err error) {
+	for {
+		var pkts []av.Packet
+		if pkts, err = ReadPackets(readTag); err != nil {
+			return
+		}
+		for _, p := range pkts {
+			if p.Idx == 0 {
+				pkt = p
+				return
 			}
 		}
 	}
 }
 
 func (r *Demuxer) ReadPacket() (pkt av.Packet, err error) {
-	return ReadPacket(r.ReadTag)
+	return r.pr.ReadPacket()
+}
+
+func (r *Demuxer) Streams() ([]av.Stream, error) {
+	return r.pr.Streams()
 }
@@ -55,8 +55,10 @@ const (
 	SOUND_NELLYMOSER            = 6
 	SOUND_ALAW                  = 7
 	SOUND_MULAW                 = 8
+	SOUND_EX_HEADER             = 9
 	SOUND_AAC                   = 10
 	SOUND_SPEEX                 = 11
+	SOUND_MP3_8KHZ              = 14
 
 	SOUND_5_5Khz = 0
 	SOUND_11Khz  = 1
@@ -85,6 +87,71 @@ const (
 	VIDEO_H265 = 12
 )
 
+// Enhanced RTMP VideoPacketType and AudioPacketType
+const (
+	PKTTYPE_SEQUENCE_START         = 0
+	PKTTYPE_CODED_FRAMES           = 1
+	PKTTYPE_SEQUENCE_END           = 2
+	PKTTYPE_CODED_FRAMES_X         = 3
+	PKTTYPE_METADATA               = 4
+	PKTTYPE_MPEG2TS_SEQUENCE_START = 5
+
+	PKTTYPE_AUDIO_MULTICHANNEL_CONFIG = 4
+	PKTTYPE_AUDIO_MULTITRACK          = 5
+	PKTTYPE_VIDEO_MULTITRACK          = 6
+	PKTTYPE_MODEX                     = 7
+)
+
+// Enhanced RTMP AvMultitrackType
+const (
+	MULTITRACK_ONE_TRACK               = 0
+	MULTITRACK_MANY_TRACKS             = 1
+	MULTITRACK_MANY_TRACKS_MANY_CODECS = 2
+)
+
+// Enhanced RTMP FourCC
+const (
+	FOURCC_AVC1 = 0x61766331 // 'avc1'
+	FOURCC_HVC1 = 0x68766331 // 'hvc1'
+	FOURCC_AV01 = 0x61763031 // 'av01'
+	FOURCC_VP09 = 0x76703039 // 'vp09'
+	FOURCC_OPUS = 0x4f707573 // 'Opus'
+	FOURCC_FLAC = 0x664c6143 // 'fLaC'
+	FOURCC_AC3  = 0x61632d33 // 'ac-3'
+	FOURCC_EAC3 = 0x65632d33 // 'ec-3'
+	FOURCC_MP3  = 0x2e6d7033 // '.mp3'
+	FOURCC_MP4A = 0x6d703461 // 'mp4a'
+)
+
+func FourCCString(v uint32) string {
+	return string([]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)})
+}
+
+func StringToFourCC(s string) uint32 {
+	if len(s) != 4 {
+		return 0
+	}
+	return pio.U32BE([]byte(s))
+}
+
+func PacketTypeString(v uint8) string {
+	switch v {
+	case PKTTYPE_SEQUENCE_START:
+		return "SequenceStart"
+	case PKTTYPE_CODED_FRAMES:
+		return "CodedFrames"
+	case PKTTYPE_SEQUENCE_END:
+		return "SequenceEnd"
+	case PKTTYPE_CODED_FRAMES_X:
+		return "CodedFramesX"
+	case PKTTYPE_METADATA:
+		return "Metadata"
+	case PKTTYPE_MPEG2TS_SEQUENCE_START:
+		return "MPEG2TSSequenceStart"
+	}
+	return fmt.Sprint(v)
+}
+
 type Tag struct {
 	Type uint8
 
@@ -99,7 +166,7 @@ type Tag struct {
 		6 = Nellymoser
 		7 = G.711 A-law logarithmic PCM
 		8 = G.711 mu-law logarithmic PCM
-		9 = reserved
+		9 = ExHeader (Enhanced RTMP)
 		10 = AAC
 		11 = Speex
 		14 = MP3 8-Khz
@@ -172,6 +239,34 @@ type Tag struct {
 	*/
 	AVCPacketType uint8
 
+	/*
+		Enhanced RTMP ExVideoTagHeader / ExAudioTagHeader.
+		Video: IsExHeader UB[1], FrameType UB[3], PacketType UB[4], FourCC UI32
+		Audio: SoundFormat UB[4] == 9, PacketType UB[4], FourCC UI32
+	*/
+	IsExHeader bool
+
+	/*
+		0: SequenceStart
+		1: CodedFrames
+		2: SequenceEnd
+		3: CodedFramesX (video only, CTime is zero)
+		4: Metadata (video) / MultichannelConfig (audio)
+		5: MPEG2TSSequenceStart (video only)
+	*/
+	PacketType uint8
+
+	FourCC uint32
+
+	/*
+		Enhanced RTMP multitrack, PacketType is Multitrack (video 6, audio 5):
+		MultitrackType UB[4], PacketType UB[4], FourCC UI32 unless ManyTracksManyCodecs
+		Each track in Data: [FourCC UI32], TrackId UI8, [SizeOfTrack UI24 unless OneTrack], body
+		PacketType above is then the packet type of all the tracks.
+	*/
+	IsMultitrack   bool
+	MultitrackType uint8
+
 	Time  uint32
 	CTime int32
 
@@ -188,13 +283,26 @@ func (t Tag) DebugFields() []interface{} {
 		p = append(p, "FrameType")
 		p = append(p, FrameTypeString(t.FrameType))
 
-		p = append(p, "VideoFormat")
-		p = append(p, t.VideoFormat)
-
-		switch t.VideoFormat {
-		case VIDEO_H264, VIDEO_H265:
-			p = append(p, "AVCPacketType")
-			p = append(p, t.AVCPacketType)
+		if t.IsExHeader {
+			p = append(p, "FourCC")
+			p = append(p, FourCCString(t.FourCC))
+
+			p = append(p, "PacketType")
+			p = append(p, PacketTypeString(t.PacketType))
+
+			if t.IsMultitrack {
+				p = append(p, "MultitrackType")
+				p = append(p, t.MultitrackType)
+			}
+		} else {
+			p = append(p, "VideoFormat")
+			p = append(p, t.VideoFormat)
+
+			switch t.VideoFormat {
+			case VIDEO_H264, VIDEO_H265:
+				p = append(p, "AVCPacketType")
+				p = append(p, t.AVCPacketType)
+			}
 		}
 
 		if t.CTime != 0 {
@@ -202,6 +310,23 @@ func (t Tag) DebugFields() []interface{} {
 			p = append(p, t.CTime)
 		}
 
+	case TAG_AUDIO:
+		if t.IsExHeader {
+			p = append(p, "FourCC")
+			p = append(p, FourCCString(t.FourCC))
+
+			p = append(p, "PacketType")
+			p = append(p, PacketTypeString(t.PacketType))
+
+			if t.IsMultitrack {
+				p = append(p, "MultitrackType")
+				p = append(p, t.MultitrackType)
+			}
+		} else {
+			p = append(p, "SoundFormat")
+			p = append(p, t.SoundFormat)
+		}
+
 	case TAG_AMF0, TAG_AMF3:
 		amf3 := t.Type == TAG_AMF3
 		arr, _ := ParseAMFVals(t.Data, amf3)
@@ -225,6 +350,14 @@ func (t *Tag) parseAudioHeader(b []byte) (n int, err error) {
 		return
 	}
 	t.SoundFormat = flags >> 4
+
+	if t.SoundFormat == SOUND_EX_HEADER {
+		t.IsExHeader = true
+		t.PacketType = flags & 0xf
+		err = t.parseExHeader(b, &n, PKTTYPE_AUDIO_MULTITRACK)
+		return
+	}
+
 	t.SoundRate = (flags >> 2) & 0x3
 	t.SoundSize = (flags >> 1) & 0x1
 	t.SoundType = flags & 0x1
@@ -240,6 +373,16 @@ func (t *Tag) parseAudioHeader(b []byte) (n int, err error) {
 }
 
 func (t Tag) fillAudioHeader(b []byte) (n int) {
+	if t.IsExHeader {
+		if t.IsMultitrack {
+			pio.WriteU8(b, &n, SOUND_EX_HEADER<<4|PKTTYPE_AUDIO_MULTITRACK)
+		} else {
+			pio.WriteU8(b, &n, SOUND_EX_HEADER<<4|t.PacketType&0xf)
+		}
+		t.fillExHeader(b, &n, PKTTYPE_AUDIO_MULTITRACK)
+		return
+	}
+
 	var flags uint8
 	flags |= t.SoundFormat << 4
 	flags |= t.SoundRate << 2
@@ -260,6 +403,24 @@ func (t *Tag) parseVideoHeader(b []byte) (n int, err error) {
 	if flags, err = pio.ReadU8(b, &n); err != nil {
 		return
 	}
+
+	if flags&0x80 != 0 {
+		t.IsExHeader = true
+		t.FrameType = (flags >> 4) & 0x7
+		t.PacketType = flags & 0xf
+		if err = t.parseExHeader(b, &n, PKTTYPE_VIDEO_MULTITRACK); err != nil {
+			return
+		}
+		if t.hasExCTime() {
+			var v int32
+			if v, err = pio.ReadI24BE(b, &n); err != nil {
+				return
+			}
+			t.CTime = v
+		}
+		return
+	}
+
 	t.FrameType = flags >> 4
 	t.VideoFormat = flags & 0xf
 
@@ -278,7 +439,90 @@ func (t *Tag) parseVideoHeader(b []byte) (n int, err error) {
 	return
 }
 
+// parseExHeader reads the part of the ex header after the packet type:
+// ModEx entries are skipped, then the multitrack type and the FourCC.
+func (t *Tag) parseExHeader(b []byte, n *int, multitrack uint8) (err error) {
+	var v uint8
+	for t.PacketType == PKTTYPE_MODEX {
+		if v, err = pio.ReadU8(b, n); err != nil {
+			return
+		}
+		size := int(v) + 1
+		if size == 256 {
+			var u16 uint16
+			if u16, err = pio.ReadU16BE(b, n); err != nil {
+				return
+			}
+			size = int(u16) + 1
+		}
+		if _, err = pio.ReadBytes(b, n, size); err != nil {
+			return
+		}
+		if v, err = pio.ReadU8(b, n); err != nil {
+			return
+		}
+		t.PacketType = v & 0xf
+	}
+
+	if t.PacketType == multitrack {
+		t.IsMultitrack = true
+		if v, err = pio.ReadU8(b, n); err != nil {
+			return
+		}
+		t.MultitrackType = v >> 4
+		t.PacketType = v & 0xf
+		if t.MultitrackType == MULTITRACK_MANY_TRACKS_MANY_CODECS {
+			return
+		}
+	}
+
+	if t.FourCC, err = pio.ReadU32BE(b, n); err != nil {
+		return
+	}
+	return
+}
+
+func (t Tag) fillExHeader(b []byte, n *int, multitrack uint8) {
+	if t.IsMultitrack {
+		pio.WriteU8(b, n, t.MultitrackType<<4|t.PacketType&0xf)
+		if t.MultitrackType == MULTITRACK_MANY_TRACKS_MANY_CODECS {
+			return
+		}
+	}
+	pio.WriteU32BE(b, n, t.FourCC)
+}
+
+// CodedFrames of AVC and HEVC carry a SI24 composition time offset.
+func hasExCTime(tagtype uint8, pkttype uint8, fourcc uint32) bool {
+	if tagtype != TAG_VIDEO || pkttype != PKTTYPE_CODED_FRAMES {
+		return false
+	}
+	switch fourcc {
+	case FOURCC_AVC1, FOURCC_HVC1:
+		return true
+	}
+	return false
+}
+
+// For multitrack tags CTime is in each track.
+func (t Tag) hasExCTime() bool {
+	return !t.IsMultitrack && hasExCTime(t.Type, t.PacketType, t.FourCC)
+}
+
 func (t Tag) fillVideoHeader(b []byte) (n int) {
+	if t.IsExHeader {
+		if t.IsMultitrack {
+			pio.WriteU8(b, &n, 0x80|(t.FrameType&0x7)<<4|PKTTYPE_VIDEO_MULTITRACK)
+		} else {
+			pio.WriteU8(b, &n, 0x80|(t.FrameType&0x7)<<4|t.PacketType&0xf)
+		}
+		t.fillExHeader(b, &n, PKTTYPE_VIDEO_MULTITRACK)
+		if t.hasExCTime() {
+			pio.WriteI24BE(b, &n, int32(t.CTime))
+		}
+		return
+	}
+
 	pio.WriteU8(b, &n, t.FrameType<<4|t.VideoFormat)
 
 	switch t.VideoFormat {
@@ -289,6 +533,80 @@ func (t Tag) fillVideoHeader(b []byte) (n int) {
 	return
 }
 
+type Track struct {
+	FourCC  uint32
+	TrackId uint8
+	CTime   int32
+	Data    []byte
+}
+
+// ParseTracks splits the Data of a multitrack tag into tracks.
+func (t Tag) ParseTracks() (tracks []Track, err error) {
+	b := t.Data
+	n := 0
+	for n < len(b) {
+		tr := Track{FourCC: t.FourCC}
+		if t.MultitrackType == MULTITRACK_MANY_TRACKS_MANY_CODECS {
+			if tr.FourCC, err = pio.ReadU32BE(b, &n); err != nil {
+				return
+			}
+		}
+		if tr.TrackId, err = pio.ReadU8(b, &n); err != nil {
+			return
+		}
+		size := len(b) - n
+		if t.MultitrackType != MULTITRACK_ONE_TRACK {
+			var u24 uint32
+			if u24, err = pio.ReadU24BE(b, &n); err != nil {
+				return
+			}
+			size = int(u24)
+		}
+		var body []byte
+		if body, err = pio.ReadBytes(b, &n, size); err != nil {
+			return
+		}
+		if hasExCTime(t.Type, t.PacketType, tr.FourCC) {
+			m := 0
+			if tr.CTime, err = pio.ReadI24BE(body, &m); err != nil {
+				return
+			}
+			body = body[m:]
+		}
+		tr.Data = body
+		tracks = append(tracks, tr)
+	}
+	return
+}
+
+// FillTracks sets Data of a multitrack tag from tracks.
+func (t *Tag) FillTracks(tracks []Track) {
+	fill := func(b []byte) (n int) {
+		for _, tr := range tracks {
+			if t.MultitrackType == MULTITRACK_MANY_TRACKS_MANY_CODECS {
+				pio.WriteU32BE(b, &n, tr.FourCC)
+			}
+			pio.WriteU8(b, &n, tr.TrackId)
+			ctime := hasExCTime(t.Type, t.PacketType, tr.FourCC)
+			if t.MultitrackType != MULTITRACK_ONE_TRACK {
+				size := len(tr.Data)
+				if ctime {
+					size += 3
+				}
+				pio.WriteU24BE(b, &n, uint32(size))
+			}
+			if ctime {
+				pio.WriteI24BE(b, &n, tr.CTime)
+			}
+			pio.WriteBytes(b, &n, tr.Data)
+		}
+		return
+	}
+	b := make([]byte, fill(nil))
+	fill(b)
+	t.Data = b
+}
+
 func (t Tag) FillHeader(b []byte) (n int) {
 	switch t.Type {
 	case TAG_AUDIO:
@@ -0,0 +1,88 @@
+package flvio
+
+import (
+	"testing"
+)
+
+func TestExVideoHeader(t *testing.T) {
+	tests := []struct {
+		tag   Tag
+		bytes []byte
+	}{
+		{
+			Tag{Type: TAG_VIDEO, IsExHeader: true, FrameType: FRAME_KEY, PacketType: PKTTYPE_SEQUENCE_START, FourCC: FOURCC_HVC1},
+			[]byte{0x90, 'h', 'v', 'c', '1'},
+		},
+		{
+			Tag{Type: TAG_VIDEO, IsExHeader: true, FrameType: FRAME_INTER, PacketType: PKTTYPE_CODED_FRAMES, FourCC: FOURCC_HVC1, CTime: 40},
+			[]byte{0xa1, 'h', 'v', 'c', '1', 0x00, 0x00, 0x28},
+		},
+		{
+			Tag{Type: TAG_VIDEO, IsExHeader: true, FrameType: FRAME_KEY, PacketType: PKTTYPE_CODED_FRAMES_X, FourCC: FOURCC_HVC1},
+			[]byte{0x93, 'h', 'v', 'c', '1'},
+		},
+		{
+			Tag{Type: TAG_VIDEO, IsExHeader: true, FrameType: FRAME_KEY, PacketType: PKTTYPE_CODED_FRAMES, FourCC: FOURCC_AV01},
+			[]byte{0x91, 'a', 'v', '0', '1'},
+		},
+	}
+
+	for _, test := range tests {
+		b := make([]byte, test.tag.MaxHeaderLen())
+		n := test.tag.FillHeader(b)
+		assertEqual(t, b[:n], test.bytes)
+
+		tag := Tag{Type: TAG_VIDEO}
+		n, err := tag.ParseHeader(test.bytes)
+		assertEqual(t, err, nil)
+		assertEqual(t, n, len(test.bytes))
+		tag.Header = nil
+		assertEqual(t, tag, test.tag)
+	}
+}
+
+func TestExAudioHeader(t *testing.T) {
+	tag := Tag{Type: TAG_AUDIO, SoundFormat: SOUND_EX_HEADER, IsExHeader: true, PacketType: PKTTYPE_CODED_FRAMES, FourCC: FOURCC_OPUS}
+	b := make([]byte, tag.MaxHeaderLen())
+	n := tag.FillHeader(b)
+	assertEqual(t, b[:n], []byte{0x91, 'O', 'p', 'u', 's'})
+
+	ptag := Tag{Type: TAG_AUDIO}
+	_, err := ptag.ParseHeader(b[:n])
+	assertEqual(t, err, nil)
+	ptag.Header = nil
+	assertEqual(t, ptag, tag)
+}
+
+func TestMultitrack(t *testing.T) {
+	tag := Tag{
+		Type:           TAG_VIDEO,
+		IsExHeader:     true,
+		FrameType:      FRAME_KEY,
+		IsMultitrack:   true,
+		MultitrackType: MULTITRACK_MANY_TRACKS,
+		PacketType:     PKTTYPE_CODED_FRAMES,
+		FourCC:         FOURCC_AVC1,
+	}
+	tracks := []Track{
+		{FourCC: FOURCC_AVC1, TrackId: 0, CTime: 40, Data: []byte{1, 2}},
+		{FourCC: FOURCC_AVC1, TrackId: 1, Data: []byte{3}},
+	}
+	tag.FillTracks(tracks)
+	assertEqual(t, tag.Data, []byte{
+		0, 0, 0, 5, 0, 0, 0x28, 1, 2,
+		1, 0, 0, 4, 0, 0, 0, 3,
+	})
+
+	b := make([]byte, tag.MaxHeaderLen())
+	n := tag.FillHeader(b)
+	assertEqual(t, b[:n], []byte{0x96, 0x11, 'a', 'v', 'c', '1'})
+
+	ptag := Tag{Type: TAG_VIDEO}
+	_, err := ptag.ParseHeader(b[:n])
+	assertEqual(t, err, nil)
+	ptag.Data = tag.Data
+	ptracks, err := ptag.ParseTracks()
+	assertEqual(t, err, nil)
+	assertEqual(t, ptracks, tracks)
+}
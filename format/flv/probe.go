@@ -0,0 +1,297 @@
+package flv
+
+import (
+	"sort"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/aac"
+	"github.com/nareix/joy5/codec/av1"
+	"github.com/nareix/joy5/codec/h264"
+	"github.com/nareix/joy5/codec/h265"
+	"github.com/nareix/joy5/codec/opus"
+	"github.com/nareix/joy5/codec/vp9"
+	"github.com/nareix/joy5/format/flv/flvio"
+)
+
+const (
+	DefaultProbePackets  = 256
+	DefaultProbeDuration = time.Second * 3
+	DefaultProbeTimeout  = time.Second
+)
+
+// PacketReader reads packets of all tracks and keeps codec state per track:
+// the codec pointers of av.Packet are filled and Streams() probes the first
+// packets until sequence headers of the expected streams are seen.
+//
+// ProbeDuration is of media time, ProbeTimeout of real time bounds probing
+// a live source that is slower than that.
+type PacketReader struct {
+	ReadTag func() (flvio.Tag, error)
+
+	ProbePackets  int
+	ProbeDuration time.Duration
+	ProbeTimeout  time.Duration
+
+	expectVideo, expectAudio bool
+
+	probed   bool
+	probeErr error
+	pending  []av.Packet
+	streams  []av.Stream
+	codecs   map[trackKey]*trackCodecs
+
+	// a read still blocked when probing timed out
+	reading *tagsRead
+}
+
+type tagsRead struct {
+	done chan struct{}
+	pkts []av.Packet
+	err  error
+}
+
+func (r *PacketReader) readAsync() *tagsRead {
+	tr := &tagsRead{done: make(chan struct{})}
+	go func() {
+		tr.pkts, tr.err = ReadPackets(r.ReadTag)
+		close(tr.done)
+	}()
+	return tr
+}
+
+type trackKey struct {
+	video bool
+	idx   int
+}
+
+type trackCodecs struct {
+	aac  *aac.Codec
+	h264 *h264.Codec
+	h265 *h265.Codec
+	av1  *av1.Codec
+	vp9  *vp9.Codec
+	opus *opus.Codec
+}
+
+func NewPacketReader(readTag func() (flvio.Tag, error)) *PacketReader {
+	return &PacketReader{
+		ReadTag:       readTag,
+		ProbePackets:  DefaultProbePackets,
+		ProbeDuration: DefaultProbeDuration,
+		ProbeTimeout:  DefaultProbeTimeout,
+		expectVideo:   true,
+		expectAudio:   true,
+		codecs:        map[trackKey]*trackCodecs{},
+	}
+}
+
+// SetHint tells which streams to wait for, from the FLV file header or
+// onMetaData.
+func (r *PacketReader) SetHint(hasVideo, hasAudio bool) {
+	r.expectVideo = hasVideo
+	r.expectAudio = hasAudio
+}
+
+func (r *PacketReader) hasStream(video bool, idx int) bool {
+	for _, s := range r.streams {
+		if s.IsVideo() == video && (idx < 0 || s.Idx == idx) {
+			return true
+		}
+	}
+	return false
+}
+
+// setStream keeps streams ordered video first, then by Idx.
+func (r *PacketReader) setStream(idx int, c av.CodecData) {
+	for i, s := range r.streams {
+		if s.IsVideo() == c.IsVideo() && s.Idx == idx {
+			r.streams[i].CodecData = c
+			return
+		}
+	}
+	r.streams = append(r.streams, av.Stream{Idx: idx, CodecData: c})
+	sort.SliceStable(r.streams, func(i, j int) bool {
+		a, b := r.streams[i], r.streams[j]
+		if a.IsVideo() != b.IsVideo() {
+			return a.IsVideo()
+		}
+		return a.Idx < b.Idx
+	})
+}
+
+func (r *PacketReader) handleMetadata(data []byte) {
+	vals, err := flvio.ParseAMFVals(data, false)
+	if err != nil {
+		return
+	}
+	for _, v := range vals {
+		m, ok := v.(flvio.AMFMap)
+		if !ok {
+			continue
+		}
+		_, hasVideo := m.GetV("videocodecid")
+		_, hasAudio := m.GetV("audiocodecid")
+		if hasVideo || hasAudio {
+			r.SetHint(hasVideo, hasAudio)
+		}
+		return
+	}
+}
+
+func (r *PacketReader) handle(pkt *av.Packet) {
+	if pkt.Type == av.Metadata {
+		if !r.probed {
+			r.handleMetadata(pkt.Data)
+		}
+		return
+	}
+
+	video := av.IsVideo(pkt.Type)
+	key := trackKey{video: video, idx: pkt.Idx}
+	tc := r.codecs[key]
+	if tc == nil {
+		tc = &trackCodecs{}
+		r.codecs[key] = tc
+	}
+
+	if av.IsConfig(pkt.Type) {
+		if c, err := av.CodecDataFromConfig(*pkt); err == nil {
+			r.setStream(pkt.Idx, c)
+		}
+		switch pkt.Type {
+		case av.AACDecoderConfig:
+			tc.aac, _ = aac.FromMPEG4AudioConfigBytes(pkt.Data)
+		case av.H264DecoderConfig:
+			tc.h264, _ = h264.FromDecoderConfig(pkt.Data)
+		case av.H265DecoderConfig:
+			tc.h265, _ = h265.FromDecoderConfig(pkt.Data)
+		case av.AV1DecoderConfig:
+			tc.av1, _ = av1.FromDecoderConfig(pkt.Data)
+		case av.VP9DecoderConfig:
+			tc.vp9, _ = vp9.FromDecoderConfig(pkt.Data)
+		case av.OpusConfig:
+			tc.opus, _ = opus.FromDecoderConfig(pkt.Data)
+		}
+	} else if !r.hasStream(video, pkt.Idx) && !(pkt.Type == av.VP9 && !pkt.IsKeyFrame) {
+		if c, err := av.CodecDataFromFrame(*pkt); err == nil {
+			r.setStream(pkt.Idx, c)
+		}
+	}
+
+	pkt.AAC = tc.aac
+	pkt.H264 = tc.h264
+	pkt.H265 = tc.h265
+	pkt.AV1 = tc.av1
+	pkt.VP9 = tc.vp9
+	pkt.Opus = tc.opus
+}
+
+func (r *PacketReader) readPackets() (pkts []av.Packet, err error) {
+	if tr := r.reading; tr != nil {
+		<-tr.done
+		r.reading = nil
+		pkts, err = tr.pkts, tr.err
+	} else {
+		pkts, err = ReadPackets(r.ReadTag)
+	}
+	if err != nil {
+		return
+	}
+	for i := range pkts {
+		r.handle(&pkts[i])
+	}
+	return
+}
+
+func (r *PacketReader) probeDone(n int, dur time.Duration, begin time.Time) bool {
+	if n >= r.ProbePackets || dur >= r.ProbeDuration {
+		return true
+	}
+	if r.ProbeTimeout > 0 && time.Since(begin) >= r.ProbeTimeout {
+		return true
+	}
+	if r.expectVideo && !r.hasStream(true, -1) {
+		return false
+	}
+	if r.expectAudio && !r.hasStream(false, -1) {
+		return false
+	}
+	return true
+}
+
+// probe reads in the background so that a source blocking in ReadTag can't
+// hold it past ProbeTimeout. The read left running then goes to the next
+// ReadPacket.
+func (r *PacketReader) probe() {
+	var start time.Duration
+	var timeout <-chan time.Time
+	if r.ProbeTimeout > 0 {
+		t := time.NewTimer(r.ProbeTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	begin := time.Now()
+
+	for i := 0; ; i++ {
+		var dur time.Duration
+		if len(r.pending) > 0 {
+			dur = r.pending[len(r.pending)-1].Time - start
+		}
+		if r.probeDone(i, dur, begin) {
+			break
+		}
+
+		r.reading = r.readAsync()
+		select {
+		case <-timeout:
+			r.probed = true
+			return
+		case <-r.reading.done:
+		}
+
+		pkts, err := r.readPackets()
+		if err != nil {
+			r.probeErr = err
+			break
+		}
+		if len(r.pending) == 0 {
+			start = pkts[0].Time
+		}
+		r.pending = append(r.pending, pkts...)
+	}
+	r.probed = true
+}
+
+// Streams returns the streams found in the first packets. Packets read
+// while probing are returned by ReadPacket afterwards.
+func (r *PacketReader) Streams() (streams []av.Stream, err error) {
+	if !r.probed {
+		r.probe()
+	}
+	if len(r.streams) == 0 && r.probeErr != nil {
+		err = r.probeErr
+		return
+	}
+	streams = r.streams
+	return
+}
+
+func (r *PacketReader) ReadPacket() (pkt av.Packet, err error) {
+	if len(r.pending) > 0 {
+		pkt = r.pending[0]
+		r.pending = r.pending[1:]
+		return
+	}
+	if r.probeErr != nil {
+		err = r.probeErr
+		return
+	}
+	var pkts []av.Packet
+	if pkts, err = r.readPackets(); err != nil {
+		return
+	}
+	pkt = pkts[0]
+	r.pending = pkts[1:]
+	return
+}
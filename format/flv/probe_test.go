@@ -0,0 +1,92 @@
+package flv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/format/flv/flvio"
+)
+
+func TestProbeTimeout(t *testing.T) {
+	// a live audio only source without metadata, video never comes
+	n := 0
+	r := NewPacketReader(func() (tag flvio.Tag, err error) {
+		time.Sleep(time.Millisecond * 20)
+		tag = flvio.Tag{
+			Type:          flvio.TAG_AUDIO,
+			SoundFormat:   flvio.SOUND_AAC,
+			AACPacketType: flvio.AAC_RAW,
+			Time:          uint32(n),
+			Data:          []byte{1},
+		}
+		if n == 0 {
+			tag.AACPacketType = flvio.AAC_SEQHDR
+			tag.Data = []byte{0x12, 0x10}
+		}
+		n++
+		return
+	})
+	r.ProbeTimeout = time.Millisecond * 200
+
+	begin := time.Now()
+	streams, err := r.Streams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(begin); d > time.Second {
+		t.Fatalf("probe took %v", d)
+	}
+	if len(streams) != 1 || streams[0].IsVideo() {
+		t.Fatalf("streams %v", streams)
+	}
+}
+
+func TestProbeTimeoutBlocking(t *testing.T) {
+	// the source sends an audio header then blocks until released
+	release := make(chan struct{})
+	n := 0
+	r := NewPacketReader(func() (tag flvio.Tag, err error) {
+		if n > 0 {
+			<-release
+		}
+		tag = flvio.Tag{
+			Type:          flvio.TAG_AUDIO,
+			SoundFormat:   flvio.SOUND_AAC,
+			AACPacketType: flvio.AAC_RAW,
+			Time:          uint32(n * 23),
+			Data:          []byte{1},
+		}
+		if n == 0 {
+			tag.AACPacketType = flvio.AAC_SEQHDR
+			tag.Data = []byte{0x12, 0x10}
+		}
+		n++
+		return
+	})
+	r.ProbeTimeout = time.Millisecond * 100
+
+	begin := time.Now()
+	streams, err := r.Streams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(begin); d > time.Second {
+		t.Fatalf("probe took %v", d)
+	}
+	if len(streams) != 1 || streams[0].IsVideo() {
+		t.Fatalf("streams %v", streams)
+	}
+
+	close(release)
+	// the probed header, then the frame of the read left blocked
+	for i, want := range []int{av.AACDecoderConfig, av.AAC} {
+		pkt, err := r.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkt.Type != want || pkt.Time != time.Duration(i)*23*time.Millisecond || pkt.AAC == nil {
+			t.Fatalf("packet %d %v", i, pkt.String())
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package fmp4
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/aac"
+	"github.com/nareix/joy5/codec/h264"
+	"github.com/nareix/joy5/format/mp4/mp4io"
+	"github.com/nareix/joy5/utils/bits/pio"
+)
+
+const DefaultFragmentDuration = time.Second
+
+const videoTimeScale = 90000
+
+type sample struct {
+	dts  int64
+	dur  uint32
+	cto  int32
+	sync bool
+	data []byte
+}
+
+type track struct {
+	id        uint32
+	video     bool
+	idx       int
+	timescale int64
+	cfg       []byte
+	h264      *h264.Codec
+	aac       *aac.Codec
+
+	started bool
+	samples []sample
+	pending *sample
+	lastdur uint32
+}
+
+func (t *track) ts(d time.Duration) int64 {
+	if d < 0 {
+		d = 0
+	}
+	return int64(d/time.Second)*t.timescale + int64(d%time.Second)*t.timescale/int64(time.Second)
+}
+
+// complete sets the duration of the pending sample, dts is the next sample.
+func (t *track) complete(dts int64) {
+	if t.pending == nil {
+		return
+	}
+	if dur := dts - t.pending.dts; dur > 0 {
+		t.lastdur = uint32(dur)
+	}
+	t.pending.dur = t.lastdur
+	t.samples = append(t.samples, *t.pending)
+	t.pending = nil
+}
+
+// Muxer writes an init segment when the first frame comes, from the configs
+// seen before it, then a moof+mdat fragment for every keyframe at least
+// FragmentDuration after the start of the fragment. Without video, fragments
+// are cut every FragmentDuration.
+//
+// Tracks without a config before the first frame or in SetStreams are
+// dropped, a config of a new track after that is ignored. Configs can not
+// change after the init segment.
+type Muxer struct {
+	W                io.Writer
+	FragmentDuration time.Duration
+
+	tracks      []*track
+	inited      bool
+	hasVideo    bool
+	seq         uint32
+	fragstarted bool
+	fragstart   time.Duration
+}
+
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{
+		W:                w,
+		FragmentDuration: DefaultFragmentDuration,
+	}
+}
+
+func (m *Muxer) track(video bool, idx int) *track {
+	for _, t := range m.tracks {
+		if t.video == video && t.idx == idx {
+			return t
+		}
+	}
+	return nil
+}
+
+func (m *Muxer) setConfig(pkt av.Packet) (err error) {
+	video := av.IsVideo(pkt.Type)
+	t := m.track(video, pkt.Idx)
+	if m.inited {
+		if t != nil && !av.ConfigEqual(pkt.Type, t.cfg, pkt.Data) {
+			err = fmt.Errorf("fmp4: %s changed after init segment", av.PacketTypeString[pkt.Type])
+		}
+		return
+	}
+
+	if t == nil {
+		t = &track{video: video, idx: pkt.Idx}
+		m.tracks = append(m.tracks, t)
+	}
+	t.cfg = pkt.Data
+	if video {
+		if t.h264, err = h264.FromDecoderConfig(pkt.Data); err != nil {
+			return
+		}
+		t.timescale = videoTimeScale
+	} else {
+		if t.aac, err = aac.FromMPEG4AudioConfigBytes(pkt.Data); err != nil {
+			return
+		}
+		t.timescale = int64(t.aac.Config.SampleRate)
+	}
+	return
+}
+
+// SetStreams adds the tracks of the streams from their configs, so that a
+// track with its config after the first frame is not dropped.
+func (m *Muxer) SetStreams(streams []av.Stream) {
+	for _, s := range streams {
+		switch s.Type {
+		case av.H264, av.AAC:
+			m.setConfig(av.Packet{Type: av.ConfigType[s.Type], Idx: s.Idx, Data: s.ConfigBytes})
+		}
+	}
+}
+
+func ftyp() []byte {
+	return mp4io.Box("ftyp", []byte("iso6"), make([]byte, 4), []byte("iso6cmfcmp41"))
+}
+
+func (m *Muxer) trak(t *track) []byte {
+	var w, h int
+	var volume uint16
+	var handler, name string
+	var mhd, entry []byte
+
+	if t.video {
+		w, h = t.h264.W, t.h264.H
+		handler, name = "vide", "VideoHandler"
+		mhd = mp4io.FullBox("vmhd", 0, 1, make([]byte, 8))
+		entry = mp4io.Box("avc1", mp4io.Fields(func(b []byte, n *int) {
+			pio.WriteBytes(b, n, make([]byte, 6))
+			pio.WriteU16BE(b, n, 1) // data_reference_index
+			pio.WriteBytes(b, n, make([]byte, 16))
+			pio.WriteU16BE(b, n, uint16(w))
+			pio.WriteU16BE(b, n, uint16(h))
+			pio.WriteU32BE(b, n, 0x00480000) // 72 dpi
+			pio.WriteU32BE(b, n, 0x00480000)
+			pio.WriteU32BE(b, n, 0)
+			pio.WriteU16BE(b, n, 1) // frame_count
+			pio.WriteBytes(b, n, make([]byte, 32))
+			pio.WriteU16BE(b, n, 0x18) // depth
+			pio.WriteU16BE(b, n, 0xffff)
+		}), mp4io.Box("avcC", mp4io.Fields(t.h264.ToConfig)))
+	} else {
+		config := t.aac.Config
+		volume = 0x100
+		handler, name = "soun", "SoundHandler"
+		mhd = mp4io.FullBox("smhd", 0, 0, make([]byte, 4))
+		esds := mp4io.FullBox("esds", 0, 0, mp4io.Descriptor(mp4io.TAG_ES_DESCRIPTOR,
+			[]byte{0, 0, 0}, // ES_ID, flags
+			mp4io.Descriptor(mp4io.TAG_DECODER_CONFIG,
+				[]byte{0x40, 0x15},  // objectTypeIndication AAC, streamType audio
+				make([]byte, 3+4+4), // bufferSizeDB, maxBitrate, avgBitrate
+				mp4io.Descriptor(mp4io.TAG_DECODER_SPECIFIC, t.aac.ConfigBytes),
+			),
+			mp4io.Descriptor(mp4io.TAG_SL_CONFIG, []byte{0x02}),
+		))
+		entry = mp4io.Box("mp4a", mp4io.Fields(func(b []byte, n *int) {
+			pio.WriteBytes(b, n, make([]byte, 6))
+			pio.WriteU16BE(b, n, 1) // data_reference_index
+			pio.WriteBytes(b, n, make([]byte, 8))
+			pio.WriteU16BE(b, n, uint16(config.ChannelLayout.Count()))
+			pio.WriteU16BE(b, n, 16) // samplesize
+			pio.WriteU32BE(b, n, 0)
+			pio.WriteU32BE(b, n, uint32(config.SampleRate)<<16)
+		}), esds)
+	}
+
+	tkhd := mp4io.FullBox("tkhd", 0, 3, mp4io.Fields(func(b []byte, n *int) {
+		pio.WriteU32BE(b, n, 0) // creation_time
+		pio.WriteU32BE(b, n, 0) // modification_time
+		pio.WriteU32BE(b, n, t.id)
+		pio.WriteU32BE(b, n, 0)
+		pio.WriteU32BE(b, n, 0) // duration
+		pio.WriteBytes(b, n, make([]byte, 8))
+		pio.WriteU16BE(b, n, 0) // layer
+		pio.WriteU16BE(b, n, 0) // alternate_group
+		pio.WriteU16BE(b, n, volume)
+		pio.WriteU16BE(b, n, 0)
+		mp4io.WriteMatrix(b, n)
+		pio.WriteU32BE(b, n, uint32(w)<<16)
+		pio.WriteU32BE(b, n, uint32(h)<<16)
+	}))
+	mdhd := mp4io.FullBox("mdhd", 0, 0, mp4io.Fields(func(b []byte, n *int) {
+		pio.WriteU32BE(b, n, 0)
+		pio.WriteU32BE(b, n, 0)
+		pio.WriteU32BE(b, n, uint32(t.timescale))
+		pio.WriteU32BE(b, n, 0)      // duration
+		pio.WriteU16BE(b, n, 0x55c4) // und
+		pio.WriteU16BE(b, n, 0)
+	}))
+	hdlr := mp4io.FullBox("hdlr", 0, 0, make([]byte, 4), []byte(handler), make([]byte, 12), []byte(name+"\x00"))
+	dinf := mp4io.Box("dinf", mp4io.FullBox("dref", 0, 0, []byte{0, 0, 0, 1}, mp4io.FullBox("url ", 0, 1)))
+	stbl := mp4io.Box("stbl",
+		mp4io.FullBox("stsd", 0, 0, []byte{0, 0, 0, 1}, entry),
+		mp4io.FullBox("stts", 0, 0, make([]byte, 4)),
+		mp4io.FullBox("stsc", 0, 0, make([]byte, 4)),
+		mp4io.FullBox("stsz", 0, 0, make([]byte, 8)),
+		mp4io.FullBox("stco", 0, 0, make([]byte, 4)),
+	)
+	return mp4io.Box("trak", tkhd, mp4io.Box("mdia", mdhd, hdlr, mp4io.Box("minf", mhd, dinf, stbl)))
+}
+
+func (m *Muxer) writeInit() (err error) {
+	mvhd := mp4io.FullBox("mvhd", 0, 0, mp4io.Fields(func(b []byte, n *int) {
+		pio.WriteU32BE(b, n, 0)
+		pio.WriteU32BE(b, n, 0)
+		pio.WriteU32BE(b, n, 1000) // timescale
+		pio.WriteU32BE(b, n, 0)    // duration
+		pio.WriteU32BE(b, n, 0x10000)
+		pio.WriteU16BE(b, n, 0x100)
+		pio.WriteBytes(b, n, make([]byte, 10))
+		mp4io.WriteMatrix(b, n)
+		pio.WriteBytes(b, n, make([]byte, 24))
+		pio.WriteU32BE(b, n, uint32(len(m.tracks)+1)) // next_track_ID
+	}))
+
+	moov := [][]byte{mvhd}
+	trexs := [][]byte{}
+	for _, t := range m.tracks {
+		moov = append(moov, m.trak(t))
+		trexs = append(trexs, mp4io.FullBox("trex", 0, 0, mp4io.Fields(func(b []byte, n *int) {
+			pio.WriteU32BE(b, n, t.id)
+			pio.WriteU32BE(b, n, 1) // default_sample_description_index
+			pio.WriteU32BE(b, n, 0)
+			pio.WriteU32BE(b, n, 0)
+			pio.WriteU32BE(b, n, 0)
+		})))
+	}
+	moov = append(moov, mp4io.Box("mvex", trexs...))
+
+	_, err = m.W.Write(append(ftyp(), mp4io.Box("moov", moov...)...))
+	return
+}
+
+func (m *Muxer) init() (err error) {
+	// video first
+	tracks := []*track{}
+	for _, video := range []bool{true, false} {
+		for _, t := range m.tracks {
+			if t.video == video {
+				tracks = append(tracks, t)
+			}
+		}
+	}
+	m.tracks = tracks
+	for i, t := range m.tracks {
+		t.id = uint32(i + 1)
+		if t.video {
+			m.hasVideo = true
+		}
+	}
+	m.inited = true
+	return m.writeInit()
+}
+
+func (m *Muxer) moof(offset int) []byte {
+	trafs := [][]byte{mp4io.FullBox("mfhd", 0, 0, mp4io.Fields(func(b []byte, n *int) {
+		pio.WriteU32BE(b, n, m.seq)
+	}))}
+	for _, t := range m.tracks {
+		if len(t.samples) == 0 {
+			continue
+		}
+		tfhd := mp4io.FullBox("tfhd", 0, mp4io.TFHD_DEFAULT_BASE_IS_MOOF, mp4io.Fields(func(b []byte, n *int) {
+			pio.WriteU32BE(b, n, t.id)
+		}))
+		tfdt := mp4io.FullBox("tfdt", 1, 0, mp4io.Fields(func(b []byte, n *int) {
+			pio.WriteU64BE(b, n, uint64(t.samples[0].dts))
+		}))
+		flags := uint32(mp4io.TRUN_DATA_OFFSET | mp4io.TRUN_SAMPLE_DURATION | mp4io.TRUN_SAMPLE_SIZE | mp4io.TRUN_SAMPLE_FLAGS | mp4io.TRUN_SAMPLE_CTS_OFFSET)
+		trun := mp4io.FullBox("trun", 1, flags, mp4io.Fields(func(b []byte, n *int) {
+			pio.WriteU32BE(b, n, uint32(len(t.samples)))
+			pio.WriteI32BE(b, n, int32(offset))
+			for _, s := range t.samples {
+				pio.WriteU32BE(b, n, s.dur)
+				pio.WriteU32BE(b, n, uint32(len(s.data)))
+				if s.sync {
+					pio.WriteU32BE(b, n, mp4io.SAMPLE_FLAG_SYNC)
+				} else {
+					pio.WriteU32BE(b, n, mp4io.SAMPLE_FLAG_NON_SYNC)
+				}
+				pio.WriteI32BE(b, n, s.cto)
+			}
+		}))
+		trafs = append(trafs, mp4io.Box("traf", tfhd, tfdt, trun))
+		for _, s := range t.samples {
+			offset += len(s.data)
+		}
+	}
+	return mp4io.Box("moof", trafs...)
+}
+
+func (m *Muxer) writeFragment() (err error) {
+	datas := [][]byte{}
+	for _, t := range m.tracks {
+		for _, s := range t.samples {
+			datas = append(datas, s.data)
+		}
+	}
+	if len(datas) == 0 {
+		return
+	}
+
+	m.seq++
+	// data offsets are from the start of moof, its size does not depend on
+	// them
+	moofsize := len(m.moof(0))
+	moof := m.moof(moofsize + mp4io.BoxHeaderLength)
+	mdat := mp4io.Box("mdat", datas...)
+	for _, t := range m.tracks {
+		t.samples = nil
+	}
+
+	_, err = m.W.Write(append(moof, mdat...))
+	return
+}
+
+func (m *Muxer) WritePacket(pkt av.Packet) (err error) {
+	switch pkt.Type {
+	case av.Metadata:
+		return
+	case av.H264DecoderConfig, av.AACDecoderConfig:
+		return m.setConfig(pkt)
+	case av.H264, av.AAC:
+	default:
+		err = fmt.Errorf("fmp4: can not write %s", av.PacketTypeString[pkt.Type])
+		return
+	}
+
+	video := av.IsVideo(pkt.Type)
+	t := m.track(video, pkt.Idx)
+	if t == nil {
+		return
+	}
+	if !m.inited {
+		if err = m.init(); err != nil {
+			return
+		}
+	}
+	if !t.started {
+		// fragments start with a keyframe
+		if video && !pkt.IsKeyFrame || !video && m.hasVideo && !m.fragstarted {
+			return
+		}
+		t.started = true
+	}
+
+	dts := t.ts(pkt.Time)
+	t.complete(dts)
+
+	cut := !m.hasVideo || video && pkt.IsKeyFrame
+	if cut && m.fragstarted && pkt.Time-m.fragstart >= m.FragmentDuration {
+		if err = m.writeFragment(); err != nil {
+			return
+		}
+		m.fragstarted = false
+	}
+	if !m.fragstarted {
+		m.fragstarted = true
+		m.fragstart = pkt.Time
+	}
+
+	t.pending = &sample{
+		dts:  dts,
+		cto:  int32(t.ts(pkt.Time+pkt.CTime) - dts),
+		sync: !video || pkt.IsKeyFrame,
+		data: pkt.Data,
+	}
+	return
+}
+
+// Flush writes the last fragment, the last sample of each track gets the
+// duration of the one before it.
+func (m *Muxer) Flush() (err error) {
+	for _, t := range m.tracks {
+		if t.pending == nil {
+			continue
+		}
+		if t.lastdur == 0 && t.aac != nil {
+			t.lastdur = uint32(t.aac.Config.FrameSamples())
+		}
+		t.complete(t.pending.dts)
+	}
+	return m.writeFragment()
+}
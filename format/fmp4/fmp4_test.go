@@ -0,0 +1,221 @@
+package fmp4
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/h264"
+	"github.com/nareix/joy5/format/mp4/mp4io"
+	"github.com/nareix/joy5/utils/bits/pio"
+)
+
+var (
+	testSPS = []byte{0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x03, 0xc0, 0xf1, 0x83, 0x19, 0x60}
+	testPPS = []byte{0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0}
+)
+
+type box struct {
+	typ  string
+	data []byte
+}
+
+func boxes(b []byte) (out []box) {
+	for len(b) >= 8 {
+		size := int(pio.U32BE(b))
+		out = append(out, box{string(b[4:8]), b[8:size]})
+		b = b[size:]
+	}
+	return
+}
+
+func TestFragments(t *testing.T) {
+	buf := &bytes.Buffer{}
+	m := NewMuxer(buf)
+	m.FragmentDuration = time.Millisecond * 500
+
+	// 1 channel 44100Hz AAC-LC
+	if err := m.WritePacket(av.Packet{Type: av.AACDecoderConfig, Data: []byte{0x12, 0x08}}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		pkt := av.Packet{Type: av.AAC, Time: time.Duration(i) * time.Millisecond * 100, Data: []byte{byte(i)}}
+		if err := m.WritePacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	top := boxes(buf.Bytes())
+	types := ""
+	for _, b := range top {
+		types += b.typ + " "
+	}
+	if types != "ftyp moov moof mdat moof mdat moof mdat moof mdat " {
+		t.Fatalf("boxes %s", types)
+	}
+
+	next := 0
+	for i := 2; i < len(top); i += 2 {
+		traf := boxes(boxes(top[i].data)[1].data)
+		tfdt, trun := traf[1].data, traf[2].data
+		if dts := pio.U64BE(tfdt[4:]); dts != uint64(next*4410) {
+			t.Fatalf("tfdt %d", dts)
+		}
+		count := int(pio.U32BE(trun[4:]))
+		dataoff := int(pio.I32BE(trun[8:]))
+		mdat := top[i+1].data
+		if dataoff != len(top[i].data)+16 || count != len(mdat) {
+			t.Fatalf("trun count %d offset %d", count, dataoff)
+		}
+		for j := 0; j < count; j++ {
+			if dur := pio.U32BE(trun[12+j*16:]); dur != 4410 {
+				t.Fatalf("duration %d", dur)
+			}
+			if mdat[j] != byte(next+j) {
+				t.Fatalf("sample %d", mdat[j])
+			}
+		}
+		next += count
+	}
+	if next != 20 {
+		t.Fatalf("samples %d", next)
+	}
+}
+
+func TestVideoFragments(t *testing.T) {
+	buf := &bytes.Buffer{}
+	m := NewMuxer(buf)
+	m.FragmentDuration = time.Millisecond * 150
+
+	c := h264.NewCodec()
+	c.AddSPSPPS(testSPS)
+	c.AddSPSPPS(testPPS)
+	cfg := mp4io.Fields(c.ToConfig)
+	if err := m.WritePacket(av.Packet{Type: av.H264DecoderConfig, Data: cfg}); err != nil {
+		t.Fatal(err)
+	}
+
+	// I P B B per 4 frames at 25fps, keyframe every 8 frames, display order
+	// 0 3 1 2
+	ctimes := []time.Duration{40, 120, 0, 0}
+	for i := 0; i < 16; i++ {
+		if i == 1 {
+			// a new track after the init segment is dropped
+			if err := m.WritePacket(av.Packet{Type: av.AACDecoderConfig, Data: []byte{0x12, 0x08}}); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.WritePacket(av.Packet{Type: av.AAC, Data: []byte{0xff}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		pkt := av.Packet{
+			Type:       av.H264,
+			IsKeyFrame: i%8 == 0,
+			Time:       time.Duration(i) * time.Millisecond * 40,
+			CTime:      ctimes[i%4] * time.Millisecond,
+			Data:       []byte{byte(i)},
+		}
+		if err := m.WritePacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.WritePacket(av.Packet{Type: av.H264DecoderConfig, Data: cfg}); err != nil {
+		t.Fatalf("same config: %v", err)
+	}
+	changed := append([]byte(nil), cfg...)
+	changed[11] = 0x28 // level_idc in the SPS
+	if err := m.WritePacket(av.Packet{Type: av.H264DecoderConfig, Data: changed}); err == nil {
+		t.Fatal("changed config after init segment")
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	top := boxes(buf.Bytes())
+	if len(top) != 6 {
+		t.Fatalf("%d boxes", len(top))
+	}
+	if traks := mp4io.FindAll(top[1].data, "trak"); len(traks) != 1 {
+		t.Fatalf("%d traks", len(traks))
+	}
+
+	// cut at each keyframe
+	next := 0
+	for i := 2; i < len(top); i += 2 {
+		trafs := boxes(top[i].data)[1:]
+		if len(trafs) != 1 {
+			t.Fatalf("%d trafs", len(trafs))
+		}
+		traf := boxes(trafs[0].data)
+		tfdt, trun := traf[1].data, traf[2].data
+		if dts := pio.U64BE(tfdt[4:]); dts != uint64(next*3600) {
+			t.Fatalf("tfdt %d", dts)
+		}
+		count := int(pio.U32BE(trun[4:]))
+		if count != 8 {
+			t.Fatalf("trun count %d", count)
+		}
+		for j := 0; j < count; j++ {
+			entry := trun[12+j*16:]
+			dur, flags, cto := pio.U32BE(entry), pio.U32BE(entry[8:]), pio.I32BE(entry[12:])
+			if dur != 3600 || cto != int32(ctimes[j%4])*90 {
+				t.Fatalf("sample %d duration %d cto %d", next+j, dur, cto)
+			}
+			want := uint32(mp4io.SAMPLE_FLAG_NON_SYNC)
+			if j == 0 {
+				want = mp4io.SAMPLE_FLAG_SYNC
+			}
+			if flags != want {
+				t.Fatalf("sample %d flags %x", next+j, flags)
+			}
+		}
+		next += count
+	}
+}
+
+func TestSetStreams(t *testing.T) {
+	buf := &bytes.Buffer{}
+	m := NewMuxer(buf)
+
+	c := h264.NewCodec()
+	c.AddSPSPPS(testSPS)
+	c.AddSPSPPS(testPPS)
+	cfg := mp4io.Fields(c.ToConfig)
+	acfg := []byte{0x12, 0x08}
+	m.SetStreams([]av.Stream{
+		{CodecData: av.CodecData{Type: av.H264, ConfigBytes: cfg}},
+		{CodecData: av.CodecData{Type: av.AAC, ConfigBytes: acfg}},
+	})
+
+	// the audio config comes after the first frame
+	pkts := []av.Packet{
+		{Type: av.H264DecoderConfig, Data: cfg},
+		{Type: av.H264, IsKeyFrame: true, Data: []byte{0}},
+		{Type: av.AACDecoderConfig, Data: acfg},
+		{Type: av.AAC, Time: time.Millisecond * 10, Data: []byte{1}},
+		{Type: av.H264, Time: time.Millisecond * 40, Data: []byte{2}},
+	}
+	for _, pkt := range pkts {
+		if err := m.WritePacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	top := boxes(buf.Bytes())
+	if len(top) != 4 {
+		t.Fatalf("%d boxes", len(top))
+	}
+	if traks := mp4io.FindAll(top[1].data, "trak"); len(traks) != 2 {
+		t.Fatalf("%d traks", len(traks))
+	}
+	if trafs := boxes(top[2].data)[1:]; len(trafs) != 2 {
+		t.Fatalf("%d trafs", len(trafs))
+	}
+}
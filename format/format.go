@@ -13,6 +13,10 @@ import (
 	"time"
 
 	"github.com/nareix/joy5/format/flv"
+	"github.com/nareix/joy5/format/fmp4"
+	"github.com/nareix/joy5/format/hls"
+	"github.com/nareix/joy5/format/mp4"
+	"github.com/nareix/joy5/format/ts"
 
 	"github.com/nareix/joy5/av"
 	"github.com/nareix/joy5/format/rtmp"
@@ -24,24 +28,73 @@ func (c dummyCloser) Close() error {
 	return nil
 }
 
+// flushCloser flushes the muxer before closing the file.
+type flushCloser struct {
+	flush func() error
+	io.Closer
+}
+
+func (c flushCloser) Close() (err error) {
+	if err = c.flush(); err != nil {
+		c.Closer.Close()
+		return
+	}
+	return c.Closer.Close()
+}
+
 type Reader struct {
 	av.PacketReader
 	io.Closer
 	NetConn  net.Conn
 	Rtmp     *rtmp.Conn
 	Flv      *flv.Demuxer
+	Ts       *ts.Demuxer
+	Mp4      *mp4.Demuxer
 	IsRemote bool
 }
 
+// Streams probes the streams of readers that support it.
+func (r *Reader) Streams() (streams []av.Stream, err error) {
+	sr, ok := r.PacketReader.(av.StreamsReader)
+	if !ok {
+		err = fmt.Errorf("streams not supported")
+		return
+	}
+	return sr.Streams()
+}
+
 type Writer struct {
 	av.PacketWriter
 	io.Closer
 	NetConn  net.Conn
 	Rtmp     *rtmp.Conn
 	Flv      *flv.Muxer
+	Ts       *ts.Muxer
+	Hls      *hls.Writer
+	Fmp4     *fmp4.Muxer
 	IsRemote bool
 }
 
+// SetStreams gives the streams to the muxers that use them before the first
+// packet.
+func (w *Writer) SetStreams(streams []av.Stream) {
+	if streams == nil {
+		return
+	}
+	if w.Flv != nil {
+		w.Flv.SetStreams(streams)
+	}
+	if w.Ts != nil {
+		w.Ts.SetStreams(streams)
+	}
+	if w.Hls != nil {
+		w.Hls.SetStreams(streams)
+	}
+	if w.Fmp4 != nil {
+		w.Fmp4.SetStreams(streams)
+	}
+}
+
 func ErrUnsupported(url_ string) error {
 	return fmt.Errorf("open `%s` failed: %s", url_, "unsupported format")
 }
@@ -55,6 +108,7 @@ type URLOpener struct {
 	OnNewRtmpClient func(c *rtmp.Client)
 	OnNewFlvDemuxer func(r *flv.Demuxer)
 	OnNewFlvMuxer   func(w *flv.Muxer)
+	OnNewHlsWriter  func(w *hls.Writer)
 }
 
 func (o *URLOpener) StartRtmpServerWaitConn(u *url.URL) (c *rtmp.Conn, nc net.Conn, err error) {
@@ -168,12 +222,53 @@ func (o *URLOpener) Create(url_ string) (w *Writer, err error) {
 				fn(c)
 			}
 			w = &Writer{
-				PacketWriter: flv.NewMuxer(f),
+				PacketWriter: c,
 				Closer:       f,
 				Flv:          c,
 			}
 			return
 
+		case ".ts":
+			var f *os.File
+			if f, err = os.Create(u.Path); err != nil {
+				return
+			}
+			c := ts.NewMuxer(f)
+			w = &Writer{
+				PacketWriter: c,
+				Closer:       f,
+				Ts:           c,
+			}
+			return
+
+		case ".mp4":
+			var f *os.File
+			if f, err = os.Create(u.Path); err != nil {
+				return
+			}
+			c := fmp4.NewMuxer(f)
+			w = &Writer{
+				PacketWriter: c,
+				Closer:       flushCloser{c.Flush, f},
+				Fmp4:         c,
+			}
+			return
+
+		case ".m3u8":
+			if err = os.MkdirAll(path.Dir(u.Path), 0755); err != nil {
+				return
+			}
+			c := hls.NewWriter(u.Path)
+			if fn := o.OnNewHlsWriter; fn != nil {
+				fn(c)
+			}
+			w = &Writer{
+				PacketWriter: c,
+				Closer:       c,
+				Hls:          c,
+			}
+			return
+
 		default:
 			err = ErrUnsupported(url_)
 			return
@@ -250,6 +345,20 @@ func (o *URLOpener) Open(url_ string) (r *Reader, err error) {
 			}
 			return
 
+		case ".ts":
+			var hr *http.Response
+			if hr, err = http.Get(url_); err != nil {
+				return
+			}
+			c := ts.NewDemuxer(hr.Body)
+			r = &Reader{
+				PacketReader: c,
+				Closer:       hr.Body,
+				Ts:           c,
+				IsRemote:     true,
+			}
+			return
+
 		default:
 			err = ErrUnsupported(url_)
 			return
@@ -274,6 +383,32 @@ func (o *URLOpener) Open(url_ string) (r *Reader, err error) {
 			}
 			return
 
+		case ".ts":
+			var f *os.File
+			if f, err = os.Open(u.Path); err != nil {
+				return
+			}
+			c := ts.NewDemuxer(f)
+			r = &Reader{
+				PacketReader: c,
+				Closer:       f,
+				Ts:           c,
+			}
+			return
+
+		case ".mp4", ".m4a", ".mov":
+			var f *os.File
+			if f, err = os.Open(u.Path); err != nil {
+				return
+			}
+			c := mp4.NewDemuxer(f)
+			r = &Reader{
+				PacketReader: c,
+				Closer:       f,
+				Mp4:          c,
+			}
+			return
+
 		default:
 			err = ErrUnsupported(url_)
 			return
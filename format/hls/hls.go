@@ -0,0 +1,282 @@
+package hls
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/format/ts"
+)
+
+const (
+	DefaultTargetDuration = time.Second * 6
+	DefaultListSize       = 5
+)
+
+const (
+	PLAYLIST_LIVE = iota
+	PLAYLIST_EVENT
+	PLAYLIST_VOD
+)
+
+var PlaylistTypeString = map[int]string{
+	PLAYLIST_LIVE:  "LIVE",
+	PLAYLIST_EVENT: "EVENT",
+	PLAYLIST_VOD:   "VOD",
+}
+
+type Segment struct {
+	Name          string
+	Seq           int
+	Duration      time.Duration
+	Discontinuity bool
+}
+
+type cfgKey struct {
+	typ, idx int
+}
+
+type trackKey struct {
+	video bool
+	idx   int
+}
+
+// Writer cuts packets into TS segments next to the playlist. A segment is
+// cut at the first keyframe after TargetDuration, any frame when there is no
+// video. A codec change or time going backwards starts a new segment marked
+// with EXT-X-DISCONTINUITY at the next cut, the current segment already
+// gets the new config.
+//
+// A LIVE playlist keeps the last ListSize segments. Segments leaving the
+// playlist are deleted once another ListSize segments have been written, so
+// clients still loading an older playlist can get them. EVENT and VOD
+// playlists keep all segments and end with EXT-X-ENDLIST on Close, VOD is
+// only written on Close.
+type Writer struct {
+	Dir            string
+	Name           string // playlist file name
+	TargetDuration time.Duration
+	ListSize       int
+	Type           int
+
+	segs     []Segment
+	expired  []Segment
+	longest  time.Duration
+	seq      int
+	discseq  int
+	hasVideo bool
+
+	f        *os.File
+	m        *ts.Muxer
+	cur      Segment
+	start    time.Duration
+	last     time.Duration
+	lasts    map[trackKey]time.Duration
+	disc     bool
+	streams  []av.Stream
+	cfgs     map[cfgKey]av.Packet
+	cfgorder []cfgKey
+}
+
+// NewWriter writes the playlist to path, segments go to the same directory.
+func NewWriter(path string) *Writer {
+	return &Writer{
+		Dir:            filepath.Dir(path),
+		Name:           filepath.Base(path),
+		TargetDuration: DefaultTargetDuration,
+		ListSize:       DefaultListSize,
+		cfgs:           map[cfgKey]av.Packet{},
+		lasts:          map[trackKey]time.Duration{},
+	}
+}
+
+func (w *Writer) SetStreams(streams []av.Stream) {
+	w.streams = streams
+	for _, s := range streams {
+		if s.IsVideo() {
+			w.hasVideo = true
+		}
+	}
+}
+
+func (w *Writer) segmentName(seq int) string {
+	prefix := strings.TrimSuffix(w.Name, filepath.Ext(w.Name))
+	return fmt.Sprintf("%s%d.ts", prefix, seq)
+}
+
+func (w *Writer) openSegment(t time.Duration) (err error) {
+	seq := w.seq + len(w.segs)
+	name := w.segmentName(seq)
+	if w.f, err = os.Create(filepath.Join(w.Dir, name)); err != nil {
+		return
+	}
+	w.m = ts.NewMuxer(w.f)
+	w.m.SetStreams(w.streams)
+	w.cur = Segment{
+		Name:          name,
+		Seq:           seq,
+		Discontinuity: w.disc,
+	}
+	if w.disc {
+		w.lasts = map[trackKey]time.Duration{}
+	}
+	w.disc = false
+	w.start = t
+	w.last = t
+	for _, k := range w.cfgorder {
+		if err = w.m.WritePacket(w.cfgs[k]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (w *Writer) closeSegment(end time.Duration) (err error) {
+	if w.f == nil {
+		return
+	}
+	err = w.f.Close()
+	w.f, w.m = nil, nil
+	if err != nil {
+		return
+	}
+
+	w.cur.Duration = end - w.start
+	if w.cur.Duration > w.longest {
+		w.longest = w.cur.Duration
+	}
+	w.segs = append(w.segs, w.cur)
+	if w.Type == PLAYLIST_LIVE {
+		for len(w.segs) > w.ListSize {
+			if w.segs[0].Discontinuity {
+				w.discseq++
+			}
+			w.expired = append(w.expired, w.segs[0])
+			w.segs = w.segs[1:]
+			w.seq++
+		}
+		for len(w.expired) > w.ListSize {
+			os.Remove(filepath.Join(w.Dir, w.expired[0].Name))
+			w.expired = w.expired[1:]
+		}
+	}
+	if w.Type != PLAYLIST_VOD {
+		err = w.writePlaylist(false)
+	}
+	return
+}
+
+// targetDuration covers every segment written so far, it must not change
+// when the longest one leaves the playlist.
+func (w *Writer) targetDuration() int {
+	max := w.TargetDuration
+	if w.longest > max {
+		max = w.longest
+	}
+	return int(math.Ceil(max.Seconds()))
+}
+
+func (w *Writer) writePlaylist(end bool) (err error) {
+	b := &bytes.Buffer{}
+	fmt.Fprintf(b, "#EXTM3U\n")
+	fmt.Fprintf(b, "#EXT-X-VERSION:3\n")
+	fmt.Fprintf(b, "#EXT-X-TARGETDURATION:%d\n", w.targetDuration())
+	fmt.Fprintf(b, "#EXT-X-MEDIA-SEQUENCE:%d\n", w.seq)
+	if w.discseq > 0 {
+		fmt.Fprintf(b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", w.discseq)
+	}
+	if w.Type != PLAYLIST_LIVE {
+		fmt.Fprintf(b, "#EXT-X-PLAYLIST-TYPE:%s\n", PlaylistTypeString[w.Type])
+	}
+	for _, s := range w.segs {
+		if s.Discontinuity {
+			fmt.Fprintf(b, "#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(b, "#EXTINF:%.3f,\n", s.Duration.Seconds())
+		fmt.Fprintf(b, "%s\n", s.Name)
+	}
+	if end {
+		fmt.Fprintf(b, "#EXT-X-ENDLIST\n")
+	}
+
+	// readers never see a partly written playlist
+	path := filepath.Join(w.Dir, w.Name)
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, b.Bytes(), 0644); err != nil {
+		return
+	}
+	return os.Rename(tmp, path)
+}
+
+func (w *Writer) setConfig(pkt av.Packet) {
+	k := cfgKey{pkt.Type, pkt.Idx}
+	if last, ok := w.cfgs[k]; !ok {
+		w.cfgorder = append(w.cfgorder, k)
+	} else if !av.ConfigEqual(pkt.Type, last.Data, pkt.Data) {
+		w.disc = true
+	}
+	w.cfgs[k] = pkt
+}
+
+func (w *Writer) WritePacket(pkt av.Packet) (err error) {
+	switch {
+	case pkt.Type == av.Metadata:
+		return
+
+	case av.IsConfig(pkt.Type):
+		if av.IsVideo(pkt.Type) {
+			w.hasVideo = true
+		}
+		w.setConfig(pkt)
+		// audio frames up to the cut need a changed config right away
+		if w.m != nil {
+			return w.m.WritePacket(pkt)
+		}
+		return
+	}
+
+	video := av.IsVideo(pkt.Type)
+	if video {
+		w.hasVideo = true
+	}
+	tk := trackKey{video, pkt.Idx}
+	if last, ok := w.lasts[tk]; ok && w.f != nil && pkt.Time < last {
+		w.disc = true
+	}
+
+	canCut := !w.hasVideo || video && pkt.IsKeyFrame
+	if canCut && (w.f == nil || w.disc || pkt.Time-w.start >= w.TargetDuration) {
+		end := pkt.Time
+		if end < w.last {
+			end = w.last
+		}
+		if err = w.closeSegment(end); err != nil {
+			return
+		}
+		if err = w.openSegment(pkt.Time); err != nil {
+			return
+		}
+	}
+	if w.f == nil {
+		// wait for the first keyframe
+		return
+	}
+
+	w.lasts[tk] = pkt.Time
+	if pkt.Time > w.last {
+		w.last = pkt.Time
+	}
+	return w.m.WritePacket(pkt)
+}
+
+func (w *Writer) Close() (err error) {
+	if err = w.closeSegment(w.last); err != nil {
+		return
+	}
+	return w.writePlaylist(w.Type != PLAYLIST_LIVE)
+}
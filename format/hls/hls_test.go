@@ -0,0 +1,173 @@
+package hls
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/format/ts"
+)
+
+func writeAudio(t *testing.T, w *Writer, start time.Duration, n int) {
+	for i := 0; i < n; i++ {
+		pkt := av.Packet{Type: av.AAC, Time: start + time.Duration(i)*time.Millisecond*100, Data: []byte{1, 2}}
+		if err := w.WritePacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestLivePlaylist(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(filepath.Join(dir, "index.m3u8"))
+	w.TargetDuration = time.Second
+	w.ListSize = 2
+
+	if err := w.WritePacket(av.Packet{Type: av.AACDecoderConfig, Data: []byte{0x12, 0x10}}); err != nil {
+		t.Fatal(err)
+	}
+	writeAudio(t, w, 0, 40)
+	// restarted source
+	writeAudio(t, w, 0, 15)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "index.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Join([]string{
+		"#EXTM3U",
+		"#EXT-X-VERSION:3",
+		"#EXT-X-TARGETDURATION:1",
+		"#EXT-X-MEDIA-SEQUENCE:4",
+		"#EXT-X-DISCONTINUITY",
+		"#EXTINF:1.000,",
+		"index4.ts",
+		"#EXTINF:0.400,",
+		"index5.ts",
+		"",
+	}, "\n")
+	if string(b) != want {
+		t.Fatalf("playlist\n%s", b)
+	}
+
+	for seq, exists := range []bool{false, false, true, true, true, true} {
+		_, err := os.Stat(filepath.Join(dir, w.segmentName(seq)))
+		if exists != (err == nil) {
+			t.Fatalf("segment %d exists %v", seq, err == nil)
+		}
+	}
+}
+
+func TestEventPlaylist(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(filepath.Join(dir, "index.m3u8"))
+	w.TargetDuration = time.Second
+	w.Type = PLAYLIST_EVENT
+
+	if err := w.WritePacket(av.Packet{Type: av.AACDecoderConfig, Data: []byte{0x12, 0x10}}); err != nil {
+		t.Fatal(err)
+	}
+	writeAudio(t, w, 0, 25)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "index.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(b)
+	if !strings.Contains(s, "#EXT-X-PLAYLIST-TYPE:EVENT\n") || !strings.HasSuffix(s, "index2.ts\n#EXT-X-ENDLIST\n") {
+		t.Fatalf("playlist\n%s", b)
+	}
+}
+
+func TestTargetDuration(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(filepath.Join(dir, "index.m3u8"))
+	w.TargetDuration = time.Second
+	w.ListSize = 2
+
+	// a 3.5s GOP, then 1s GOPs
+	keys := map[int]bool{0: true, 35: true, 45: true, 55: true, 65: true}
+	for i := 0; i < 70; i++ {
+		pkt := av.Packet{Type: av.H264, IsKeyFrame: keys[i], Time: time.Duration(i) * time.Millisecond * 100, Data: []byte{0, 0, 0, 2, 0x41, 0}}
+		if err := w.WritePacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "index.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := string(b)
+	if strings.Contains(s, "index0.ts") || !strings.Contains(s, "#EXT-X-TARGETDURATION:4\n") {
+		t.Fatalf("playlist\n%s", b)
+	}
+}
+
+func TestAudioConfigChange(t *testing.T) {
+	dir := t.TempDir()
+	w := NewWriter(filepath.Join(dir, "index.m3u8"))
+	w.TargetDuration = time.Second
+
+	// 44.1kHz then 48kHz from 500ms, in the middle of a 2s GOP
+	pkts := []av.Packet{{Type: av.AACDecoderConfig, Data: []byte{0x12, 0x10}}}
+	for i := 0; i < 30; i++ {
+		tm := time.Duration(i) * time.Millisecond * 100
+		if i == 5 {
+			pkts = append(pkts, av.Packet{Type: av.AACDecoderConfig, Time: tm, Data: []byte{0x11, 0x90}})
+		}
+		pkts = append(pkts,
+			av.Packet{Type: av.H264, IsKeyFrame: i%20 == 0, Time: tm, Data: []byte{0, 0, 0, 2, 0x41, 0}},
+			av.Packet{Type: av.AAC, Time: tm, Data: []byte{1, 2}},
+		)
+	}
+	for _, pkt := range pkts {
+		if err := w.WritePacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "index0.ts"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	d := ts.NewDemuxer(f)
+	var rates []string
+	for {
+		pkt, err := d.ReadPacket()
+		if err != nil {
+			break
+		}
+		if pkt.Type == av.AAC {
+			rates = append(rates, fmt.Sprint(pkt.Time-ts.TimeOffset, " ", pkt.AAC.Config.SampleRate))
+		}
+	}
+	if len(rates) != 20 || rates[4] != "400ms 44100" || rates[5] != "500ms 48000" {
+		t.Fatalf("segment audio %q", rates)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "index.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "#EXT-X-DISCONTINUITY\n#EXTINF:0.900,\nindex1.ts\n") {
+		t.Fatalf("playlist\n%s", b)
+	}
+}
@@ -0,0 +1,514 @@
+package mp4
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/aac"
+	"github.com/nareix/joy5/codec/h264"
+	"github.com/nareix/joy5/format/mp4/mp4io"
+	"github.com/nareix/joy5/utils/bits/pio"
+)
+
+type sample struct {
+	offset int64
+	size   int
+	dts    int64
+	cto    int64
+	sync   bool
+}
+
+type track struct {
+	idx       int
+	video     bool
+	timescale int64
+	cfg       []byte
+	h264      *h264.Codec
+	aac       *aac.Codec
+
+	samples []sample
+	next    int
+}
+
+func (t *track) time(ts int64) time.Duration {
+	return time.Duration(ts/t.timescale)*time.Second + time.Duration(ts%t.timescale)*time.Second/time.Duration(t.timescale)
+}
+
+func (t *track) configPacket() av.Packet {
+	if t.video {
+		return av.Packet{Type: av.H264DecoderConfig, Idx: t.idx, Data: t.cfg, H264: t.h264}
+	}
+	return av.Packet{Type: av.AACDecoderConfig, Idx: t.idx, Data: t.cfg, AAC: t.aac}
+}
+
+// Demuxer reads H.264 and AAC tracks of a progressive MP4 or MOV, moov can
+// be before or after mdat. Config packets come first, then frames of all
+// tracks in decode time order. Times follow the edit list of a track, if a
+// first frame delayed by B-frames would start below zero all tracks are
+// moved later together. Other tracks are skipped, fragmented files are not
+// supported.
+type Demuxer struct {
+	R io.ReadSeeker
+
+	tracks   []*track
+	pending  []av.Packet
+	start    time.Duration
+	parsed   bool
+	parseErr error
+}
+
+func NewDemuxer(r io.ReadSeeker) *Demuxer {
+	return &Demuxer{
+		R: r,
+	}
+}
+
+func (d *Demuxer) readMoov() (moov []byte, err error) {
+	var pos int64
+	hdr := make([]byte, 16)
+	for {
+		if _, err = d.R.Seek(pos, io.SeekStart); err != nil {
+			return
+		}
+		var n int
+		if n, err = io.ReadFull(d.R, hdr); err != nil && (err != io.ErrUnexpectedEOF || n < mp4io.BoxHeaderLength) {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = fmt.Errorf("mp4: moov not found")
+			}
+			return
+		}
+		var typ string
+		var size int64
+		var hdrlen int
+		if typ, size, hdrlen, err = mp4io.ParseBoxHeader(hdr[:n]); err != nil {
+			return
+		}
+
+		if typ == "moov" {
+			if _, err = d.R.Seek(pos+int64(hdrlen), io.SeekStart); err != nil {
+				return
+			}
+			if size == 0 {
+				return io.ReadAll(d.R)
+			}
+			moov = make([]byte, size-int64(hdrlen))
+			_, err = io.ReadFull(d.R, moov)
+			return
+		}
+		if size == 0 {
+			err = fmt.Errorf("mp4: moov not found")
+			return
+		}
+		pos += size
+	}
+}
+
+// table checks a full box of count entries of width bytes, returns the
+// entries.
+func table(b []byte, width int) (count int, entries []byte, err error) {
+	if len(b) < 8 {
+		err = fmt.Errorf("mp4: sample table too short")
+		return
+	}
+	count = int(pio.U32BE(b[4:]))
+	entries = b[8:]
+	if count < 0 || count > len(entries)/width {
+		err = fmt.Errorf("mp4: sample table of %d entries too short", count)
+		return
+	}
+	return
+}
+
+func (t *track) parseSampleEntry(stsd []byte) (ok bool, err error) {
+	if len(stsd) < 8 {
+		err = fmt.Errorf("mp4: stsd too short")
+		return
+	}
+	var typ string
+	var entry []byte
+	mp4io.Children(stsd[8:], func(t string, payload []byte) bool {
+		typ, entry = t, payload
+		return false
+	})
+
+	switch typ {
+	case "avc1", "avc3":
+		// VisualSampleEntry fields before the child boxes
+		if len(entry) < 78 {
+			err = fmt.Errorf("mp4: %s too short", typ)
+			return
+		}
+		avcC := mp4io.Find(entry[78:], "avcC")
+		if avcC == nil {
+			err = fmt.Errorf("mp4: avcC not found")
+			return
+		}
+		if t.h264, err = h264.FromDecoderConfig(avcC); err != nil {
+			return
+		}
+		t.video = true
+		t.cfg = avcC
+
+	case "mp4a":
+		// AudioSampleEntry, longer in MOV sound description version 1 and 2
+		n := 28
+		if len(entry) >= 10 {
+			switch pio.U16BE(entry[8:]) {
+			case 1:
+				n += 16
+			case 2:
+				n += 36
+			}
+		}
+		if len(entry) < n {
+			err = fmt.Errorf("mp4: mp4a too short")
+			return
+		}
+		esds := mp4io.Find(entry[n:], "esds")
+		if esds == nil {
+			esds = mp4io.Find(entry[n:], "wave", "esds")
+		}
+		if esds == nil {
+			err = fmt.Errorf("mp4: esds not found")
+			return
+		}
+		var config []byte
+		if config, err = mp4io.ParseESDS(esds); err != nil {
+			return
+		}
+		if t.aac, err = aac.FromMPEG4AudioConfigBytes(config); err != nil {
+			return
+		}
+		t.cfg = t.aac.ConfigBytes
+
+	default:
+		return
+	}
+
+	ok = true
+	return
+}
+
+func (t *track) parseSamples(stbl []byte) (err error) {
+	stsz := mp4io.Find(stbl, "stsz")
+	if len(stsz) < 12 {
+		err = fmt.Errorf("mp4: stsz not found")
+		return
+	}
+	samplesize := int(pio.U32BE(stsz[4:]))
+	count := int(pio.U32BE(stsz[8:]))
+	if samplesize == 0 {
+		if count < 0 || count > len(stsz[12:])/4 {
+			err = fmt.Errorf("mp4: stsz of %d entries too short", count)
+			return
+		}
+	}
+	t.samples = make([]sample, count)
+	for i := range t.samples {
+		if samplesize == 0 {
+			t.samples[i].size = int(pio.U32BE(stsz[12+i*4:]))
+		} else {
+			t.samples[i].size = samplesize
+		}
+	}
+
+	var n int
+	var entries []byte
+
+	if n, entries, err = table(mp4io.Find(stbl, "stts"), 8); err != nil {
+		return
+	}
+	i := 0
+	dts := int64(0)
+	for k := 0; k < n; k++ {
+		c := int(pio.U32BE(entries[k*8:]))
+		delta := int64(pio.U32BE(entries[k*8+4:]))
+		for j := 0; j < c && i < count; j++ {
+			t.samples[i].dts = dts
+			dts += delta
+			i++
+		}
+	}
+
+	if ctts := mp4io.Find(stbl, "ctts"); ctts != nil {
+		if n, entries, err = table(ctts, 8); err != nil {
+			return
+		}
+		i := 0
+		for k := 0; k < n; k++ {
+			c := int(pio.U32BE(entries[k*8:]))
+			// version 0 offsets are unsigned, but negative ones are
+			// written that way too
+			cto := int64(pio.I32BE(entries[k*8+4:]))
+			for j := 0; j < c && i < count; j++ {
+				t.samples[i].cto = cto
+				i++
+			}
+		}
+	}
+
+	if stss := mp4io.Find(stbl, "stss"); stss != nil {
+		if n, entries, err = table(stss, 4); err != nil {
+			return
+		}
+		for k := 0; k < n; k++ {
+			if i := int(pio.U32BE(entries[k*4:])) - 1; i >= 0 && i < count {
+				t.samples[i].sync = true
+			}
+		}
+	} else {
+		// every sample is a sync sample
+		for i := range t.samples {
+			t.samples[i].sync = true
+		}
+	}
+
+	var nchunks int
+	var chunks []byte
+	width := 4
+	if stco := mp4io.Find(stbl, "stco"); stco != nil {
+		if nchunks, chunks, err = table(stco, width); err != nil {
+			return
+		}
+	} else {
+		width = 8
+		if nchunks, chunks, err = table(mp4io.Find(stbl, "co64"), width); err != nil {
+			return
+		}
+	}
+	chunkOffset := func(c int) int64 {
+		if width == 4 {
+			return int64(pio.U32BE(chunks[c*4:]))
+		}
+		return int64(pio.U64BE(chunks[c*8:]))
+	}
+
+	if n, entries, err = table(mp4io.Find(stbl, "stsc"), 12); err != nil {
+		return
+	}
+	i = 0
+	for k := 0; k < n; k++ {
+		first := int(pio.U32BE(entries[k*12:])) - 1
+		per := int(pio.U32BE(entries[k*12+4:]))
+		last := nchunks
+		if k+1 < n {
+			last = int(pio.U32BE(entries[(k+1)*12:])) - 1
+		}
+		for c := first; c >= 0 && c < last && c < nchunks && i < count; c++ {
+			offset := chunkOffset(c)
+			for j := 0; j < per && i < count; j++ {
+				t.samples[i].offset = offset
+				offset += int64(t.samples[i].size)
+				i++
+			}
+		}
+	}
+	if i < count {
+		err = fmt.Errorf("mp4: %d of %d samples in chunks", i, count)
+		return
+	}
+	return
+}
+
+// applyEdits moves samples to the presentation timeline of elst. Empty edits
+// at the start delay the track, the media_time of the first other edit is
+// taken as time zero. Later edits are ignored.
+func (t *track) applyEdits(elst []byte, movieTimescale int64) (err error) {
+	if len(elst) < 4 {
+		return
+	}
+	width := 12
+	if elst[0] == 1 {
+		width = 20
+	}
+	var n int
+	var entries []byte
+	if n, entries, err = table(elst, width); err != nil {
+		return
+	}
+
+	var shift int64
+	for k := 0; k < n; k++ {
+		e := entries[k*width:]
+		var dur, mediaTime int64
+		if width == 20 {
+			dur, mediaTime = int64(pio.U64BE(e)), int64(pio.U64BE(e[8:]))
+		} else {
+			dur, mediaTime = int64(pio.U32BE(e)), int64(pio.I32BE(e[4:]))
+		}
+		if mediaTime == -1 {
+			if movieTimescale > 0 {
+				shift += dur * t.timescale / movieTimescale
+			}
+			continue
+		}
+		shift -= mediaTime
+		break
+	}
+	for i := range t.samples {
+		t.samples[i].dts += shift
+	}
+	return
+}
+
+// timescale reads the timescale of mvhd or mdhd, creation_time and
+// modification_time are 64 bits in version 1.
+func timescale(b []byte) int64 {
+	switch {
+	case len(b) >= 24 && b[0] == 1:
+		return int64(pio.U32BE(b[20:]))
+	case len(b) >= 16 && b[0] == 0:
+		return int64(pio.U32BE(b[12:]))
+	}
+	return 0
+}
+
+func parseTrak(trak []byte, movieTimescale int64) (t *track, err error) {
+	mdia := mp4io.Find(trak, "mdia")
+	mdhd := mp4io.Find(mdia, "mdhd")
+	stbl := mp4io.Find(mdia, "minf", "stbl")
+	if mdhd == nil || stbl == nil {
+		err = fmt.Errorf("mp4: trak without mdhd or stbl")
+		return
+	}
+
+	t = &track{}
+	if t.timescale = timescale(mdhd); t.timescale == 0 {
+		err = fmt.Errorf("mp4: mdhd timescale invalid")
+		return
+	}
+
+	var ok bool
+	if ok, err = t.parseSampleEntry(mp4io.Find(stbl, "stsd")); err != nil || !ok {
+		t = nil
+		return
+	}
+	if err = t.parseSamples(stbl); err != nil {
+		return
+	}
+	if err = t.applyEdits(mp4io.Find(trak, "edts", "elst"), movieTimescale); err != nil {
+		return
+	}
+	return
+}
+
+func (d *Demuxer) parseMoov() (err error) {
+	var moov []byte
+	if moov, err = d.readMoov(); err != nil {
+		return
+	}
+	if mp4io.Find(moov, "mvex") != nil {
+		err = fmt.Errorf("mp4: fragmented mp4 not supported")
+		return
+	}
+
+	movieTimescale := timescale(mp4io.Find(moov, "mvhd"))
+	tracks := []*track{}
+	for _, trak := range mp4io.FindAll(moov, "trak") {
+		var t *track
+		if t, err = parseTrak(trak, movieTimescale); err != nil {
+			return
+		}
+		if t != nil {
+			tracks = append(tracks, t)
+		}
+	}
+
+	// video first
+	for _, video := range []bool{true, false} {
+		idx := 0
+		for _, t := range tracks {
+			if t.video == video {
+				t.idx = idx
+				idx++
+				d.tracks = append(d.tracks, t)
+				d.pending = append(d.pending, t.configPacket())
+			}
+		}
+	}
+
+	// keep the offsets between tracks, no time below zero
+	for _, t := range d.tracks {
+		if len(t.samples) > 0 {
+			if tm := t.time(t.samples[0].dts); tm < d.start {
+				d.start = tm
+			}
+		}
+	}
+	return
+}
+
+func (d *Demuxer) parse() error {
+	if !d.parsed {
+		d.parsed = true
+		d.parseErr = d.parseMoov()
+	}
+	return d.parseErr
+}
+
+func (d *Demuxer) Streams() (streams []av.Stream, err error) {
+	if err = d.parse(); err != nil {
+		return
+	}
+	for _, t := range d.tracks {
+		var c av.CodecData
+		if c, err = av.CodecDataFromConfig(t.configPacket()); err != nil {
+			return
+		}
+		streams = append(streams, av.Stream{Idx: t.idx, CodecData: c})
+	}
+	return
+}
+
+func (d *Demuxer) ReadPacket() (pkt av.Packet, err error) {
+	if err = d.parse(); err != nil {
+		return
+	}
+	if len(d.pending) > 0 {
+		pkt = d.pending[0]
+		d.pending = d.pending[1:]
+		return
+	}
+
+	var t *track
+	for _, c := range d.tracks {
+		if c.next >= len(c.samples) {
+			continue
+		}
+		if t == nil || c.time(c.samples[c.next].dts) < t.time(t.samples[t.next].dts) {
+			t = c
+		}
+	}
+	if t == nil {
+		err = io.EOF
+		return
+	}
+	s := t.samples[t.next]
+	t.next++
+
+	data := make([]byte, s.size)
+	if _, err = d.R.Seek(s.offset, io.SeekStart); err != nil {
+		return
+	}
+	if _, err = io.ReadFull(d.R, data); err != nil {
+		return
+	}
+
+	pkt = av.Packet{
+		Idx:   t.idx,
+		Time:  t.time(s.dts) - d.start,
+		CTime: t.time(s.dts+s.cto) - t.time(s.dts),
+		Data:  data,
+	}
+	if t.video {
+		pkt.Type = av.H264
+		pkt.IsKeyFrame = s.sync
+		pkt.H264 = t.h264
+	} else {
+		pkt.Type = av.AAC
+		pkt.AAC = t.aac
+	}
+	return
+}
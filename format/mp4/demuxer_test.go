@@ -0,0 +1,166 @@
+package mp4
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/h264"
+	"github.com/nareix/joy5/format/mp4/mp4io"
+	"github.com/nareix/joy5/utils/bits/pio"
+)
+
+var (
+	testSPS = []byte{0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x03, 0xc0, 0xf1, 0x83, 0x19, 0x60}
+	testPPS = []byte{0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0}
+)
+
+func u32s(v ...uint32) []byte {
+	return mp4io.Fields(func(b []byte, n *int) {
+		for _, x := range v {
+			pio.WriteU32BE(b, n, x)
+		}
+	})
+}
+
+func testTrak(timescale uint32, elst []byte, entry []byte, tables ...[]byte) []byte {
+	mdhd := mp4io.FullBox("mdhd", 0, 0, u32s(0, 0, timescale, 0, 0x55c40000))
+	stsd := mp4io.FullBox("stsd", 0, 0, u32s(1), entry)
+	stbl := mp4io.Box("stbl", append([][]byte{stsd}, tables...)...)
+	mdia := mp4io.Box("mdia", mdhd, mp4io.Box("minf", stbl))
+	if elst == nil {
+		return mp4io.Box("trak", mdia)
+	}
+	return mp4io.Box("trak", mp4io.Box("edts", elst), mdia)
+}
+
+// testFile has 4 video frames in 2 chunks and 3 audio frames in 1 chunk,
+// moov after mdat. The movie timescale is 1000.
+func testFile(videoElst, audioElst []byte) []byte {
+	c := h264.NewCodec()
+	c.AddSPSPPS(testSPS)
+	c.AddSPSPPS(testPPS)
+	avc1 := mp4io.Box("avc1", make([]byte, 78), mp4io.Box("avcC", mp4io.Fields(c.ToConfig)))
+	esds := mp4io.FullBox("esds", 0, 0, mp4io.Descriptor(mp4io.TAG_ES_DESCRIPTOR,
+		[]byte{0, 0, 0},
+		mp4io.Descriptor(mp4io.TAG_DECODER_CONFIG,
+			[]byte{0x40, 0x15}, make([]byte, 11),
+			mp4io.Descriptor(mp4io.TAG_DECODER_SPECIFIC, []byte{0x12, 0x08}),
+		),
+	))
+	mp4a := mp4io.Box("mp4a", make([]byte, 28), esds)
+
+	ftyp := mp4io.Box("ftyp", []byte("isom"), make([]byte, 4))
+	// v0 v1 | a0 a1 a2 | v2 v3
+	mdat := mp4io.Box("mdat", []byte{0, 1, 1, 10, 11, 11, 12, 12, 12, 2, 2, 2, 3})
+	base := uint32(len(ftyp) + mp4io.BoxHeaderLength)
+
+	video := testTrak(90000, videoElst, avc1,
+		mp4io.FullBox("stts", 0, 0, u32s(1, 4, 3600)),
+		mp4io.FullBox("ctts", 0, 0, u32s(2, 1, 7200, 3, 0)),
+		mp4io.FullBox("stss", 0, 0, u32s(2, 1, 3)),
+		mp4io.FullBox("stsc", 0, 0, u32s(1, 1, 2, 1)),
+		mp4io.FullBox("stsz", 0, 0, u32s(0, 4, 1, 2, 3, 1)),
+		mp4io.FullBox("stco", 0, 0, u32s(2, base, base+9)),
+	)
+	audio := testTrak(44100, audioElst, mp4a,
+		mp4io.FullBox("stts", 0, 0, u32s(1, 3, 1024)),
+		mp4io.FullBox("stsc", 0, 0, u32s(1, 1, 3, 1)),
+		mp4io.FullBox("stsz", 0, 0, u32s(0, 3, 1, 2, 3)),
+		mp4io.FullBox("co64", 0, 0, u32s(1, 0, base+3)),
+	)
+	// audio trak first, video is still read first
+	mvhd := mp4io.FullBox("mvhd", 0, 0, u32s(0, 0, 1000, 0), make([]byte, 80))
+	moov := mp4io.Box("moov", mvhd, audio, video)
+
+	return append(append(ftyp, mdat...), moov...)
+}
+
+func TestDemuxer(t *testing.T) {
+	d := NewDemuxer(bytes.NewReader(testFile(nil, nil)))
+
+	streams, err := d.Streams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 2 || !streams[0].IsVideo() || streams[0].Width != 1280 || streams[1].SampleRate != 44100 {
+		t.Fatalf("streams %v", streams)
+	}
+
+	audioTime := func(i int) time.Duration {
+		return time.Duration(i*1024) * time.Second / 44100
+	}
+	want := []av.Packet{
+		{Type: av.H264DecoderConfig},
+		{Type: av.AACDecoderConfig, Data: []byte{0x12, 0x08}},
+		{Type: av.H264, IsKeyFrame: true, CTime: time.Millisecond * 80, Data: []byte{0}},
+		{Type: av.AAC, Data: []byte{10}},
+		{Type: av.AAC, Time: audioTime(1), Data: []byte{11, 11}},
+		{Type: av.H264, Time: time.Millisecond * 40, Data: []byte{1, 1}},
+		{Type: av.AAC, Time: audioTime(2), Data: []byte{12, 12, 12}},
+		{Type: av.H264, Time: time.Millisecond * 80, IsKeyFrame: true, Data: []byte{2, 2, 2}},
+		{Type: av.H264, Time: time.Millisecond * 120, Data: []byte{3}},
+	}
+	for i, w := range want {
+		pkt, err := d.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkt.Type != w.Type || pkt.Time != w.Time || pkt.CTime != w.CTime || pkt.IsKeyFrame != w.IsKeyFrame ||
+			w.Data != nil && !bytes.Equal(pkt.Data, w.Data) {
+			t.Fatalf("packet %d: %s %v", i, pkt.String(), pkt.Data)
+		}
+	}
+	if _, err := d.ReadPacket(); err != io.EOF {
+		t.Fatalf("want EOF, got %v", err)
+	}
+}
+
+func TestDemuxerEditList(t *testing.T) {
+	// video starts at its first presentation time, two B-frame delayed
+	// frames before it, audio is delayed 500ms by an empty edit
+	videoElst := mp4io.FullBox("elst", 0, 0, u32s(1, 360, 7200, 0x10000))
+	audioElst := mp4io.FullBox("elst", 1, 0, mp4io.Fields(func(b []byte, n *int) {
+		pio.WriteU32BE(b, n, 2)
+		pio.WriteU64BE(b, n, 500)
+		pio.WriteU64BE(b, n, 0xffffffffffffffff)
+		pio.WriteU32BE(b, n, 0x10000)
+		pio.WriteU64BE(b, n, 3072)
+		pio.WriteU64BE(b, n, 0)
+		pio.WriteU32BE(b, n, 0x10000)
+	}))
+	d := NewDemuxer(bytes.NewReader(testFile(videoElst, audioElst)))
+
+	var got []string
+	for {
+		pkt, err := d.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pkt.Time < 0 {
+			t.Fatalf("%s at %v", av.PacketTypeString[pkt.Type], pkt.Time)
+		}
+		if !av.IsConfig(pkt.Type) {
+			got = append(got, fmt.Sprintf("%s %v", av.PacketTypeString[pkt.Type], pkt.Time))
+		}
+	}
+	// all moved 80ms later
+	audioTime := func(i int) time.Duration {
+		return time.Millisecond*580 + time.Duration(i*1024)*time.Second/44100
+	}
+	want := []string{
+		"H264 0s", "H264 40ms", "H264 80ms", "H264 120ms",
+		fmt.Sprintf("AAC %v", audioTime(0)),
+		fmt.Sprintf("AAC %v", audioTime(1)),
+		fmt.Sprintf("AAC %v", audioTime(2)),
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %q\nwant %q", got, want)
+	}
+}
@@ -0,0 +1,243 @@
+package mp4io
+
+import (
+	"fmt"
+
+	"github.com/nareix/joy5/utils/bits/pio"
+)
+
+const (
+	SAMPLE_FLAG_SYNC     = 0x02000000 // sample_depends_on 2
+	SAMPLE_FLAG_NON_SYNC = 0x01010000 // sample_depends_on 1, sample_is_non_sync_sample
+
+	TFHD_DEFAULT_BASE_IS_MOOF = 0x020000
+
+	TRUN_DATA_OFFSET       = 0x1
+	TRUN_SAMPLE_DURATION   = 0x100
+	TRUN_SAMPLE_SIZE       = 0x200
+	TRUN_SAMPLE_FLAGS      = 0x400
+	TRUN_SAMPLE_CTS_OFFSET = 0x800
+)
+
+const BoxHeaderLength = 8
+
+const (
+	TAG_ES_DESCRIPTOR    = 0x03
+	TAG_DECODER_CONFIG   = 0x04
+	TAG_DECODER_SPECIFIC = 0x05
+	TAG_SL_CONFIG        = 0x06
+)
+
+// Box joins payload into a box of typ.
+func Box(typ string, payload ...[]byte) []byte {
+	n := BoxHeaderLength
+	for _, p := range payload {
+		n += len(p)
+	}
+	b := make([]byte, n)
+	i := 0
+	pio.WriteU32BE(b, &i, uint32(n))
+	pio.WriteString(b, &i, typ)
+	for _, p := range payload {
+		pio.WriteBytes(b, &i, p)
+	}
+	return b
+}
+
+// FullBox is Box with version and flags.
+func FullBox(typ string, version uint8, flags uint32, payload ...[]byte) []byte {
+	vf := make([]byte, 4)
+	pio.PutU32BE(vf, uint32(version)<<24|flags&0xffffff)
+	return Box(typ, append([][]byte{vf}, payload...)...)
+}
+
+// Descriptor writes an MPEG-4 descriptor of esds, len(payload) must be less
+// than 128.
+func Descriptor(tag uint8, payload ...[]byte) []byte {
+	n := 0
+	for _, p := range payload {
+		n += len(p)
+	}
+	b := []byte{tag, uint8(n)}
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// Matrix is the unity transformation matrix of mvhd and tkhd.
+var Matrix = []uint32{0x10000, 0, 0, 0, 0x10000, 0, 0, 0, 0x40000000}
+
+func WriteMatrix(b []byte, n *int) {
+	for _, v := range Matrix {
+		pio.WriteU32BE(b, n, v)
+	}
+}
+
+// Fields runs fill once to size the buffer and once to write it.
+func Fields(fill func(b []byte, n *int)) []byte {
+	n := 0
+	fill(nil, &n)
+	b := make([]byte, n)
+	n = 0
+	fill(b, &n)
+	return b
+}
+
+// ParseBoxHeader parses the header at the start of b. size is of the whole
+// box, 0 if it extends to the end of the file.
+func ParseBoxHeader(b []byte) (typ string, size int64, hdrlen int, err error) {
+	if len(b) < BoxHeaderLength {
+		err = fmt.Errorf("mp4io: box header too short")
+		return
+	}
+	size = int64(pio.U32BE(b))
+	typ = string(b[4:8])
+	hdrlen = BoxHeaderLength
+	if size == 1 {
+		if len(b) < BoxHeaderLength+8 {
+			err = fmt.Errorf("mp4io: box header too short")
+			return
+		}
+		size = int64(pio.U64BE(b[8:]))
+		hdrlen += 8
+	}
+	if size != 0 && size < int64(hdrlen) {
+		err = fmt.Errorf("mp4io: box %q size %d invalid", typ, size)
+		return
+	}
+	return
+}
+
+// Children calls fn with the type and payload of each box in b, stopping
+// when fn returns false.
+func Children(b []byte, fn func(typ string, payload []byte) bool) (err error) {
+	for len(b) > 0 {
+		var typ string
+		var size int64
+		var hdrlen int
+		if typ, size, hdrlen, err = ParseBoxHeader(b); err != nil {
+			return
+		}
+		if size == 0 {
+			size = int64(len(b))
+		}
+		if size > int64(len(b)) {
+			err = fmt.Errorf("mp4io: box %q size %d exceeds its parent", typ, size)
+			return
+		}
+		if !fn(typ, b[hdrlen:size]) {
+			return
+		}
+		b = b[size:]
+	}
+	return
+}
+
+// Find returns the payload of the first box at path under b, nil if not
+// found.
+func Find(b []byte, path ...string) []byte {
+	for _, typ := range path {
+		var found []byte
+		Children(b, func(t string, payload []byte) bool {
+			if t == typ {
+				found = payload
+				return false
+			}
+			return true
+		})
+		if found == nil {
+			return nil
+		}
+		b = found
+	}
+	return b
+}
+
+// FindAll returns the payloads of the boxes of typ directly under b.
+func FindAll(b []byte, typ string) (out [][]byte) {
+	Children(b, func(t string, payload []byte) bool {
+		if t == typ {
+			out = append(out, payload)
+		}
+		return true
+	})
+	return
+}
+
+// ParseDescriptor parses the MPEG-4 descriptor at the start of b, n is where
+// the next one starts.
+func ParseDescriptor(b []byte) (tag uint8, payload []byte, n int, err error) {
+	if tag, err = pio.ReadU8(b, &n); err != nil {
+		return
+	}
+	length := 0
+	for i := 0; i < 4; i++ {
+		var v uint8
+		if v, err = pio.ReadU8(b, &n); err != nil {
+			return
+		}
+		length = length<<7 | int(v&0x7f)
+		if v&0x80 == 0 {
+			break
+		}
+	}
+	if payload, err = pio.ReadBytes(b, &n, length); err != nil {
+		return
+	}
+	return
+}
+
+// ParseESDS returns the DecoderSpecificInfo of an esds payload, the AAC
+// AudioSpecificConfig.
+func ParseESDS(b []byte) (config []byte, err error) {
+	if len(b) < 4 {
+		err = fmt.Errorf("mp4io: esds too short")
+		return
+	}
+	var tag uint8
+	var es []byte
+	if tag, es, _, err = ParseDescriptor(b[4:]); err != nil {
+		return
+	}
+	if tag != TAG_ES_DESCRIPTOR || len(es) < 3 {
+		err = fmt.Errorf("mp4io: es descriptor not found")
+		return
+	}
+	// ES_ID, then optional fields by flags
+	flags := es[2]
+	n := 3
+	if flags&0x80 != 0 {
+		n += 2 // dependsOn_ES_ID
+	}
+	if flags&0x40 != 0 && n < len(es) {
+		n += 1 + int(es[n]) // URL
+	}
+	if flags&0x20 != 0 {
+		n += 2 // OCR_ES_ID
+	}
+	if n > len(es) {
+		err = fmt.Errorf("mp4io: es descriptor too short")
+		return
+	}
+
+	var dc []byte
+	if tag, dc, _, err = ParseDescriptor(es[n:]); err != nil {
+		return
+	}
+	// objectTypeIndication, streamType, bufferSizeDB, maxBitrate, avgBitrate
+	if tag != TAG_DECODER_CONFIG || len(dc) < 13 {
+		err = fmt.Errorf("mp4io: decoder config descriptor not found")
+		return
+	}
+	var dsi []byte
+	if tag, dsi, _, err = ParseDescriptor(dc[13:]); err != nil {
+		return
+	}
+	if tag != TAG_DECODER_SPECIFIC {
+		err = fmt.Errorf("mp4io: decoder specific info not found")
+		return
+	}
+	config = dsi
+	return
+}
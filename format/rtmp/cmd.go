@@ -216,15 +216,22 @@ func (c *Conn) readConnect() (err error) {
 
 	objectEncoding, _ := cmd.obj.GetFloat64("objectEncoding")
 
+	c.PeerFourCcList = parseFourCcList(cmd.obj)
+
 	if err = c.writeBasicConf(); err != nil {
 		return
 	}
 
+	props := flvio.AMFMap{
+		{K: "fmsVer", V: "LNX 9,0,124,2"},
+		{K: "capabilities", V: 31},
+	}
+	if len(c.PeerFourCcList) > 0 && len(c.FourCcList) > 0 {
+		props = props.Set("fourCcList", fourCcListAMF(c.FourCcList))
+	}
+
 	if err = c.writeCommand(3, 0, "_result", cmd.transid,
-		flvio.AMFMap{
-			{K: "fmsVer", V: "LNX 9,0,124,2"},
-			{K: "capabilities", V: 31},
-		},
+		props,
 		flvio.AMFMap{
 			{K: "level", V: "status"},
 			{K: "code", V: "NetConnection.Connect.Success"},
@@ -319,18 +326,21 @@ func (c *Conn) writeConnect(path string) (err error) {
 		return
 	}
 
-	if err = c.writeCommand(3, 0, "connect", 1,
-		flvio.AMFMap{
-			{K: "app", V: path},
-			{K: "flashVer", V: "LNX 9,0,124,2"},
-			{K: "tcUrl", V: getTcURL(c.URL)},
-			{K: "fpad", V: false},
-			{K: "capabilities", V: 15},
-			{K: "audioCodecs", V: 4071},
-			{K: "videoCodecs", V: 252},
-			{K: "videoFunction", V: 1},
-		},
-	); err != nil {
+	obj := flvio.AMFMap{
+		{K: "app", V: path},
+		{K: "flashVer", V: "LNX 9,0,124,2"},
+		{K: "tcUrl", V: getTcURL(c.URL)},
+		{K: "fpad", V: false},
+		{K: "capabilities", V: 15},
+		{K: "audioCodecs", V: 4071},
+		{K: "videoCodecs", V: 252},
+		{K: "videoFunction", V: 1},
+	}
+	if len(c.FourCcList) > 0 {
+		obj = obj.Set("fourCcList", fourCcListAMF(c.FourCcList))
+	}
+
+	if err = c.writeCommand(3, 0, "connect", 1, obj); err != nil {
 		return
 	}
 
@@ -349,6 +359,9 @@ func (c *Conn) writeConnect(path string) (err error) {
 				err = fmt.Errorf("CommandConnectFailed: %s", err)
 				return
 			}
+			if cmd.obj != nil {
+				c.PeerFourCcList = parseFourCcList(cmd.obj)
+			}
 			break
 		}
 	}
@@ -495,14 +508,52 @@ func (c *Conn) ReadPacket() (pkt av.Packet, err error) {
 	if err = c.Prepare(StageCommandDone, PrepareReading); err != nil {
 		return
 	}
-	return flv.ReadPacket(c.ReadTag)
+	return c.pr.ReadPacket()
+}
+
+func (c *Conn) Streams() (streams []av.Stream, err error) {
+	if err = c.Prepare(StageCommandDone, PrepareReading); err != nil {
+		return
+	}
+	return c.pr.Streams()
 }
 
 func (c *Conn) WritePacket(pkt av.Packet) (err error) {
 	if err = c.Prepare(StageDataStart, PrepareWriting); err != nil {
 		return
 	}
-	return flv.WritePacket(pkt, c.WriteTag, c.Publishing)
+	return flv.WritePacket(pkt, c.WriteTag, c.Publishing, c.PeerSupportsFourCC(flvio.FOURCC_HVC1))
+}
+
+func fourCcListAMF(list []string) flvio.AMFArray {
+	arr := flvio.AMFArray{}
+	for _, s := range list {
+		arr = append(arr, s)
+	}
+	return arr
+}
+
+func parseFourCcList(obj flvio.AMFMap) (list []string) {
+	v, _ := obj.GetV("fourCcList")
+	arr, _ := v.(flvio.AMFArray)
+	for _, e := range arr {
+		if s, ok := e.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return
+}
+
+// PeerSupportsFourCC reports whether the peer advertised the codec in its
+// connect fourCcList. "*" means any codec.
+func (c *Conn) PeerSupportsFourCC(fourcc uint32) bool {
+	s := flvio.FourCCString(fourcc)
+	for _, v := range c.PeerFourCcList {
+		if v == s || v == "*" {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *Conn) debugStage(flags int, goturl bool) {
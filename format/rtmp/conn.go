@@ -4,6 +4,7 @@ import (
 	"io"
 	"net/url"
 
+	"github.com/nareix/joy5/format/flv"
 	"github.com/nareix/joy5/format/flv/flvio"
 )
 
@@ -26,6 +27,10 @@ type Conn struct {
 	TcUrl    string
 	FlashVer string
 
+	// Enhanced RTMP codecs advertised in connect and the ones the peer sent back
+	FourCcList     []string
+	PeerFourCcList []string
+
 	PubPlayErr            error
 	PubPlayOnStatusParams flvio.AMFMap
 
@@ -47,6 +52,8 @@ type Conn struct {
 
 	lastcmd *command
 
+	pr *flv.PacketReader
+
 	isserver   bool
 	Publishing bool
 	Stage      Stage
@@ -55,6 +62,8 @@ type Conn struct {
 	BypassMsgtypeid  []uint8
 }
 
+var DefaultFourCcList = []string{"avc1", "hvc1", "av01", "vp09", "mp4a", "Opus"}
+
 func NewConn(rw ReadWriteFlusher) *Conn {
 	c := &Conn{}
 	c.closeNotify = make(chan bool, 1)
@@ -67,6 +76,8 @@ func NewConn(rw ReadWriteFlusher) *Conn {
 	c.readbuf = make([]byte, 256)
 	c.readbuf2 = make([]byte, 256)
 	c.readAckSize = 2500000
+	c.FourCcList = append([]string(nil), DefaultFourCcList...)
+	c.pr = flv.NewPacketReader(c.ReadTag)
 	return c
 }
 
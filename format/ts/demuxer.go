@@ -0,0 +1,434 @@
+package ts
+
+import (
+	"bytes"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/aac"
+	"github.com/nareix/joy5/codec/h264"
+	"github.com/nareix/joy5/format/ts/tsio"
+)
+
+const DefaultProbePackets = 256
+
+type demuxStream struct {
+	pid        uint16
+	streamtype uint8
+	idx        int
+
+	cc  int // -1 before the first packet
+	pes []byte
+
+	sps     *h264.Codec
+	h264cfg []byte
+	h264    *h264.Codec
+
+	aaccfg aac.MPEG4AudioConfig
+	aac    *aac.Codec
+}
+
+func (s *demuxStream) hasConfig() bool {
+	return s.h264 != nil || s.aac != nil
+}
+
+// Demuxer reads H.264 and AAC of the first program. H.264 is converted to
+// AVCC with H264DecoderConfig packets made from inband SPS/PPS, ADTS to raw
+// AAC with AACDecoderConfig packets, both are sent again on change.
+//
+// Times are the 33-bit DTS unwrapped. A continuity counter gap drops the
+// PES being reassembled.
+type Demuxer struct {
+	R io.Reader
+
+	ProbePackets int
+
+	CCErrors int
+
+	b       []byte
+	eof     bool
+	pmtpid  int
+	pmtver  int
+	streams []*demuxStream
+	pending []av.Packet
+
+	tsstarted bool
+	lastts    uint64
+	wrap      int64
+
+	probed bool
+}
+
+func NewDemuxer(r io.Reader) *Demuxer {
+	return &Demuxer{
+		R:            r,
+		ProbePackets: DefaultProbePackets,
+		b:            make([]byte, tsio.PacketSize),
+		pmtpid:       -1,
+		pmtver:       -1,
+	}
+}
+
+func (d *Demuxer) readTSPacket() (err error) {
+	if _, err = io.ReadFull(d.R, d.b); err != nil {
+		return
+	}
+	for d.b[0] != tsio.SYNC_BYTE {
+		// lost sync, start again at the next sync byte
+		i := bytes.IndexByte(d.b[1:], tsio.SYNC_BYTE) + 1
+		if i == 0 {
+			i = len(d.b)
+		}
+		n := copy(d.b, d.b[i:])
+		if _, err = io.ReadFull(d.R, d.b[n:]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (d *Demuxer) stream(pid uint16) *demuxStream {
+	for _, s := range d.streams {
+		if s.pid == pid {
+			return s
+		}
+	}
+	return nil
+}
+
+func (d *Demuxer) handlePMT(payload []byte) {
+	_, _, version, data, err := tsio.ParsePSI(payload)
+	if err != nil || int(version) == d.pmtver {
+		return
+	}
+	var pmt tsio.PMT
+	if pmt, err = tsio.ParsePMT(data); err != nil {
+		return
+	}
+	d.pmtver = int(version)
+
+	streams := []*demuxStream{}
+	nvideo, naudio := 0, 0
+	for _, es := range pmt.Streams {
+		var idx int
+		switch es.StreamType {
+		case tsio.STREAM_TYPE_H264:
+			idx = nvideo
+			nvideo++
+		case tsio.STREAM_TYPE_AAC:
+			idx = naudio
+			naudio++
+		default:
+			continue
+		}
+		s := d.stream(es.PID)
+		if s == nil || s.streamtype != es.StreamType {
+			s = &demuxStream{
+				pid:        es.PID,
+				streamtype: es.StreamType,
+				cc:         -1,
+			}
+		}
+		s.idx = idx
+		streams = append(streams, s)
+	}
+	d.streams = streams
+}
+
+func (d *Demuxer) handleTSPacket() {
+	h, hasPayload, discontinuity, n, err := tsio.ParseTSHeader(d.b)
+	if err != nil || !hasPayload {
+		return
+	}
+	payload := d.b[n:]
+
+	switch {
+	case h.PID == tsio.PAT_PID:
+		if !h.PayloadStart {
+			return
+		}
+		_, _, _, data, err := tsio.ParsePSI(payload)
+		if err != nil {
+			return
+		}
+		pat, _ := tsio.ParsePAT(data)
+		for _, e := range pat.Entries {
+			if e.ProgramNumber != 0 {
+				d.pmtpid = int(e.PID)
+				break
+			}
+		}
+
+	case int(h.PID) == d.pmtpid:
+		if h.PayloadStart {
+			d.handlePMT(payload)
+		}
+
+	default:
+		if s := d.stream(h.PID); s != nil {
+			d.handleES(s, h, discontinuity, payload)
+		}
+	}
+}
+
+func (d *Demuxer) handleES(s *demuxStream, h tsio.TSHeader, discontinuity bool, payload []byte) {
+	cc := int(h.CC)
+	if s.cc >= 0 && !discontinuity {
+		if cc == s.cc {
+			// duplicate packet
+			return
+		}
+		if cc != (s.cc+1)&0xf {
+			d.CCErrors++
+			s.pes = nil
+		}
+	}
+	s.cc = cc
+
+	if h.PayloadStart {
+		d.flushPES(s)
+		s.pes = append([]byte(nil), payload...)
+	} else if s.pes != nil {
+		s.pes = append(s.pes, payload...)
+	}
+
+	// a PES with length is done without waiting for the next one
+	if s.pes != nil {
+		if _, length, _, _, _, n, err := tsio.ParsePESHeader(s.pes); err == nil && length > 0 && len(s.pes) >= n+length {
+			d.flushPES(s)
+		}
+	}
+}
+
+func (d *Demuxer) unwrap(ts uint64) time.Duration {
+	if !d.tsstarted {
+		d.tsstarted = true
+		d.lastts = ts
+	}
+	wrap := d.wrap
+	switch diff := int64(ts) - int64(d.lastts); {
+	case diff < -tsio.MAX_PTS/2:
+		d.wrap += tsio.MAX_PTS
+		wrap = d.wrap
+		d.lastts = ts
+	case diff > tsio.MAX_PTS/2:
+		// from before the last wrap
+		wrap -= tsio.MAX_PTS
+	default:
+		d.lastts = ts
+	}
+	return time.Duration(int64(ts)+wrap) * time.Second / tsio.PTS_HZ
+}
+
+func (d *Demuxer) flushPES(s *demuxStream) {
+	pes := s.pes
+	s.pes = nil
+	if pes == nil {
+		return
+	}
+	_, length, hasPTS, pts, dts, n, err := tsio.ParsePESHeader(pes)
+	if err != nil || !hasPTS {
+		return
+	}
+	data := pes[n:]
+	if length > 0 && length < len(data) {
+		data = data[:length]
+	}
+
+	t := d.unwrap(dts)
+	diff := (int64(pts) - int64(dts)) & (tsio.MAX_PTS - 1)
+	if diff >= tsio.MAX_PTS/2 {
+		diff -= tsio.MAX_PTS
+	}
+	ctime := time.Duration(diff) * time.Second / tsio.PTS_HZ
+
+	switch s.streamtype {
+	case tsio.STREAM_TYPE_H264:
+		d.h264PES(s, t, ctime, data)
+	case tsio.STREAM_TYPE_AAC:
+		d.aacPES(s, t, data)
+	}
+}
+
+func (d *Demuxer) h264PES(s *demuxStream, t, ctime time.Duration, data []byte) {
+	nalus, _ := h264.SplitNALUs(data)
+	frame := [][]byte{}
+	key := false
+	for _, nalu := range nalus {
+		switch h264.NALUType(nalu) {
+		case h264.NALU_AUD:
+		case h264.NALU_SPS, h264.NALU_PPS:
+			if s.sps == nil {
+				s.sps = h264.NewCodec()
+			}
+			s.sps.AddSPSPPS(nalu)
+		case h264.NALU_IDR:
+			key = true
+			frame = append(frame, nalu)
+		default:
+			frame = append(frame, nalu)
+		}
+	}
+
+	if c := s.sps; c != nil && len(c.SPS) > 0 && len(c.PPS) > 0 {
+		n := 0
+		c.ToConfig(nil, &n)
+		cfg := make([]byte, n)
+		n = 0
+		c.ToConfig(cfg, &n)
+		if !bytes.Equal(cfg, s.h264cfg) {
+			if nc, err := h264.FromDecoderConfig(cfg); err == nil {
+				s.h264cfg = cfg
+				s.h264 = nc
+				d.pending = append(d.pending, av.Packet{
+					Type: av.H264DecoderConfig,
+					Idx:  s.idx,
+					Time: t,
+					Data: cfg,
+					H264: nc,
+				})
+			}
+		}
+	}
+
+	// frames before the first SPS/PPS can not be decoded
+	if s.h264 == nil || len(frame) == 0 {
+		return
+	}
+	d.pending = append(d.pending, av.Packet{
+		Type:       av.H264,
+		Idx:        s.idx,
+		Time:       t,
+		CTime:      ctime,
+		IsKeyFrame: key,
+		Data:       h264.JoinNALUsAVCC(frame),
+		H264:       s.h264,
+	})
+}
+
+func (d *Demuxer) aacPES(s *demuxStream, t time.Duration, data []byte) {
+	for len(data) >= aac.ADTSHeaderLength {
+		config, hdrlen, framelen, samples, err := aac.ParseADTSHeader(data)
+		if err != nil || framelen > len(data) {
+			return
+		}
+
+		if s.aac == nil || config != s.aaccfg {
+			buf := &bytes.Buffer{}
+			if err = aac.WriteMPEG4AudioConfig(buf, config); err != nil {
+				return
+			}
+			var c *aac.Codec
+			if c, err = aac.FromMPEG4AudioConfigBytes(buf.Bytes()); err != nil {
+				return
+			}
+			s.aaccfg = config
+			s.aac = c
+			d.pending = append(d.pending, av.Packet{
+				Type: av.AACDecoderConfig,
+				Idx:  s.idx,
+				Time: t,
+				Data: c.ConfigBytes,
+				AAC:  c,
+			})
+		}
+
+		d.pending = append(d.pending, av.Packet{
+			Type: av.AAC,
+			Idx:  s.idx,
+			Time: t,
+			Data: data[hdrlen:framelen],
+			AAC:  s.aac,
+		})
+		t += time.Duration(samples) * time.Second / time.Duration(config.SampleRate)
+		data = data[framelen:]
+	}
+}
+
+func (d *Demuxer) readMore() (err error) {
+	if d.eof {
+		err = io.EOF
+		return
+	}
+	if err = d.readTSPacket(); err != nil {
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			return
+		}
+		err = nil
+		d.eof = true
+		for _, s := range d.streams {
+			d.flushPES(s)
+		}
+		return
+	}
+	d.handleTSPacket()
+	return
+}
+
+func (d *Demuxer) probeDone() bool {
+	if len(d.pending) >= d.ProbePackets {
+		return true
+	}
+	if d.pmtver < 0 {
+		return false
+	}
+	for _, s := range d.streams {
+		if !s.hasConfig() {
+			return false
+		}
+	}
+	return true
+}
+
+// Streams reads until every stream of PMT has a config. Packets read while
+// probing are returned by ReadPacket afterwards.
+func (d *Demuxer) Streams() (streams []av.Stream, err error) {
+	if !d.probed {
+		d.probed = true
+		for !d.probeDone() {
+			if err = d.readMore(); err != nil {
+				break
+			}
+		}
+	}
+
+	for _, s := range d.streams {
+		pkt := av.Packet{}
+		switch {
+		case s.h264 != nil:
+			pkt = av.Packet{Type: av.H264DecoderConfig, Data: s.h264cfg}
+		case s.aac != nil:
+			pkt = av.Packet{Type: av.AACDecoderConfig, Data: s.aac.ConfigBytes}
+		default:
+			continue
+		}
+		if c, cerr := av.CodecDataFromConfig(pkt); cerr == nil {
+			streams = append(streams, av.Stream{Idx: s.idx, CodecData: c})
+		}
+	}
+	sort.SliceStable(streams, func(i, j int) bool {
+		a, b := streams[i], streams[j]
+		if a.IsVideo() != b.IsVideo() {
+			return a.IsVideo()
+		}
+		return a.Idx < b.Idx
+	})
+
+	if len(streams) > 0 {
+		err = nil
+	}
+	return
+}
+
+func (d *Demuxer) ReadPacket() (pkt av.Packet, err error) {
+	for len(d.pending) == 0 {
+		if err = d.readMore(); err != nil {
+			return
+		}
+	}
+	pkt = d.pending[0]
+	d.pending = d.pending[1:]
+	return
+}
@@ -0,0 +1,185 @@
+package ts
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/aac"
+	"github.com/nareix/joy5/format/ts/tsio"
+)
+
+func demux(t *testing.T, d *Demuxer) (pkts []av.Packet) {
+	for {
+		pkt, err := d.ReadPacket()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		pkts = append(pkts, pkt)
+	}
+}
+
+// tracks splits packets by type, frames of a track in order.
+func tracks(pkts []av.Packet) map[int][]av.Packet {
+	m := map[int][]av.Packet{}
+	for _, pkt := range pkts {
+		m[pkt.Type] = append(m[pkt.Type], pkt)
+	}
+	return m
+}
+
+// shift returns the packets with times as written by the muxer.
+func shift(pkts []av.Packet) (out []av.Packet) {
+	for _, pkt := range pkts {
+		pkt.Time += TimeOffset
+		out = append(out, pkt)
+	}
+	return
+}
+
+func checkFrames(t *testing.T, got, want []av.Packet) {
+	if len(got) != len(want) {
+		t.Fatalf("%d %s frames, want %d", len(got), av.PacketTypeString[want[0].Type], len(want))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if g.Time != w.Time || g.CTime != w.CTime || g.IsKeyFrame != w.IsKeyFrame || !bytes.Equal(g.Data, w.Data) {
+			t.Fatalf("frame %d %s, want %s", i, g.String(), w.String())
+		}
+	}
+}
+
+func TestDemuxerRoundtrip(t *testing.T) {
+	in := testStream(10 * time.Second)
+	d := NewDemuxer(bytes.NewReader(mux(t, in)))
+
+	streams, err := d.Streams()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(streams) != 2 || !streams[0].IsVideo() || streams[0].Width != 1280 || streams[1].SampleRate != 48000 {
+		t.Fatalf("streams %v", streams)
+	}
+
+	got, want := tracks(demux(t, d)), tracks(shift(in))
+	if len(got[av.H264DecoderConfig]) != 1 || !bytes.Equal(got[av.H264DecoderConfig][0].Data, want[av.H264DecoderConfig][0].Data) {
+		t.Fatalf("h264 config %v", got[av.H264DecoderConfig])
+	}
+	if len(got[av.AACDecoderConfig]) != 1 {
+		t.Fatalf("aac config %v", got[av.AACDecoderConfig])
+	}
+	checkFrames(t, got[av.H264], want[av.H264])
+	checkFrames(t, got[av.AAC], want[av.AAC])
+	if d.CCErrors != 0 {
+		t.Fatalf("%d cc errors", d.CCErrors)
+	}
+}
+
+// tsPackets returns the offsets of packets of pid, the ones starting a PES
+// if start is set.
+func tsPackets(b []byte, pid uint16, start bool) (offs []int) {
+	for off := 0; off < len(b); off += tsio.PacketSize {
+		h, _, _, _, _ := tsio.ParseTSHeader(b[off:])
+		if h.PID == pid && (!start || h.PayloadStart) {
+			offs = append(offs, off)
+		}
+	}
+	return
+}
+
+func TestDemuxerDamaged(t *testing.T) {
+	// DTS wraps about 200ms in, on a whole nanosecond
+	start := tsio.TsToTime(tsio.MAX_PTS-18008) - TimeOffset
+	in := testStream(start)
+	b := mux(t, in)
+
+	// drop the second packet of video frame 2
+	drop := tsPackets(b, firstPID, true)[2] + tsio.PacketSize
+	// duplicate the first packet of audio frame 3
+	dup := tsPackets(b, firstPID+1, true)[3]
+	var damaged []byte
+	for off := 0; off < len(b); off += tsio.PacketSize {
+		pkt := b[off : off+tsio.PacketSize]
+		if off == drop {
+			continue
+		}
+		damaged = append(damaged, pkt...)
+		if off == dup {
+			damaged = append(damaged, pkt...)
+		}
+	}
+
+	d := NewDemuxer(bytes.NewReader(damaged))
+	got, want := tracks(demux(t, d)), tracks(shift(in))
+	if d.CCErrors != 1 {
+		t.Fatalf("%d cc errors", d.CCErrors)
+	}
+	// times continue over the wrap
+	video := want[av.H264]
+	checkFrames(t, got[av.H264], append(video[:2:2], video[3:]...))
+	checkFrames(t, got[av.AAC], want[av.AAC])
+}
+
+func TestDemuxerSPSChange(t *testing.T) {
+	sps2 := append([]byte(nil), testSPS...)
+	sps2[3] = 0x28
+	in := testStream(0)
+	// new SPS at the second keyframe
+	var changed []av.Packet
+	for _, pkt := range in {
+		if pkt.Type == av.H264 && pkt.IsKeyFrame && pkt.Time > 0 {
+			changed = append(changed, av.Packet{Type: av.H264DecoderConfig, Time: pkt.Time, Data: testH264Config(sps2)})
+		}
+		changed = append(changed, pkt)
+	}
+
+	var cfgs []string
+	for _, pkt := range demux(t, NewDemuxer(bytes.NewReader(mux(t, changed)))) {
+		if pkt.Type == av.H264DecoderConfig {
+			cfgs = append(cfgs, fmt.Sprintf("%v %x", pkt.Time, pkt.Data[3]))
+		}
+	}
+	// the same SPS on the next keyframes sends no config
+	if fmt.Sprint(cfgs) != "[1.4s 1f 1.6s 28]" {
+		t.Fatalf("configs %q", cfgs)
+	}
+}
+
+func TestDemuxerADTSFrames(t *testing.T) {
+	// two ADTS frames in one PES over several TS packets
+	buf := &bytes.Buffer{}
+	m := NewMuxer(buf)
+	s := m.stream(av.AAC, 0)
+	if err := m.writePSI(); err != nil {
+		t.Fatal(err)
+	}
+	config := aac.MPEG4AudioConfig{ObjectType: aac.AOT_AAC_LC, SampleRateIndex: 3, ChannelConfig: 2}
+	var data []byte
+	for i := 0; i < 2; i++ {
+		frame := make([]byte, aac.ADTSHeaderLength+150)
+		aac.FillADTSHeader(frame, config, 1024, 150)
+		frame[aac.ADTSHeaderLength] = byte(i)
+		data = append(data, frame...)
+	}
+	ts := tsio.TimeToTs(time.Second)
+	hdr := make([]byte, tsio.FillPESHeader(nil, s.streamid, len(data), ts, ts))
+	tsio.FillPESHeader(hdr, s.streamid, len(data), ts, ts)
+	if err := m.writePES(s, hdr, data, tsio.TSHeader{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, pkt := range demux(t, NewDemuxer(buf)) {
+		got = append(got, fmt.Sprintf("%s %d %d", pkt.String(), pkt.Data[0], len(pkt.Data)))
+	}
+	want := []string{"AACDecoderConfig 1s 2 17 2", "AAC 1s 150 0 150", "AAC 1.021333333s 150 1 150"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,313 @@
+package ts
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/aac"
+	"github.com/nareix/joy5/codec/h264"
+	"github.com/nareix/joy5/format/ts/tsio"
+)
+
+const firstPID = 0x100
+
+// PSIInterval is how often PAT/PMT are repeated when there is no video,
+// with video they are written before every keyframe.
+const PSIInterval = time.Second
+
+// PCRDelay is how far PCR is behind DTS, the time a decoder has to buffer a
+// frame before it is due.
+const PCRDelay = 700 * time.Millisecond
+
+// TimeOffset is added to PTS and DTS, as FFmpeg does, so that PCR starts
+// above zero for streams starting at zero.
+const TimeOffset = 1400 * time.Millisecond
+
+var audNALU = []byte{h264.NALU_AUD, 0xf0}
+
+type muxStream struct {
+	video      bool
+	idx        int
+	typ        int
+	streamtype uint8
+	streamid   uint8
+	pid        uint16
+	cc         uint8
+
+	h264 *h264.Codec
+	aac  *aac.MPEG4AudioConfig
+}
+
+type Muxer struct {
+	W io.Writer
+
+	streams  []*muxStream
+	version  uint8
+	psidirty bool
+	lastpsi  time.Duration
+	patcc    uint8
+	pmtcc    uint8
+	b        []byte
+}
+
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{
+		W:        w,
+		psidirty: true,
+	}
+}
+
+func streamType(typ int) (streamtype uint8, ok bool) {
+	switch typ {
+	case av.H264:
+		return tsio.STREAM_TYPE_H264, true
+	case av.AAC:
+		return tsio.STREAM_TYPE_AAC, true
+	}
+	return
+}
+
+// SetStreams adds the streams to PMT before any packet is written.
+func (m *Muxer) SetStreams(streams []av.Stream) {
+	for _, s := range streams {
+		if _, ok := streamType(s.Type); ok {
+			m.stream(s.Type, s.Idx)
+		}
+	}
+}
+
+// stream finds the stream of a frame type, adding it or changing its codec
+// updates PMT.
+func (m *Muxer) stream(typ int, idx int) *muxStream {
+	video := av.IsVideo(typ)
+	var same *muxStream
+	n := 0
+	for _, s := range m.streams {
+		if s.video == video {
+			if s.idx == idx {
+				same = s
+			}
+			n++
+		}
+	}
+	streamtype, _ := streamType(typ)
+
+	if same != nil {
+		if same.typ != typ {
+			same.typ = typ
+			same.streamtype = streamtype
+			m.psidirty = true
+		}
+		return same
+	}
+
+	s := &muxStream{
+		video:      video,
+		idx:        idx,
+		typ:        typ,
+		streamtype: streamtype,
+		pid:        firstPID + uint16(len(m.streams)),
+	}
+	if video {
+		s.streamid = tsio.STREAM_ID_VIDEO + uint8(n)
+	} else {
+		s.streamid = tsio.STREAM_ID_AUDIO + uint8(n)
+	}
+	m.streams = append(m.streams, s)
+	m.psidirty = true
+	return s
+}
+
+func (m *Muxer) pcrStream() *muxStream {
+	for _, s := range m.streams {
+		if s.video {
+			return s
+		}
+	}
+	return m.streams[0]
+}
+
+func (m *Muxer) appendSection(pid uint16, cc *uint8, tableid uint8, data []byte) {
+	pkt := make([]byte, tsio.PacketSize)
+	h := tsio.TSHeader{PID: pid, PayloadStart: true, CC: *cc}
+	n := tsio.FillTSHeader(pkt, h, tsio.PacketSize-4)
+	n += tsio.FillPSI(pkt[n:], tableid, 1, m.version, data)
+	for ; n < len(pkt); n++ {
+		pkt[n] = 0xff
+	}
+	*cc++
+	m.b = append(m.b, pkt...)
+}
+
+func (m *Muxer) writePSI() (err error) {
+	if m.psidirty {
+		m.version++
+		m.psidirty = false
+	}
+
+	pat := tsio.PAT{
+		Entries: []tsio.PATEntry{{ProgramNumber: 1, PID: tsio.PMT_PID}},
+	}
+	pmt := tsio.PMT{
+		PCRPID: m.pcrStream().pid,
+	}
+	for _, s := range m.streams {
+		pmt.Streams = append(pmt.Streams, tsio.PMTStream{StreamType: s.streamtype, PID: s.pid})
+	}
+
+	m.b = m.b[:0]
+	patdata := make([]byte, pat.Fill(nil))
+	pat.Fill(patdata)
+	m.appendSection(tsio.PAT_PID, &m.patcc, tsio.TABLE_ID_PAT, patdata)
+	pmtdata := make([]byte, pmt.Fill(nil))
+	pmt.Fill(pmtdata)
+	m.appendSection(tsio.PMT_PID, &m.pmtcc, tsio.TABLE_ID_PMT, pmtdata)
+
+	_, err = m.W.Write(m.b)
+	return
+}
+
+func (m *Muxer) writePES(s *muxStream, hdr []byte, data []byte, h tsio.TSHeader) (err error) {
+	m.b = m.b[:0]
+	h.PID = s.pid
+	h.PayloadStart = true
+	payload := [][]byte{hdr, data}
+	left := len(hdr) + len(data)
+
+	for left > 0 {
+		n := tsio.PacketSize - tsio.TSHeaderLength(h)
+		if n > left {
+			n = left
+		}
+		h.CC = s.cc
+		s.cc++
+
+		pkt := make([]byte, tsio.PacketSize)
+		off := tsio.FillTSHeader(pkt, h, n)
+		for off < tsio.PacketSize {
+			c := copy(pkt[off:], payload[0])
+			payload[0] = payload[0][c:]
+			if len(payload[0]) == 0 {
+				payload = payload[1:]
+			}
+			off += c
+		}
+		m.b = append(m.b, pkt...)
+
+		left -= n
+		h = tsio.TSHeader{PID: s.pid}
+	}
+
+	_, err = m.W.Write(m.b)
+	return
+}
+
+func (m *Muxer) h264Frame(s *muxStream, pkt av.Packet) []byte {
+	nalus, _ := h264.SplitNALUs(pkt.Data)
+	out := [][]byte{audNALU}
+	hasps := false
+	for _, nalu := range nalus {
+		switch h264.NALUType(nalu) {
+		case h264.NALU_SPS, h264.NALU_PPS:
+			hasps = true
+		}
+	}
+	c := s.h264
+	if c == nil {
+		c = pkt.H264
+	}
+	if pkt.IsKeyFrame && !hasps && c != nil {
+		out = append(out, h264.Map2arr(c.SPS)...)
+		out = append(out, h264.Map2arr(c.PPS)...)
+	}
+	for _, nalu := range nalus {
+		if h264.NALUType(nalu) != h264.NALU_AUD {
+			out = append(out, nalu)
+		}
+	}
+	return h264.JoinNALUsAnnexb(out)
+}
+
+func (m *Muxer) aacFrame(s *muxStream, pkt av.Packet) (b []byte, err error) {
+	config := s.aac
+	if config == nil && pkt.AAC != nil {
+		config = &pkt.AAC.Config
+	}
+	if config == nil {
+		err = fmt.Errorf("ts: aac frame without config")
+		return
+	}
+	b = make([]byte, aac.ADTSHeaderLength+len(pkt.Data))
+	aac.FillADTSHeader(b, *config, config.FrameSamples(), len(pkt.Data))
+	copy(b[aac.ADTSHeaderLength:], pkt.Data)
+	return
+}
+
+func (m *Muxer) WritePacket(pkt av.Packet) (err error) {
+	switch pkt.Type {
+	case av.Metadata:
+		return
+
+	case av.H264DecoderConfig:
+		var c *h264.Codec
+		if c, err = h264.FromDecoderConfig(pkt.Data); err != nil {
+			return
+		}
+		m.stream(av.H264, pkt.Idx).h264 = c
+		return
+
+	case av.AACDecoderConfig:
+		var config aac.MPEG4AudioConfig
+		if config, err = aac.ParseMPEG4AudioConfigBytes(pkt.Data); err != nil {
+			return
+		}
+		m.stream(av.AAC, pkt.Idx).aac = &config
+		return
+	}
+
+	if _, ok := streamType(pkt.Type); !ok {
+		err = fmt.Errorf("ts: can not write %s", av.PacketTypeString[pkt.Type])
+		return
+	}
+	s := m.stream(pkt.Type, pkt.Idx)
+	pcrs := m.pcrStream()
+
+	needpsi := m.psidirty
+	if pcrs.video {
+		needpsi = needpsi || s == pcrs && pkt.IsKeyFrame
+	} else {
+		needpsi = needpsi || pkt.Time-m.lastpsi >= PSIInterval
+	}
+	if needpsi {
+		if err = m.writePSI(); err != nil {
+			return
+		}
+		m.lastpsi = pkt.Time
+	}
+
+	var data []byte
+	h := tsio.TSHeader{}
+	if s.video {
+		data = m.h264Frame(s, pkt)
+		h.RandomAccess = pkt.IsKeyFrame
+	} else {
+		if data, err = m.aacFrame(s, pkt); err != nil {
+			return
+		}
+		h.RandomAccess = !pcrs.video
+	}
+
+	t := pkt.Time + TimeOffset
+	dts := tsio.TimeToTs(t)
+	pts := tsio.TimeToTs(t + pkt.CTime)
+	if s == pcrs {
+		h.HasPCR = true
+		h.PCR = tsio.TimeToTs(t - PCRDelay)
+	}
+
+	hdr := make([]byte, tsio.FillPESHeader(nil, s.streamid, len(data), pts, dts))
+	tsio.FillPESHeader(hdr, s.streamid, len(data), pts, dts)
+	return m.writePES(s, hdr, data, h)
+}
@@ -0,0 +1,179 @@
+package ts
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nareix/joy5/av"
+	"github.com/nareix/joy5/codec/aac"
+	"github.com/nareix/joy5/codec/h264"
+	"github.com/nareix/joy5/format/ts/tsio"
+)
+
+var (
+	testSPS = []byte{0x67, 0x64, 0x00, 0x1f, 0xac, 0xd9, 0x40, 0x50, 0x05, 0xbb, 0x01, 0x10, 0x00, 0x00, 0x03, 0x00, 0x10, 0x00, 0x00, 0x03, 0x03, 0xc0, 0xf1, 0x83, 0x19, 0x60}
+	testPPS = []byte{0x68, 0xeb, 0xe3, 0xcb, 0x22, 0xc0}
+)
+
+func testH264Config(sps []byte) []byte {
+	c := h264.NewCodec()
+	c.AddSPSPPS(sps)
+	c.AddSPSPPS(testPPS)
+	n := 0
+	c.ToConfig(nil, &n)
+	b := make([]byte, n)
+	n = 0
+	c.ToConfig(b, &n)
+	return b
+}
+
+// testStream has a keyframe every 5 frames at 25fps with B-frame CTime,
+// and 48kHz AAC of 960 samples per frame. Frames are big enough to span
+// several TS packets.
+func testStream(start time.Duration) (pkts []av.Packet) {
+	pkts = append(pkts,
+		av.Packet{Type: av.H264DecoderConfig, Data: testH264Config(testSPS)},
+		av.Packet{Type: av.AACDecoderConfig, Data: []byte{0x11, 0x94}},
+	)
+	adur := 20 * time.Millisecond
+	at := start
+	for i := 0; i < 10; i++ {
+		vt := start + time.Duration(i)*40*time.Millisecond
+		for ; at < vt; at += adur {
+			pkts = append(pkts, av.Packet{Type: av.AAC, Time: at, Data: bytes.Repeat([]byte{byte(i)}, 300)})
+		}
+		key := i%5 == 0
+		nalu := []byte{0x41}
+		if key {
+			nalu = []byte{0x65}
+		}
+		nalu = append(nalu, bytes.Repeat([]byte{byte(i)}, 400)...)
+		pkts = append(pkts, av.Packet{
+			Type:       av.H264,
+			IsKeyFrame: key,
+			Time:       vt,
+			CTime:      80 * time.Millisecond,
+			Data:       h264.JoinNALUsAVCC([][]byte{nalu}),
+		})
+	}
+	return
+}
+
+func mux(t *testing.T, pkts []av.Packet) []byte {
+	buf := &bytes.Buffer{}
+	m := NewMuxer(buf)
+	for _, pkt := range pkts {
+		if err := m.WritePacket(pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestMuxer(t *testing.T) {
+	start := 10 * time.Second
+	b := mux(t, testStream(start))
+	if len(b)%tsio.PacketSize != 0 {
+		t.Fatalf("%d bytes", len(b))
+	}
+
+	cc := map[uint16]uint8{}
+	var pmt tsio.PMT
+	npsi, nvideo, naudio := 0, 0, 0
+	for off := 0; off < len(b); off += tsio.PacketSize {
+		h, _, _, n, err := tsio.ParseTSHeader(b[off:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if last, ok := cc[h.PID]; ok && h.CC != (last+1)&0xf {
+			t.Fatalf("pid %x cc %d after %d", h.PID, h.CC, last)
+		}
+		cc[h.PID] = h.CC
+		payload := b[off+n : off+tsio.PacketSize]
+
+		switch h.PID {
+		case tsio.PAT_PID:
+			npsi++
+		case tsio.PMT_PID:
+			_, _, _, data, err := tsio.ParsePSI(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if pmt, err = tsio.ParsePMT(data); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !h.PayloadStart || h.PID != firstPID && h.PID != firstPID+1 {
+			continue
+		}
+
+		_, _, _, pts, dts, hn, err := tsio.ParsePESHeader(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch h.PID {
+		case firstPID:
+			if !h.HasPCR || h.PCR != tsio.TimeToTs(tsio.TsToTime(dts)-PCRDelay) {
+				t.Fatalf("video pcr %v %d dts %d", h.HasPCR, h.PCR, dts)
+			}
+			if want := tsio.TimeToTs(start + TimeOffset + time.Duration(nvideo)*40*time.Millisecond); dts != want || pts != dts+7200 {
+				t.Fatalf("video %d pts %d dts %d", nvideo, pts, dts)
+			}
+			if h.RandomAccess != (nvideo%5 == 0) {
+				t.Fatalf("video %d random access %v", nvideo, h.RandomAccess)
+			}
+			nvideo++
+
+		case firstPID + 1:
+			config, hdrlen, framelen, _, err := aac.ParseADTSHeader(payload[hn:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.SampleRate != 48000 || config.ChannelConfig != 2 || framelen-hdrlen != 300 || payload[hn+6]&0x3 != 0 {
+				t.Fatalf("adts %+v framelen %d rdbs %d", config, framelen, payload[hn+6]&0x3)
+			}
+			if h.HasPCR || pts != dts {
+				t.Fatalf("audio pcr %v pts %d dts %d", h.HasPCR, pts, dts)
+			}
+			naudio++
+		}
+	}
+
+	if len(pmt.Streams) != 2 || pmt.PCRPID != firstPID ||
+		pmt.Streams[0].StreamType != tsio.STREAM_TYPE_H264 || pmt.Streams[1].StreamType != tsio.STREAM_TYPE_AAC {
+		t.Fatalf("pmt %+v", pmt)
+	}
+	// before each keyframe
+	if npsi != 2 || nvideo != 10 || naudio != 18 {
+		t.Fatalf("%d psi %d video %d audio", npsi, nvideo, naudio)
+	}
+}
+
+func TestMuxerFirstPCR(t *testing.T) {
+	// PCR stays above zero and behind DTS from the first packet
+	b := mux(t, testStream(0))
+	first := true
+	for off := 0; off < len(b); off += tsio.PacketSize {
+		h, _, _, n, _ := tsio.ParseTSHeader(b[off:])
+		if !h.HasPCR {
+			continue
+		}
+		_, _, _, _, dts, _, err := tsio.ParsePESHeader(b[off+n : off+tsio.PacketSize])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first {
+			if want := tsio.TimeToTs(TimeOffset - PCRDelay); h.PCR != want || dts != tsio.TimeToTs(TimeOffset) {
+				t.Fatalf("first pcr %d dts %d, want %d", h.PCR, dts, want)
+			}
+			first = false
+		}
+		if h.PCR >= dts || dts-h.PCR != tsio.TimeToTs(PCRDelay) {
+			t.Fatalf("pcr %d dts %d", h.PCR, dts)
+		}
+	}
+	if first {
+		t.Fatal("no pcr")
+	}
+}
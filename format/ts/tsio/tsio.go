@@ -0,0 +1,358 @@
+package tsio
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nareix/joy5/utils/bits/pio"
+)
+
+const (
+	PacketSize = 188
+	SYNC_BYTE  = 0x47
+
+	PAT_PID = 0x0
+	PMT_PID = 0x1000
+
+	TABLE_ID_PAT = 0x0
+	TABLE_ID_PMT = 0x2
+
+	STREAM_TYPE_AAC  = 0x0f
+	STREAM_TYPE_H264 = 0x1b
+	STREAM_TYPE_H265 = 0x24
+
+	STREAM_ID_AUDIO = 0xc0
+	STREAM_ID_VIDEO = 0xe0
+)
+
+const PTS_HZ = 90000
+const MAX_PTS = 1 << 33
+
+func TimeToTs(t time.Duration) uint64 {
+	return uint64(int64(t)*PTS_HZ/int64(time.Second)) & (MAX_PTS - 1)
+}
+
+func TsToTime(ts uint64) time.Duration {
+	return time.Duration(ts) * time.Second / PTS_HZ
+}
+
+var crc32Table = func() (t [256]uint32) {
+	for i := range t {
+		k := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if k&0x80000000 != 0 {
+				k = k<<1 ^ 0x04c11db7
+			} else {
+				k <<= 1
+			}
+		}
+		t[i] = k
+	}
+	return
+}()
+
+// CRC32 is the MPEG-2 CRC of PSI sections.
+func CRC32(b []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, v := range b {
+		crc = crc<<8 ^ crc32Table[byte(crc>>24)^v]
+	}
+	return crc
+}
+
+type PATEntry struct {
+	ProgramNumber uint16
+	PID           uint16
+}
+
+type PAT struct {
+	Entries []PATEntry
+}
+
+func (p PAT) Fill(b []byte) (n int) {
+	for _, e := range p.Entries {
+		pio.WriteU16BE(b, &n, e.ProgramNumber)
+		pio.WriteU16BE(b, &n, e.PID|0xe000)
+	}
+	return
+}
+
+func ParsePAT(b []byte) (p PAT, err error) {
+	for n := 0; n+4 <= len(b); n += 4 {
+		p.Entries = append(p.Entries, PATEntry{
+			ProgramNumber: pio.U16BE(b[n:]),
+			PID:           pio.U16BE(b[n+2:]) & 0x1fff,
+		})
+	}
+	return
+}
+
+type PMTStream struct {
+	StreamType uint8
+	PID        uint16
+}
+
+type PMT struct {
+	PCRPID  uint16
+	Streams []PMTStream
+}
+
+func (p PMT) Fill(b []byte) (n int) {
+	pio.WriteU16BE(b, &n, p.PCRPID|0xe000)
+	pio.WriteU16BE(b, &n, 0xf000) // program_info_length
+	for _, s := range p.Streams {
+		pio.WriteU8(b, &n, s.StreamType)
+		pio.WriteU16BE(b, &n, s.PID|0xe000)
+		pio.WriteU16BE(b, &n, 0xf000) // ES_info_length
+	}
+	return
+}
+
+func ParsePMT(b []byte) (p PMT, err error) {
+	n := 0
+	var v uint16
+	if v, err = pio.ReadU16BE(b, &n); err != nil {
+		return
+	}
+	p.PCRPID = v & 0x1fff
+	if v, err = pio.ReadU16BE(b, &n); err != nil {
+		return
+	}
+	n += int(v & 0x3ff)
+
+	for n < len(b) {
+		var s PMTStream
+		if s.StreamType, err = pio.ReadU8(b, &n); err != nil {
+			return
+		}
+		if v, err = pio.ReadU16BE(b, &n); err != nil {
+			return
+		}
+		s.PID = v & 0x1fff
+		if v, err = pio.ReadU16BE(b, &n); err != nil {
+			return
+		}
+		n += int(v & 0x3ff)
+		p.Streams = append(p.Streams, s)
+	}
+	return
+}
+
+// FillPSI writes a PSI section with the pointer field and CRC, data is the
+// table after last_section_number. Returns the length with nil b.
+func FillPSI(b []byte, tableid uint8, tableext uint16, version uint8, data []byte) (n int) {
+	pio.WriteU8(b, &n, 0) // pointer_field
+	start := n
+	// section_syntax_indicator, section_length from table_id_extension to CRC
+	pio.WriteU8(b, &n, tableid)
+	pio.WriteU16BE(b, &n, 0xb000|uint16(5+len(data)+4))
+	pio.WriteU16BE(b, &n, tableext)
+	pio.WriteU8(b, &n, 0xc1|(version&0x1f)<<1) // current_next_indicator
+	pio.WriteU8(b, &n, 0)                      // section_number
+	pio.WriteU8(b, &n, 0)                      // last_section_number
+	pio.WriteBytes(b, &n, data)
+	if b != nil {
+		pio.PutU32BE(b[n:], CRC32(b[start:n]))
+	}
+	n += 4
+	return
+}
+
+// ParsePSI parses a section starting with the pointer field, data is the
+// table after last_section_number.
+func ParsePSI(b []byte) (tableid uint8, tableext uint16, version uint8, data []byte, err error) {
+	if len(b) < 1 || len(b) < 1+int(b[0])+3 {
+		err = fmt.Errorf("tsio: psi too short")
+		return
+	}
+	b = b[1+int(b[0]):]
+	tableid = b[0]
+	length := int(pio.U16BE(b[1:]) & 0xfff)
+	if length < 9 || 3+length > len(b) {
+		err = fmt.Errorf("tsio: psi section length %d invalid", length)
+		return
+	}
+	section := b[:3+length]
+	if CRC32(section) != 0 {
+		err = fmt.Errorf("tsio: psi crc mismatch")
+		return
+	}
+	tableext = pio.U16BE(section[3:])
+	version = section[5] >> 1 & 0x1f
+	data = section[8 : len(section)-4]
+	return
+}
+
+type TSHeader struct {
+	PID          uint16
+	PayloadStart bool
+	CC           uint8
+	RandomAccess bool
+	HasPCR       bool
+	PCR          uint64 // 90khz
+}
+
+// ParseTSHeader parses the header and adaptation field, n is where the
+// payload starts.
+func ParseTSHeader(b []byte) (h TSHeader, hasPayload bool, discontinuity bool, n int, err error) {
+	if len(b) < PacketSize || b[0] != SYNC_BYTE {
+		err = fmt.Errorf("tsio: sync byte not found")
+		return
+	}
+	flags := pio.U16BE(b[1:])
+	h.PID = flags & 0x1fff
+	h.PayloadStart = flags&0x4000 != 0
+	ctrl := b[3]
+	h.CC = ctrl & 0xf
+	hasPayload = ctrl&0x10 != 0
+	n = 4
+
+	if ctrl&0x20 != 0 {
+		aflen := int(b[n])
+		n++
+		if n+aflen > PacketSize {
+			err = fmt.Errorf("tsio: adaptation field length %d invalid", aflen)
+			return
+		}
+		if aflen > 0 {
+			afflags := b[n]
+			discontinuity = afflags&0x80 != 0
+			h.RandomAccess = afflags&0x40 != 0
+			if afflags&0x10 != 0 && aflen >= 7 {
+				h.HasPCR = true
+				h.PCR = pio.U48BE(b[n+1:]) >> 15
+			}
+		}
+		n += aflen
+	}
+	return
+}
+
+// TSHeaderLength is the smallest header for h.
+func TSHeaderLength(h TSHeader) int {
+	n := 4
+	if h.RandomAccess || h.HasPCR {
+		n += 2
+	}
+	if h.HasPCR {
+		n += 6
+	}
+	return n
+}
+
+// FillTSHeader writes the header of a packet carrying payloadlen bytes,
+// stuffing the adaptation field to fill the packet. payloadlen must not be
+// more than PacketSize-TSHeaderLength(h).
+func FillTSHeader(b []byte, h TSHeader, payloadlen int) (n int) {
+	pio.WriteU8(b, &n, SYNC_BYTE)
+	flags := h.PID & 0x1fff
+	if h.PayloadStart {
+		flags |= 0x4000
+	}
+	pio.WriteU16BE(b, &n, flags)
+
+	aflen := PacketSize - 4 - payloadlen
+	ctrl := uint8(0x10) // payload only
+	if aflen > 0 {
+		ctrl = 0x30
+	}
+	pio.WriteU8(b, &n, ctrl|h.CC&0xf)
+	if aflen == 0 {
+		return
+	}
+
+	pio.WriteU8(b, &n, uint8(aflen-1))
+	if aflen == 1 {
+		return
+	}
+	var afflags uint8
+	if h.RandomAccess {
+		afflags |= 0x40
+	}
+	if h.HasPCR {
+		afflags |= 0x10
+	}
+	pio.WriteU8(b, &n, afflags)
+	if h.HasPCR {
+		pio.PutU48BE(b[n:], (h.PCR&(MAX_PTS-1))<<15|0x3f<<9)
+		n += 6
+	}
+	for n < PacketSize-payloadlen {
+		pio.WriteU8(b, &n, 0xff)
+	}
+	return
+}
+
+func writePTS(b []byte, n *int, prefix uint8, ts uint64) {
+	pio.WriteU8(b, n, prefix<<4|uint8(ts>>29)&0xe|1)
+	pio.WriteU16BE(b, n, uint16(ts>>14)&0xfffe|1)
+	pio.WriteU16BE(b, n, uint16(ts<<1)&0xfffe|1)
+}
+
+// FillPESHeader writes a PES header for datalen bytes of payload. Video PES
+// length is left 0, as is any PES longer than 0xffff.
+func FillPESHeader(b []byte, streamid uint8, datalen int, pts, dts uint64) (n int) {
+	hdrlen := 5
+	ptsflags := uint8(0x80)
+	if dts != pts {
+		hdrlen = 10
+		ptsflags = 0xc0
+	}
+
+	pio.WriteU24BE(b, &n, 0x000001)
+	pio.WriteU8(b, &n, streamid)
+	length := 3 + hdrlen + datalen
+	if streamid&0xf0 == STREAM_ID_VIDEO || length > 0xffff {
+		length = 0
+	}
+	pio.WriteU16BE(b, &n, uint16(length))
+	pio.WriteU8(b, &n, 0x80) // marker bits
+	pio.WriteU8(b, &n, ptsflags)
+	pio.WriteU8(b, &n, uint8(hdrlen))
+	if dts != pts {
+		writePTS(b, &n, 0x3, pts)
+		writePTS(b, &n, 0x1, dts)
+	} else {
+		writePTS(b, &n, 0x2, pts)
+	}
+	return
+}
+
+func readPTS(b []byte) uint64 {
+	return uint64(b[0]>>1&0x7)<<30 | uint64(pio.U16BE(b[1:])>>1)<<15 | uint64(pio.U16BE(b[3:])>>1)
+}
+
+// ParsePESHeader parses a PES header, n is where the payload starts and
+// length the PES payload length, 0 if unbounded. dts is pts when the header
+// has no dts.
+func ParsePESHeader(b []byte) (streamid uint8, length int, hasPTS bool, pts, dts uint64, n int, err error) {
+	if len(b) < 9 || pio.U24BE(b) != 0x000001 {
+		err = fmt.Errorf("tsio: pes start code not found")
+		return
+	}
+	streamid = b[3]
+	length = int(pio.U16BE(b[4:]))
+	ptsflags := b[7] >> 6
+	hdrlen := int(b[8])
+	n = 9 + hdrlen
+	if n > len(b) {
+		err = fmt.Errorf("tsio: pes header length %d invalid", hdrlen)
+		return
+	}
+	if length > 0 {
+		if length -= 3 + hdrlen; length < 0 {
+			err = fmt.Errorf("tsio: pes length invalid")
+			return
+		}
+	}
+
+	if ptsflags&0x2 != 0 && hdrlen >= 5 {
+		hasPTS = true
+		pts = readPTS(b[9:])
+		dts = pts
+		if ptsflags&0x1 != 0 && hdrlen >= 10 {
+			dts = readPTS(b[14:])
+		}
+	}
+	return
+}
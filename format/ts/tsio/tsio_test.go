@@ -0,0 +1,85 @@
+package tsio
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFillPSI(t *testing.T) {
+	pat := PAT{Entries: []PATEntry{{ProgramNumber: 1, PID: PMT_PID}}}
+	data := make([]byte, pat.Fill(nil))
+	pat.Fill(data)
+
+	b := make([]byte, FillPSI(nil, TABLE_ID_PAT, 1, 0, data))
+	FillPSI(b, TABLE_ID_PAT, 1, 0, data)
+
+	want := []byte{0x00, 0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xf0, 0x00, 0x2a, 0xb1, 0x04, 0xb2}
+	if !bytes.Equal(b, want) {
+		t.Fatalf("pat % x", b)
+	}
+
+	tableid, ext, version, pdata, err := ParsePSI(b)
+	if err != nil || tableid != TABLE_ID_PAT || ext != 1 || version != 0 || !bytes.Equal(pdata, data) {
+		t.Fatalf("parse pat %v", err)
+	}
+	b[len(b)-1] ^= 1
+	if _, _, _, _, err = ParsePSI(b); err == nil {
+		t.Fatal("crc mismatch not found")
+	}
+}
+
+func TestFillPESHeader(t *testing.T) {
+	tests := []struct {
+		streamid uint8
+		datalen  int
+		pts, dts uint64
+		bytes    []byte
+	}{
+		{
+			STREAM_ID_AUDIO, 100, 90000, 90000,
+			[]byte{0x00, 0x00, 0x01, 0xc0, 0x00, 0x6c, 0x80, 0x80, 0x05, 0x21, 0x00, 0x05, 0xbf, 0x21},
+		},
+		{
+			STREAM_ID_VIDEO, 100, 93600, 90000,
+			[]byte{0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0xc0, 0x0a, 0x31, 0x00, 0x05, 0xdb, 0x41, 0x11, 0x00, 0x05, 0xbf, 0x21},
+		},
+	}
+
+	for _, test := range tests {
+		b := make([]byte, FillPESHeader(nil, test.streamid, test.datalen, test.pts, test.dts))
+		FillPESHeader(b, test.streamid, test.datalen, test.pts, test.dts)
+		if !bytes.Equal(b, test.bytes) {
+			t.Fatalf("pes % x", b)
+		}
+
+		streamid, length, hasPTS, pts, dts, n, err := ParsePESHeader(b)
+		if err != nil || streamid != test.streamid || !hasPTS || pts != test.pts || dts != test.dts || n != len(b) {
+			t.Fatalf("parse pes %v", err)
+		}
+		if streamid == STREAM_ID_AUDIO && length != test.datalen {
+			t.Fatalf("parse pes length %d", length)
+		}
+	}
+}
+
+func TestFillTSHeader(t *testing.T) {
+	b := make([]byte, PacketSize)
+	h := TSHeader{PID: 0x100, PayloadStart: true, CC: 3, RandomAccess: true, HasPCR: true, PCR: 90000}
+	n := FillTSHeader(b, h, 100)
+	if n != PacketSize-100 {
+		t.Fatalf("n %d", n)
+	}
+	want := []byte{0x47, 0x41, 0x00, 0x33, 0x53, 0x50, 0x00, 0x00, 0xaf, 0xc8, 0x7e, 0x00}
+	if !bytes.Equal(b[:len(want)], want) {
+		t.Fatalf("ts % x", b[:len(want)])
+	}
+	ph, _, _, pn, err := ParseTSHeader(b)
+	if err != nil || ph != h || pn != n {
+		t.Fatalf("parse ts %v %+v", err, ph)
+	}
+
+	n = FillTSHeader(b, TSHeader{PID: 0x100}, PacketSize-5)
+	if n != 5 || b[3] != 0x30 || b[4] != 0 {
+		t.Fatalf("ts % x", b[:n])
+	}
+}
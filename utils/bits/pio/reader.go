@@ -24,6 +24,13 @@ func U16BE(b []byte) (i uint16) {
 	return
 }
 
+func U16LE(b []byte) (i uint16) {
+	i = uint16(b[1])
+	i <<= 8
+	i |= uint16(b[0])
+	return
+}
+
 func I16BE(b []byte) (i int16) {
 	i = int16(b[0])
 	i <<= 8
@@ -181,6 +188,18 @@ func ReadU16BE(b []byte, n *int) (v uint16, err error) {
 	return
 }
 
+func ReadU16LE(b []byte, n *int) (v uint16, err error) {
+	if len(b) < *n+2 {
+		err = Error{N: *n}
+		return
+	}
+	if b != nil {
+		v = U16LE(b[*n:])
+	}
+	*n += 2
+	return
+}
+
 func ReadI24BE(b []byte, n *int) (v int32, err error) {
 	if len(b) < *n+3 {
 		err = Error{N: *n}
@@ -217,6 +236,18 @@ func ReadU32BE(b []byte, n *int) (v uint32, err error) {
 	return
 }
 
+func ReadU32LE(b []byte, n *int) (v uint32, err error) {
+	if len(b) < *n+4 {
+		err = Error{N: *n}
+		return
+	}
+	if b != nil {
+		v = U32LE(b[*n:])
+	}
+	*n += 4
+	return
+}
+
 func ReadI32BE(b []byte, n *int) (v int32, err error) {
 	if len(b) < *n+4 {
 		err = Error{N: *n}
@@ -16,6 +16,11 @@ func PutU16BE(b []byte, v uint16) {
 	b[1] = byte(v)
 }
 
+func PutU16LE(b []byte, v uint16) {
+	b[1] = byte(v >> 8)
+	b[0] = byte(v)
+}
+
 func PutI24BE(b []byte, v int32) {
 	b[0] = byte(v >> 16)
 	b[1] = byte(v >> 8)
@@ -112,6 +117,14 @@ func WriteU16BE(b []byte, n *int, v uint16) {
 	return
 }
 
+func WriteU16LE(b []byte, n *int, v uint16) {
+	if b != nil {
+		PutU16LE(b[*n:], v)
+	}
+	*n += 2
+	return
+}
+
 func WriteU24BE(b []byte, n *int, v uint32) {
 	if b != nil {
 		PutU24BE(b[*n:], v)